@@ -3,8 +3,12 @@
 package hummingbird
 
 import (
+	"bufio"
+	"bytes"
 	"io"
+	"os"
 
+	"github.com/klauspost/compress/zstd"
 	"github.com/simagix/gox"
 )
 
@@ -13,8 +17,23 @@ type BSONReader struct {
 	Stream io.ReadCloser
 }
 
-// NewBSONReader returns a bson reader
+// NewBSONReader returns a bson reader of a plain, gzip, snappy, or zstd file
 func NewBSONReader(filename string) (*BSONReader, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	buf, err := bufio.NewReader(file).Peek(4)
+	if err == nil && bytes.Equal(buf, []byte{0x28, 0xb5, 0x2f, 0xfd}) { // zstd magic number
+		file.Seek(0, 0)
+		decoder, err := zstd.NewReader(file)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		return &BSONReader{Stream: decoder.IOReadCloser()}, nil
+	}
+	file.Close()
 	reader, err := gox.NewFileReader(filename)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,318 @@
+// Copyright Kuei-chun Chen, 2022-present. All rights reserved.
+
+package hummingbird
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// ChangeStreamName names the cluster-wide change stream in spooled files and checkpoints
+	ChangeStreamName = "changestream"
+	// StreamChangeStream reads a cluster-wide change stream from source
+	StreamChangeStream = "changestream"
+	// StreamOplog tails local.oplog.rs of every shard/replica
+	StreamOplog = "oplog"
+)
+
+const (
+	// CursorIdle means no oplog is available to read
+	CursorIdle = iota
+	// CursorRead means an oplog is read
+	CursorRead
+	// CursorSkipped means a change event not replayable is read and skipped
+	CursorSkipped
+)
+
+// ChangeStreamMinVersion is the min source version of change streams, of which DDL events are expanded
+const ChangeStreamMinVersion = 6
+
+// ChangeEvent stores a change stream event
+type ChangeEvent struct {
+	ClusterTime          primitive.Timestamp `bson:"clusterTime"`
+	DocumentKey          bson.D              `bson:"documentKey,omitempty"`
+	FullDocument         bson.D              `bson:"fullDocument,omitempty"`
+	ID                   bson.Raw            `bson:"_id"`
+	LSID                 bson.D              `bson:"lsid,omitempty"`
+	Namespace            ChangeNamespace     `bson:"ns"`
+	OperationDescription bson.D              `bson:"operationDescription,omitempty"`
+	OperationType        string              `bson:"operationType"`
+	To                   ChangeNamespace     `bson:"to,omitempty"`
+	TxnNumber            *int64              `bson:"txnNumber,omitempty"`
+	UpdateDescription    *UpdateDescription  `bson:"updateDescription,omitempty"`
+}
+
+// ChangeNamespace stores namespace of a change event
+type ChangeNamespace struct {
+	Collection string `bson:"coll"`
+	Database   string `bson:"db"`
+}
+
+// UpdateDescription stores fields changed by an update event
+type UpdateDescription struct {
+	RemovedFields   []string         `bson:"removedFields"`
+	TruncatedArrays []TruncatedArray `bson:"truncatedArrays,omitempty"`
+	UpdatedFields   bson.D           `bson:"updatedFields"`
+}
+
+// TruncatedArray stores an array truncated by an update event
+type TruncatedArray struct {
+	Field   string `bson:"field"`
+	NewSize int32  `bson:"newSize"`
+}
+
+// OplogCursor reads oplogs from local.oplog.rs or from a change stream converted to oplogs
+type OplogCursor struct {
+	current bson.Raw
+	cursor  *mongo.Cursor
+	pending *ChangeEvent // read after events of a transaction
+	stream  *mongo.Cursor
+	token   bson.Raw
+	txn     []ChangeEvent // events of a transaction being grouped
+}
+
+// GetOplogCursor returns a cursor of local.oplog.rs from a timestamp
+func GetOplogCursor(client *mongo.Client, ts *primitive.Timestamp) (*OplogCursor, error) {
+	cursor, err := GetTailableCursor(client, ts)
+	if err != nil {
+		return nil, err
+	}
+	return &OplogCursor{cursor: cursor}, nil
+}
+
+// GetChangeStreamCursor returns a cursor of a cluster-wide change stream, resumed after a token if
+// given or else started at a timestamp.  The change stream is aggregated with expanded events for DDL events,
+// i.e. create, createIndexes, dropIndexes and modify of collMod.
+func GetChangeStreamCursor(client *mongo.Client, token bson.Raw, ts *primitive.Timestamp) (*OplogCursor, error) {
+	stage := bson.D{{"allChangesForCluster", true}, {"showExpandedEvents", true}}
+	if inst := GetMigratorInstance(); inst != nil && inst.hasFilters() { // post-images are evaluated by filters
+		stage = append(stage, bson.E{Key: "fullDocument", Value: "updateLookup"})
+	}
+	if len(token) > 0 {
+		stage = append(stage, bson.E{Key: "startAfter", Value: token})
+	} else if ts != nil {
+		stage = append(stage, bson.E{Key: "startAtOperationTime", Value: *ts})
+	}
+	opts := options.Aggregate()
+	opts.SetBatchSize(OplogBatchSize)
+	opts.SetMaxAwaitTime(time.Second)
+	pipeline := mongo.Pipeline{{{"$changeStream", stage}}}
+	stream, err := client.Database("admin").Aggregate(context.Background(), pipeline, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &OplogCursor{stream: stream, token: token}, nil
+}
+
+// IsChangeStreamSupported returns true if a source version supports change streams of expanded events
+func IsChangeStreamSupported(version string) bool {
+	major, err := strconv.Atoi(strings.Split(version, ".")[0])
+	return err == nil && major >= ChangeStreamMinVersion
+}
+
+// TryNext reads the next oplog if available, it returns CursorIdle if none, CursorRead if read, or
+// CursorSkipped if a change event not replayable is read.  Events of a transaction are grouped into an
+// applyOps oplog, which is read once an event of another transaction is read or no more events are
+// available.  An error is returned if the cursor fails, an event cannot be decoded, or the change stream is
+// invalidated.
+func (c *OplogCursor) TryNext(ctx context.Context) (int, error) {
+	if c.cursor != nil {
+		if !c.cursor.TryNext(ctx) {
+			return CursorIdle, c.cursor.Err()
+		}
+		c.current = c.cursor.Current
+		return CursorRead, nil
+	}
+	for {
+		var event ChangeEvent
+		if c.pending != nil {
+			event, c.pending = *c.pending, nil
+		} else if !c.stream.TryNext(ctx) {
+			if len(c.txn) > 0 { // all events of a committed transaction are available at once
+				return c.readTxn()
+			}
+			return CursorIdle, c.stream.Err()
+		} else if err := c.stream.Decode(&event); err != nil {
+			return CursorIdle, fmt.Errorf("Decode failed: %v", err)
+		}
+		if event.OperationType == "invalidate" {
+			return CursorIdle, fmt.Errorf("change stream invalidated at %v", event.ClusterTime)
+		} else if len(c.txn) > 0 && !c.txn[0].isSameTxn(event) {
+			c.pending = &event
+			return c.readTxn()
+		} else if event.TxnNumber != nil && len(event.LSID) > 0 {
+			c.txn = append(c.txn, event)
+			continue
+		}
+		c.token = event.ID
+		oplog, ok := event.ToOplog()
+		if !ok {
+			return CursorSkipped, nil
+		}
+		return c.setCurrent(oplog)
+	}
+}
+
+// readTxn sets the current oplog to an applyOps oplog of grouped transaction events
+func (c *OplogCursor) readTxn() (int, error) {
+	events := c.txn
+	c.txn = nil
+	c.token = events[len(events)-1].ID
+	oplog, ok := ToTxnOplog(events)
+	if !ok {
+		return CursorSkipped, nil
+	}
+	return c.setCurrent(oplog)
+}
+
+func (c *OplogCursor) setCurrent(oplog Oplog) (int, error) {
+	data, err := bson.Marshal(oplog)
+	if err != nil {
+		return CursorIdle, fmt.Errorf("Marshal failed: %v", err)
+	}
+	c.current = data
+	return CursorRead, nil
+}
+
+// Current returns the current oplog
+func (c *OplogCursor) Current() bson.Raw {
+	return c.current
+}
+
+// Decode unmarshals the current oplog
+func (c *OplogCursor) Decode(oplog *Oplog) error {
+	return bson.Unmarshal(c.current, oplog)
+}
+
+// ResumeToken returns the resume token of the last event read, nil for oplog cursors
+func (c *OplogCursor) ResumeToken() bson.Raw {
+	return c.token
+}
+
+// ToOplog converts a change event to an oplog, false if the event isn't replayable.  An update with the
+// post-image looked up is converted to a replacement.  Events of a transaction are grouped by ToTxnOplog.
+func (e ChangeEvent) ToOplog() (Oplog, bool) {
+	ns := e.Namespace.Database + "." + e.Namespace.Collection
+	oplog := Oplog{Namespace: ns, Timestamp: e.ClusterTime, Version: 2}
+	switch e.OperationType {
+	case "insert":
+		oplog.Operation = "i"
+		oplog.Object = e.FullDocument
+	case "delete":
+		oplog.Operation = "d"
+		oplog.Object = e.DocumentKey
+	case "replace":
+		oplog.Operation = "u"
+		oplog.Object = e.FullDocument
+		oplog.Query = e.DocumentKey
+	case "update":
+		if len(e.FullDocument) > 0 {
+			oplog.Operation = "u"
+			oplog.Object = e.FullDocument
+			oplog.Query = e.DocumentKey
+			break
+		} else if e.UpdateDescription == nil {
+			return oplog, false
+		}
+		oplog.Operation = "u"
+		oplog.Object = bson.D{{"$v", 2}, {"diff", e.UpdateDescription.getDiff()}}
+		oplog.Query = e.DocumentKey
+	case "drop":
+		oplog.Object = bson.D{{CmdDrop, e.Namespace.Collection}}
+	case "dropDatabase":
+		oplog.Object = bson.D{{CmdDropDatabase, 1}}
+	case "rename":
+		to := e.To
+		dropTarget := false
+		for _, v := range e.OperationDescription {
+			if v.Key == "to" {
+				data, _ := bson.Marshal(v.Value)
+				bson.Unmarshal(data, &to)
+			} else if v.Key == "dropTarget" { // UUID of the dropped target collection
+				dropTarget = true
+			}
+		}
+		oplog.Object = bson.D{{CmdRenameCollection, ns}, {"to", to.Database + "." + to.Collection},
+			{"dropTarget", dropTarget}}
+	case CmdCreate:
+		oplog.Object = append(bson.D{{CmdCreate, e.Namespace.Collection}}, e.OperationDescription...)
+	case "modify":
+		oplog.Object = append(bson.D{{CmdCollMod, e.Namespace.Collection}}, e.OperationDescription...)
+	case CmdCreateIndexes: // as of a two-phase index build
+		indexes, ok := e.OperationDescription.Map()["indexes"].(bson.A)
+		if !ok {
+			return oplog, false
+		}
+		oplog.Object = bson.D{{CmdCommitIndexBuild, e.Namespace.Collection}, {"indexes", indexes}}
+	case CmdDropIndexes:
+		indexes, ok := e.OperationDescription.Map()["indexes"].(bson.A)
+		if !ok {
+			return oplog, false
+		}
+		names := bson.A{}
+		for _, index := range indexes {
+			if spec, ok := index.(bson.D); ok {
+				names = append(names, spec.Map()["name"])
+			}
+		}
+		oplog.Object = bson.D{{CmdDropIndexes, e.Namespace.Collection}, {"index", names}}
+	default: // invalidate and others
+		return oplog, false
+	}
+	if oplog.Operation == "" { // commands
+		oplog.Operation = "c"
+		oplog.Namespace = e.Namespace.Database + ".$cmd"
+	}
+	return oplog, true
+}
+
+// ToTxnOplog converts change events of a transaction to an applyOps oplog with lsid and txnNumber, so that
+// they're applied atomically, false if none of the events is replayable
+func ToTxnOplog(events []ChangeEvent) (Oplog, bool) {
+	last := events[len(events)-1]
+	ops := bson.A{}
+	for _, event := range events {
+		if oplog, ok := event.ToOplog(); ok {
+			ops = append(ops, oplog)
+		}
+	}
+	oplog := Oplog{LSID: last.LSID, Namespace: "admin.$cmd", Object: bson.D{{"applyOps", ops}},
+		Operation: "c", Timestamp: last.ClusterTime, TxnNumber: last.TxnNumber, Version: 2}
+	return oplog, len(ops) > 0
+}
+
+// isSameTxn returns true if an event is of the same transaction
+func (e ChangeEvent) isSameTxn(event ChangeEvent) bool {
+	if e.TxnNumber == nil || event.TxnNumber == nil || *e.TxnNumber != *event.TxnNumber {
+		return false
+	}
+	return GetIDKey(e.LSID) == GetIDKey(event.LSID)
+}
+
+// getDiff returns a $v:2 oplog diff of an update description
+func (u *UpdateDescription) getDiff() bson.D {
+	diff := bson.D{}
+	if len(u.RemovedFields) > 0 {
+		fields := bson.D{}
+		for _, field := range u.RemovedFields {
+			fields = append(fields, bson.E{Key: field, Value: false})
+		}
+		diff = append(diff, bson.E{Key: "d", Value: fields})
+	}
+	if len(u.UpdatedFields) > 0 {
+		diff = append(diff, bson.E{Key: "u", Value: u.UpdatedFields})
+	}
+	for _, array := range u.TruncatedArrays {
+		diff = append(diff, bson.E{Key: "s" + array.Field, Value: bson.D{{"a", true}, {"l", array.NewSize}}})
+	}
+	return diff
+}
@@ -0,0 +1,110 @@
+// Copyright Kuei-chun Chen, 2022-present. All rights reserved.
+
+package hummingbird
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestChangeEventToOplog(t *testing.T) {
+	ts := `"clusterTime": { "$timestamp": { "t": 1650000000, "i": 1 } }`
+	tests := [][]string{
+		{`{ "operationType": "insert", "ns": { "db": "testdb", "coll": "neutrino" }, "documentKey": { "_id": 1 }, "fullDocument": { "_id": 1, "a": 1 }, ` + ts + ` }`,
+			`i`, `testdb.neutrino`, `{"_id":1,"a":1}`},
+		{`{ "operationType": "delete", "ns": { "db": "testdb", "coll": "neutrino" }, "documentKey": { "_id": 1 }, ` + ts + ` }`,
+			`d`, `testdb.neutrino`, `{"_id":1}`},
+		{`{ "operationType": "replace", "ns": { "db": "testdb", "coll": "neutrino" }, "documentKey": { "_id": 1 }, "fullDocument": { "_id": 1, "b": 2 }, ` + ts + ` }`,
+			`u`, `testdb.neutrino`, `{"_id":1,"b":2}`},
+		{`{ "operationType": "update", "ns": { "db": "testdb", "coll": "neutrino" }, "documentKey": { "_id": 1 }, "updateDescription": { "updatedFields": { "a.b": 1 }, "removedFields": [ "c" ], "truncatedArrays": [ { "field": "arr", "newSize": 2 } ] }, ` + ts + ` }`,
+			`u`, `testdb.neutrino`, `{"$v":2,"diff":{"d":{"c":false},"u":{"a.b":1},"sarr":{"a":true,"l":2}}}`},
+		{`{ "operationType": "drop", "ns": { "db": "testdb", "coll": "neutrino" }, ` + ts + ` }`,
+			`c`, `testdb.$cmd`, `{"drop":"neutrino"}`},
+		{`{ "operationType": "dropDatabase", "ns": { "db": "testdb" }, ` + ts + ` }`,
+			`c`, `testdb.$cmd`, `{"dropDatabase":1}`},
+		{`{ "operationType": "rename", "ns": { "db": "testdb", "coll": "neutrino" }, "to": { "db": "testdb", "coll": "renamed" }, ` + ts + ` }`,
+			`c`, `testdb.$cmd`, `{"renameCollection":"testdb.neutrino","to":"testdb.renamed","dropTarget":false}`},
+		{`{ "operationType": "rename", "ns": { "db": "testdb", "coll": "neutrino" }, "to": { "db": "testdb", "coll": "renamed" }, "operationDescription": { "to": { "db": "testdb", "coll": "renamed" }, "dropTarget": { "$binary": { "base64": "AAAAAAAAAAAAAAAAAAAAAA==", "subType": "04" } } }, ` + ts + ` }`,
+			`c`, `testdb.$cmd`, `{"renameCollection":"testdb.neutrino","to":"testdb.renamed","dropTarget":true}`},
+		{`{ "operationType": "create", "ns": { "db": "testdb", "coll": "neutrino" }, "operationDescription": { "capped": true, "size": 4096 }, ` + ts + ` }`,
+			`c`, `testdb.$cmd`, `{"create":"neutrino","capped":true,"size":4096}`},
+		{`{ "operationType": "createIndexes", "ns": { "db": "testdb", "coll": "neutrino" }, "operationDescription": { "indexes": [ { "v": 2, "key": { "a": 1 }, "name": "a_1" } ] }, ` + ts + ` }`,
+			`c`, `testdb.$cmd`, `{"commitIndexBuild":"neutrino","indexes":[{"v":2,"key":{"a":1},"name":"a_1"}]}`},
+		{`{ "operationType": "dropIndexes", "ns": { "db": "testdb", "coll": "neutrino" }, "operationDescription": { "indexes": [ { "v": 2, "key": { "a": 1 }, "name": "a_1" } ] }, ` + ts + ` }`,
+			`c`, `testdb.$cmd`, `{"dropIndexes":"neutrino","index":["a_1"]}`},
+		{`{ "operationType": "modify", "ns": { "db": "testdb", "coll": "neutrino" }, "operationDescription": { "index": { "name": "a_1", "hidden": true } }, ` + ts + ` }`,
+			`c`, `testdb.$cmd`, `{"collMod":"neutrino","index":{"name":"a_1","hidden":true}}`},
+	}
+	for _, test := range tests {
+		var event ChangeEvent
+		err := bson.UnmarshalExtJSON([]byte(test[0]), false, &event)
+		assertEqual(t, nil, err)
+		oplog, ok := event.ToOplog()
+		assertEqual(t, true, ok)
+		assertEqual(t, test[1], oplog.Operation)
+		assertEqual(t, test[2], oplog.Namespace)
+		assertEqual(t, test[3], Stringify(oplog.Object))
+		assertEqual(t, uint32(1650000000), oplog.Timestamp.T)
+	}
+
+	var event ChangeEvent
+	err := bson.UnmarshalExtJSON([]byte(`{ "operationType": "update", "ns": { "db": "testdb", "coll": "neutrino" }, "documentKey": { "_id": 1 }, "fullDocument": { "_id": 1, "a": 2 }, "updateDescription": { "updatedFields": { "a": 2 }, "removedFields": [] }, `+ts+` }`), false, &event)
+	assertEqual(t, nil, err)
+	oplog, ok := event.ToOplog()
+	assertEqual(t, true, ok)
+	assertEqual(t, true, isReplacement(oplog.Object))
+	assertEqual(t, `{"_id":1,"a":2}`, Stringify(oplog.Object))
+
+	event = ChangeEvent{}
+	err = bson.UnmarshalExtJSON([]byte(`{ "operationType": "invalidate", `+ts+` }`), false, &event)
+	assertEqual(t, nil, err)
+	_, ok = event.ToOplog()
+	assertEqual(t, false, ok)
+}
+
+func TestChangeEventWriteModels(t *testing.T) {
+	migratorInstance = &Migrator{included: map[string]*Include{}}
+	doc := `{ "operationType": "update", "ns": { "db": "testdb", "coll": "neutrino" }, "documentKey": { "_id": 1 },
+		"updateDescription": { "updatedFields": { "a": 1 }, "removedFields": [ "b" ], "truncatedArrays": [ { "field": "arr", "newSize": 2 } ] } }`
+	var event ChangeEvent
+	err := bson.UnmarshalExtJSON([]byte(doc), false, &event)
+	assertEqual(t, nil, err)
+	oplog, ok := event.ToOplog()
+	assertEqual(t, true, ok)
+	wmodels := GetWriteModels(oplog)
+	assertEqual(t, 2, len(wmodels))
+	assertEqual(t, int32(1), wmodels[0].ID)
+}
+
+func TestToTxnOplog(t *testing.T) {
+	lsid := `"lsid": { "id": { "$binary": { "base64": "AAAAAAAAAAAAAAAAAAAAAA==", "subType": "04" } } }`
+	docs := []string{`{ "operationType": "insert", "ns": { "db": "testdb", "coll": "neutrino" }, "fullDocument": { "_id": 1 }, "txnNumber": 1, ` + lsid + ` }`,
+		`{ "operationType": "delete", "ns": { "db": "testdb", "coll": "neutrino" }, "documentKey": { "_id": 2 }, "txnNumber": 1, ` + lsid + ` }`,
+		`{ "operationType": "insert", "ns": { "db": "testdb", "coll": "neutrino" }, "fullDocument": { "_id": 3 }, "txnNumber": 2, ` + lsid + ` }`}
+	events := []ChangeEvent{}
+	for _, doc := range docs {
+		var event ChangeEvent
+		err := bson.UnmarshalExtJSON([]byte(doc), false, &event)
+		assertEqual(t, nil, err)
+		events = append(events, event)
+	}
+	assertEqual(t, true, events[0].isSameTxn(events[1]))
+	assertEqual(t, false, events[1].isSameTxn(events[2]))
+
+	oplog, ok := ToTxnOplog(events[:2])
+	assertEqual(t, true, ok)
+	assertEqual(t, true, IsTxnOplog(oplog))
+	assertEqual(t, "admin.$cmd", oplog.Namespace)
+	migratorInstance = &Migrator{included: map[string]*Include{}}
+	wmodels := GetWriteModels(oplog)
+	assertEqual(t, 2, len(wmodels))
+	assertEqual(t, "i", wmodels[0].Operation)
+	assertEqual(t, "d", wmodels[1].Operation)
+}
+
+func TestIsChangeStreamSupported(t *testing.T) {
+	assertEqual(t, false, IsChangeStreamSupported("5.0.9"))
+	assertEqual(t, true, IsChangeStreamSupported("6.0.1"))
+	assertEqual(t, false, IsChangeStreamSupported(""))
+}
@@ -221,10 +221,8 @@ func addShardingConfigs(sourceClient *mongo.Client, targetClient *mongo.Client,
 		if inst.SkipNamespace(config.ID) {
 			continue
 		}
-		ns := config.ID
-		include := inst.Included()[config.ID]
-		if include != nil && include.To != "" {
-			ns = include.To
+		ns := inst.GetToNamespace(config.ID)
+		if ns != config.ID {
 			db, _ := mdb.SplitNamespace(ns)
 			if err = targetClient.Database("admin").RunCommand(ctx, bson.D{{"enableSharding", db}}).Decode(&doc); err != nil {
 				return fmt.Errorf(`enableSharding %v failed`, db)
@@ -274,10 +272,7 @@ func addChunks(sourceClient *mongo.Client, targetClient *mongo.Client, targetSha
 		if inst.SkipNamespace(ns) {
 			continue
 		}
-		qfilter := inst.Included()[ns]
-		if qfilter != nil && qfilter.To != "" {
-			ns = qfilter.To
-		}
+		ns = inst.GetToNamespace(ns)
 		segment := len(arr) / chunksNeeded
 		if len(arr) < chunksNeeded {
 			return fmt.Errorf(`%v does not have enough chunks info to automatically split chunks`, ns)
@@ -0,0 +1,231 @@
+// Copyright Kuei-chun Chen, 2022-present. All rights reserved.
+
+package hummingbird
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/simagix/gox"
+	"github.com/simagix/keyhole/mdb"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// ConflictFail records conflicts and stops migration
+	ConflictFail = "fail"
+	// ConflictIgnore records conflicts and continues
+	ConflictIgnore = "ignore"
+	// ConflictUpsert records conflicts and replaces target documents with source documents
+	ConflictUpsert = "upsert"
+)
+
+const (
+	// ReasonDuplicateKey is a conflict of inserting an existing document
+	ReasonDuplicateKey = "duplicate key"
+	// ReasonNotMatched is a conflict of updating a missing document
+	ReasonNotMatched = "no document matched"
+	// ReasonTxnAborted is a conflict of a write of a transaction failed to apply
+	ReasonTxnAborted = "transaction aborted"
+)
+
+// Conflict stores an unapplied or mismatched operation
+type Conflict struct {
+	Document   interface{}         `bson:"doc,omitempty"`
+	ID         interface{}         `bson:"id"`
+	Namespace  string              `bson:"ns"`
+	Operation  string              `bson:"op"`
+	Reason     string              `bson:"reason"`
+	Resolution string              `bson:"resolution"`
+	Time       time.Time           `bson:"time"`
+	Timestamp  primitive.Timestamp `bson:"ts"`
+}
+
+// NewConflict returns a conflict of a write model with its document, update, or command
+func NewConflict(wmodel OplogWriteModel, reason string) Conflict {
+	return Conflict{Document: getWriteDocument(wmodel), ID: wmodel.ID, Namespace: wmodel.Namespace,
+		Operation: wmodel.Operation, Reason: reason, Time: time.Now(), Timestamp: wmodel.Timestamp}
+}
+
+// getWriteDocument returns the inserted or replacing document, the update, the delete filter, or the
+// command of a write model
+func getWriteDocument(wmodel OplogWriteModel) interface{} {
+	switch op := wmodel.WriteModel.(type) {
+	case *mongo.InsertOneModel:
+		return op.Document
+	case *mongo.ReplaceOneModel:
+		return op.Replacement
+	case *mongo.UpdateOneModel:
+		return op.Update
+	case *mongo.DeleteOneModel:
+		return op.Filter
+	}
+	if len(wmodel.Command) > 0 {
+		return wmodel.Command
+	}
+	return nil
+}
+
+// ResolveConflicts resolves conflicts by the conflict policy, records them, and returns number of
+// conflicts not resolved
+func ResolveConflicts(client *mongo.Client, conflicts []Conflict, wmodels []OplogWriteModel,
+	results *BulkWriteOplogsResult) int {
+	if len(conflicts) == 0 {
+		return 0
+	}
+	inst := GetMigratorInstance()
+	if inst == nil {
+		return len(conflicts)
+	}
+	logger := gox.GetLogger("ResolveConflicts")
+	unresolved := 0
+	for i, conflict := range conflicts {
+		conflicts[i].Resolution = inst.Conflict
+		if inst.Conflict != ConflictUpsert {
+			unresolved++
+			continue
+		}
+		if err := upsertConflict(client, conflict, wmodels[i]); err != nil {
+			logger.Warnf("%v %v upsert failed: %v", conflict.Namespace, conflict.ID, err)
+			conflicts[i].Reason += ", " + err.Error()
+			conflicts[i].Resolution = ConflictIgnore
+			unresolved++
+			continue
+		}
+		results.UpsertedCount++
+	}
+	ws := inst.Workspace()
+	if err := ws.AddConflicts(conflicts); err != nil {
+		logger.Errorf("AddConflicts failed: %v", err)
+	}
+	return unresolved
+}
+
+// upsertConflict replaces a target document with the inserted document or the source document
+func upsertConflict(client *mongo.Client, conflict Conflict, wmodel OplogWriteModel) error {
+	if conflict.ID == nil {
+		return fmt.Errorf("no _id found")
+	}
+	var doc interface{}
+	if insert, ok := wmodel.WriteModel.(*mongo.InsertOneModel); ok {
+		doc = insert.Document
+	} else if wmodel.Operation == "u" {
+		inst := GetMigratorInstance()
+		filter := bson.D{{"_id", conflict.ID}}
+		source, err := getSourceDocument(wmodel.Source, filter, inst.GetInclude(wmodel.Source))
+		if err != nil {
+			return err
+		}
+		doc = source
+	} else {
+		return fmt.Errorf("operation %v cannot be upserted", wmodel.Operation)
+	}
+	dbName, collName := mdb.SplitNamespace(wmodel.Namespace)
+	opts := options.Replace().SetUpsert(true)
+	_, err := client.Database(dbName).Collection(collName).ReplaceOne(context.Background(),
+		bson.D{{"_id", conflict.ID}}, doc, opts)
+	return err
+}
+
+// getSourceDocument returns a masked and transformed document from source
+func getSourceDocument(namespace string, filter bson.D, include *Include) (bson.D, error) {
+	inst := GetMigratorInstance()
+	client, err := GetMongoClient(inst.Source)
+	if err != nil {
+		return nil, fmt.Errorf("GetMongoClient failed: %v", err)
+	}
+	dbName, collName := mdb.SplitNamespace(namespace)
+	query := filter
+	if include != nil && len(include.Filter) > 0 {
+		query = bson.D{{"$and", bson.A{filter, include.Filter}}}
+	}
+	var doc bson.D
+	if err = client.Database(dbName).Collection(collName).FindOne(context.Background(), query).Decode(&doc); err != nil {
+		return nil, err
+	}
+	return processSourceDocument(doc, include)
+}
+
+// getSourceDocuments returns masked and transformed documents of _id values from source, keyed by
+// GetIDKey of _id
+func getSourceDocuments(namespace string, ids []interface{}, include *Include) (map[string]bson.D, error) {
+	inst := GetMigratorInstance()
+	client, err := GetMongoClient(inst.Source)
+	if err != nil {
+		return nil, fmt.Errorf("GetMongoClient failed: %v", err)
+	}
+	ctx := context.Background()
+	dbName, collName := mdb.SplitNamespace(namespace)
+	query := bson.D{{"_id", bson.D{{"$in", ids}}}}
+	if include != nil && len(include.Filter) > 0 {
+		query = bson.D{{"$and", bson.A{query, include.Filter}}}
+	}
+	cursor, err := client.Database(dbName).Collection(collName).Find(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("Find failed: %v", err)
+	}
+	defer cursor.Close(ctx)
+	docs := map[string]bson.D{}
+	for cursor.Next(ctx) {
+		var doc bson.D
+		if err = cursor.Decode(&doc); err != nil {
+			return nil, fmt.Errorf("Decode failed: %v", err)
+		}
+		id := doc.Map()["_id"]
+		if docs[GetIDKey(id)], err = processSourceDocument(doc, include); err != nil {
+			return nil, err
+		}
+	}
+	return docs, cursor.Err()
+}
+
+// processSourceDocument returns a document masked and transformed by an include
+func processSourceDocument(doc bson.D, include *Include) (bson.D, error) {
+	var err error
+	if include != nil && len(include.Masks) > 0 {
+		MaskFields(&doc, include.Masks, include.Method)
+	}
+	if include != nil && len(include.Transforms) > 0 {
+		if doc, err = TransformDocument(doc, include.Transforms); err != nil {
+			return nil, fmt.Errorf("TransformDocument failed: %v", err)
+		}
+	}
+	return doc, nil
+}
+
+// getWriteErrors returns write errors by indexes, all writes fail if it isn't a bulk write exception
+func getWriteErrors(err error, n int) map[int]mongo.WriteError {
+	errs := map[int]mongo.WriteError{}
+	if err == nil {
+		return errs
+	}
+	if bwe, ok := err.(mongo.BulkWriteException); ok && len(bwe.WriteErrors) > 0 {
+		for _, we := range bwe.WriteErrors {
+			errs[we.Index] = we.WriteError
+		}
+		return errs
+	}
+	for i := 0; i < n; i++ {
+		errs[i] = mongo.WriteError{Index: i, Code: mdb.GetErrorCode(err), Message: err.Error()}
+	}
+	return errs
+}
+
+// isWriteError returns true if an error is of writes of documents, i.e. duplicate keys, not of the
+// server or the network
+func isWriteError(err error) bool {
+	bwe, ok := err.(mongo.BulkWriteException)
+	return ok && len(bwe.WriteErrors) > 0
+}
+
+// getConflictReason returns reason of a write error
+func getConflictReason(we mongo.WriteError) string {
+	if we.Code == 11000 {
+		return ReasonDuplicateKey
+	}
+	return we.Message
+}
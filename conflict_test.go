@@ -0,0 +1,54 @@
+// Copyright Kuei-chun Chen, 2022-present. All rights reserved.
+
+package hummingbird
+
+import (
+	"errors"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestGetWriteErrors(t *testing.T) {
+	errs := getWriteErrors(nil, 3)
+	assertEqual(t, 0, len(errs))
+
+	bwe := mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{
+		{WriteError: mongo.WriteError{Index: 1, Code: 11000, Message: "E11000 duplicate key error"}},
+		{WriteError: mongo.WriteError{Index: 2, Code: 11000, Message: "E11000 duplicate key error"}},
+	}}
+	errs = getWriteErrors(bwe, 3)
+	assertEqual(t, 2, len(errs))
+	assertEqual(t, ReasonDuplicateKey, getConflictReason(errs[1]))
+	assertEqual(t, ReasonDuplicateKey, getConflictReason(errs[2]))
+
+	assertEqual(t, true, isWriteError(bwe))
+	assertEqual(t, false, isWriteError(errors.New("connection reset")))
+	assertEqual(t, false, isWriteError(mongo.BulkWriteException{}))
+
+	errs = getWriteErrors(errors.New("connection reset"), 3)
+	assertEqual(t, 3, len(errs))
+	assertEqual(t, "connection reset", getConflictReason(errs[0]))
+}
+
+func TestNewConflict(t *testing.T) {
+	ts := primitive.Timestamp{T: 1650000000, I: 1}
+	wmodel := OplogWriteModel{ID: int32(1), Namespace: "testdb.neutrino", Operation: "i", Timestamp: ts,
+		WriteModel: mongo.NewInsertOneModel().SetDocument(bson.D{{"_id", int32(1)}})}
+	conflict := NewConflict(wmodel, ReasonDuplicateKey)
+	assertEqual(t, int32(1), conflict.ID)
+	assertEqual(t, "testdb.neutrino", conflict.Namespace)
+	assertEqual(t, "i", conflict.Operation)
+	assertEqual(t, ReasonDuplicateKey, conflict.Reason)
+	assertEqual(t, ts, conflict.Timestamp)
+	assertEqual(t, `{"_id":1}`, Stringify(conflict.Document))
+
+	update := bson.D{{"$set", bson.D{{"a", 1}}}}
+	wmodel = OplogWriteModel{ID: int32(1), Namespace: "testdb.neutrino", Operation: "u",
+		WriteModel: mongo.NewUpdateOneModel().SetFilter(bson.D{{"_id", int32(1)}}).SetUpdate(update)}
+	assertEqual(t, `{"$set":{"a":1}}`, Stringify(NewConflict(wmodel, ReasonNotMatched).Document))
+	wmodel = OplogWriteModel{Namespace: "testdb.$cmd", Operation: "c", Command: bson.D{{"drop", "neutrino"}}}
+	assertEqual(t, `{"drop":"neutrino"}`, Stringify(NewConflict(wmodel, "failed").Document))
+}
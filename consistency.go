@@ -0,0 +1,274 @@
+// Copyright Kuei-chun Chen, 2022-present. All rights reserved.
+
+package hummingbird
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/simagix/gox"
+	"github.com/simagix/keyhole/mdb"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// ConsistencyCheckInterval is the interval of checking sampled documents
+	ConsistencyCheckInterval = 10 * time.Second
+	// ConsistencyMaxPending is the max number of sampled documents waiting to be applied
+	ConsistencyMaxPending = 100
+	// ConsistencyRetries is the number of rechecks of a document changed again before counted diverged
+	ConsistencyRetries = 3
+	// ConsistencySamples is the number of documents sampled from oplogs of an interval
+	ConsistencySamples = 10
+	// ConsistencyWindow is the number of recent checks of a namespace the divergence rate is of
+	ConsistencyWindow = 100
+)
+
+// ConsistencySample is a document modified by an oplog
+type ConsistencySample struct {
+	ID        interface{}
+	Namespace string
+	Retries   int
+	SetName   string
+	Time      time.Time
+	Timestamp primitive.Timestamp
+}
+
+// ConsistencyStats stores checks of sampled documents of a namespace
+type ConsistencyStats struct {
+	Checked     int64     `bson:"checked" json:"checked"`
+	Diverged    int64     `bson:"diverged" json:"diverged"`
+	Namespace   string    `bson:"_id" json:"ns"`
+	Rate        float64   `bson:"rate" json:"rate"`
+	UpdatedTime time.Time `bson:"updated_time" json:"updated_time"`
+
+	window []bool
+}
+
+// ConsistencyChecker compares documents sampled from live streamed oplogs of source and target
+type ConsistencyChecker struct {
+	mutex   sync.Mutex
+	pending []ConsistencySample
+	samples []ConsistencySample
+	seen    int
+	stats   map[string]*ConsistencyStats
+}
+
+// NewConsistencyChecker returns a consistency checker
+func NewConsistencyChecker() *ConsistencyChecker {
+	return &ConsistencyChecker{stats: map[string]*ConsistencyStats{}}
+}
+
+// Sample samples documents modified by oplogs, a fixed number of them of an interval are kept at random
+func (p *ConsistencyChecker) Sample(setName string, oplogs []Oplog) {
+	inst := GetMigratorInstance()
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, oplog := range oplogs {
+		if oplog.Operation != "i" && oplog.Operation != "u" && oplog.Operation != "d" {
+			continue
+		}
+		id := getOplogID(oplog)
+		if id == nil {
+			continue
+		}
+		if include := inst.GetInclude(oplog.Namespace); include != nil && include.Limit > 0 {
+			continue // only a sampled subset is copied
+		}
+		p.seen++
+		sample := ConsistencySample{ID: id, Namespace: oplog.Namespace, SetName: setName, Time: time.Now(),
+			Timestamp: oplog.Timestamp}
+		if len(p.samples) < ConsistencySamples {
+			p.samples = append(p.samples, sample)
+		} else if i := rand.Intn(p.seen); i < ConsistencySamples { // reservoir sampling
+			p.samples[i] = sample
+		}
+	}
+}
+
+// Run checks sampled documents once applied to target until the context is canceled
+func (p *ConsistencyChecker) Run(ctx context.Context) {
+	logger := gox.GetLogger("ConsistencyChecker")
+	for sleepWithContext(ctx, ConsistencyCheckInterval) {
+		p.mutex.Lock()
+		p.pending = append(p.pending, p.samples...)
+		if len(p.pending) > ConsistencyMaxPending {
+			p.pending = p.pending[len(p.pending)-ConsistencyMaxPending:]
+		}
+		p.samples = nil
+		p.seen = 0
+		pending := p.pending
+		p.pending = nil
+		p.mutex.Unlock()
+
+		var waiting []ConsistencySample
+		updated := map[string]bool{}
+		for _, sample := range pending {
+			if !isSampleApplied(sample) {
+				waiting = append(waiting, sample)
+				continue
+			}
+			matched, err := p.check(ctx, sample)
+			if err != nil {
+				if ctx.Err() == nil {
+					logger.Warnf("%v %v check failed: %v", sample.Namespace, sample.ID, err)
+				}
+				continue
+			}
+			if !matched && sample.Retries < ConsistencyRetries { // source may have changed since, recheck
+				sample.Retries++
+				sample.Time = time.Now()
+				sample.Timestamp = primitive.Timestamp{T: uint32(sample.Time.Unix())}
+				waiting = append(waiting, sample)
+				continue
+			}
+			if !matched {
+				logger.Warnf("%v %v diverged", sample.Namespace, sample.ID)
+			}
+			p.addResult(sample.Namespace, matched)
+			updated[sample.Namespace] = true
+		}
+		p.mutex.Lock()
+		p.pending = append(waiting, p.pending...)
+		p.mutex.Unlock()
+		ws := GetMigratorInstance().Workspace()
+		for ns := range updated {
+			if err := ws.SaveConsistencyStats(p.GetStats(ns)); err != nil {
+				logger.Errorf("SaveConsistencyStats failed: %v", err)
+			}
+		}
+	}
+}
+
+// isSampleApplied returns true if the stream of a sample has applied oplogs beyond it
+func isSampleApplied(sample ConsistencySample) bool {
+	for _, streamer := range GetMigratorInstance().Streamers() {
+		if streamer.SetName == sample.SetName {
+			return streamer.IsDrained(sample.Timestamp, sample.Time)
+		}
+	}
+	return false
+}
+
+// check returns true if a sampled document of source, masked and transformed, is the same as of target
+func (p *ConsistencyChecker) check(ctx context.Context, sample ConsistencySample) (bool, error) {
+	inst := GetMigratorInstance()
+	include := inst.GetInclude(sample.Namespace)
+	filter := bson.D{{"_id", sample.ID}}
+	source, err := getSourceDocument(sample.Namespace, filter, include)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return false, fmt.Errorf("getSourceDocument failed: %v", err)
+	}
+	client, err := GetMongoClient(inst.Target)
+	if err != nil {
+		return false, fmt.Errorf("GetMongoClient failed: %v", err)
+	}
+	dbName, collName := mdb.SplitNamespace(inst.GetToNamespace(sample.Namespace))
+	var target bson.D
+	err = client.Database(dbName).Collection(collName).FindOne(ctx, filter).Decode(&target)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return false, fmt.Errorf("FindOne failed: %v", err)
+	}
+	if source == nil || target == nil {
+		return source == nil && target == nil, nil
+	} else if include != nil && len(include.Masks) > 0 && include.Method == MaskHEX { // masked randomly
+		return true, nil
+	}
+	return isSameDocument(source, target)
+}
+
+// isSameDocument compares two documents byte by byte after sorting fields, so that documents of the same
+// fields in different orders, i.e. reordered by an update or a transform, are the same
+func isSameDocument(source bson.D, target bson.D) (bool, error) {
+	s, err := bson.Marshal(sortFields(source))
+	if err != nil {
+		return false, err
+	}
+	t, err := bson.Marshal(sortFields(target))
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(s, t), nil
+}
+
+// sortFields returns a copy of a value of which fields of documents are sorted recursively, arrays keep
+// the order of elements
+func sortFields(value interface{}) interface{} {
+	switch v := value.(type) {
+	case bson.D:
+		doc := bson.D{}
+		for _, elem := range v {
+			doc = append(doc, bson.E{Key: elem.Key, Value: sortFields(elem.Value)})
+		}
+		sort.SliceStable(doc, func(i, j int) bool { return doc[i].Key < doc[j].Key })
+		return doc
+	case bson.A:
+		arr := bson.A{}
+		for _, elem := range v {
+			arr = append(arr, sortFields(elem))
+		}
+		return arr
+	}
+	return value
+}
+
+// addResult adds a check result to the rolling window of a namespace
+func (p *ConsistencyChecker) addResult(ns string, matched bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	stats := p.stats[ns]
+	if stats == nil {
+		stats = &ConsistencyStats{Namespace: ns}
+		p.stats[ns] = stats
+	}
+	stats.Checked++
+	if !matched {
+		stats.Diverged++
+	}
+	stats.window = append(stats.window, !matched)
+	if len(stats.window) > ConsistencyWindow {
+		stats.window = stats.window[1:]
+	}
+	diverged := 0
+	for _, v := range stats.window {
+		if v {
+			diverged++
+		}
+	}
+	stats.Rate = float64(diverged) / float64(len(stats.window))
+	stats.UpdatedTime = time.Now()
+}
+
+// GetStats returns a copy of stats of a namespace
+func (p *ConsistencyChecker) GetStats(ns string) ConsistencyStats {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if stats := p.stats[ns]; stats != nil {
+		return ConsistencyStats{Checked: stats.Checked, Diverged: stats.Diverged, Namespace: ns, Rate: stats.Rate,
+			UpdatedTime: stats.UpdatedTime}
+	}
+	return ConsistencyStats{Namespace: ns}
+}
+
+// GetAllStats returns copies of stats of all namespaces checked, sorted by namespaces
+func (p *ConsistencyChecker) GetAllStats() []ConsistencyStats {
+	p.mutex.Lock()
+	namespaces := []string{}
+	for ns := range p.stats {
+		namespaces = append(namespaces, ns)
+	}
+	p.mutex.Unlock()
+	sort.Strings(namespaces)
+	all := []ConsistencyStats{}
+	for _, ns := range namespaces {
+		all = append(all, p.GetStats(ns))
+	}
+	return all
+}
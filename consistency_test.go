@@ -0,0 +1,70 @@
+// Copyright Kuei-chun Chen, 2022-present. All rights reserved.
+
+package hummingbird
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestConsistencySample(t *testing.T) {
+	filename := "testdata/config.json"
+	inst, err := NewMigratorInstance(filename)
+	assertEqual(t, nil, err)
+	inst.ResetIncludesTo(nil)
+	checker := NewConsistencyChecker()
+	oplogs := []Oplog{}
+	for i := 0; i < 50; i++ {
+		oplogs = append(oplogs, Oplog{Namespace: TestNS, Object: bson.D{{"_id", i}}, Operation: "i",
+			Timestamp: primitive.Timestamp{T: 1650000000, I: uint32(i)}})
+	}
+	oplogs = append(oplogs, Oplog{Namespace: "admin.$cmd", Operation: "c"}, Oplog{Operation: "n"})
+	checker.Sample("replset", oplogs)
+	assertEqual(t, 50, checker.seen)
+	assertEqual(t, ConsistencySamples, len(checker.samples))
+	for _, sample := range checker.samples {
+		assertEqual(t, "replset", sample.SetName)
+		assertEqual(t, TestNS, sample.Namespace)
+	}
+}
+
+func TestConsistencyStats(t *testing.T) {
+	checker := NewConsistencyChecker()
+	for i := 0; i < ConsistencyWindow; i++ {
+		checker.addResult(TestNS, i%10 != 0)
+	}
+	stats := checker.GetStats(TestNS)
+	assertEqual(t, int64(ConsistencyWindow), stats.Checked)
+	assertEqual(t, int64(10), stats.Diverged)
+	assertEqual(t, 0.1, stats.Rate)
+
+	for i := 0; i < ConsistencyWindow; i++ { // rolls out divergences
+		checker.addResult(TestNS, true)
+	}
+	stats = checker.GetStats(TestNS)
+	assertEqual(t, int64(10), stats.Diverged)
+	assertEqual(t, 0.0, stats.Rate)
+	assertEqual(t, 1, len(checker.GetAllStats()))
+}
+
+func TestIsSameDocument(t *testing.T) {
+	same, err := isSameDocument(bson.D{{"_id", 1}, {"a", "x"}}, bson.D{{"_id", 1}, {"a", "x"}})
+	assertEqual(t, nil, err)
+	assertEqual(t, true, same)
+	same, err = isSameDocument(bson.D{{"_id", 1}, {"a", "x"}}, bson.D{{"_id", 1}, {"a", "y"}})
+	assertEqual(t, nil, err)
+	assertEqual(t, false, same)
+
+	source := bson.D{{"_id", 1}, {"a", bson.D{{"b", 1}, {"c", 2}}}, {"d", bson.A{bson.D{{"e", 1}, {"f", 2}}, 3}}}
+	target := bson.D{{"d", bson.A{bson.D{{"f", 2}, {"e", 1}}, 3}}, {"_id", 1}, {"a", bson.D{{"c", 2}, {"b", 1}}}}
+	same, err = isSameDocument(source, target)
+	assertEqual(t, nil, err)
+	assertEqual(t, true, same)
+	assertEqual(t, "_id", source[0].Key) // not sorted in place
+	target = bson.D{{"d", bson.A{3, bson.D{{"e", 1}, {"f", 2}}}}, {"_id", 1}, {"a", bson.D{{"b", 1}, {"c", 2}}}}
+	same, err = isSameDocument(source, target)
+	assertEqual(t, nil, err)
+	assertEqual(t, false, same)
+}
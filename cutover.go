@@ -0,0 +1,406 @@
+// Copyright Kuei-chun Chen, 2022-present. All rights reserved.
+
+package hummingbird
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/simagix/gox"
+	"github.com/simagix/keyhole/mdb"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// CutoverCheckInterval is the interval of checking cutover requests and progress
+	CutoverCheckInterval = 5 * time.Second
+	// CutoverLag is the default lag threshold in seconds of all streams before cutover
+	CutoverLag = 5
+	// CutoverLockFsync locks source replicas with fsyncLock
+	CutoverLockFsync = "fsync"
+	// CutoverLockNone leaves source writable, applications are expected to stop writing
+	CutoverLockNone = "none"
+	// CutoverLockRole revokes roles from source users
+	CutoverLockRole = "role"
+	// CutoverRequestTimeout is the max time waiting for a running migration to pick up a request
+	CutoverRequestTimeout = time.Minute
+)
+
+const (
+	// CutoverCompleted means streaming stopped after data verified
+	CutoverCompleted = "completed"
+	// CutoverDraining means waiting for oplogs up to final timestamps to be applied
+	CutoverDraining = "draining"
+	// CutoverFailed means cutover stopped and streaming continues
+	CutoverFailed = "failed"
+	// CutoverLocking means stopping writes to source
+	CutoverLocking = "locking"
+	// CutoverRequested means waiting for a running migration to cut over
+	CutoverRequested = "requested"
+	// CutoverVerifying means comparing source and target
+	CutoverVerifying = "verifying"
+	// CutoverWaiting means waiting for lags of all streams under the threshold
+	CutoverWaiting = "waiting"
+)
+
+// CutoverOptions stores cutover configurations
+type CutoverOptions struct {
+	Lag    int             `bson:"lag,omitempty"`
+	Lock   string          `bson:"lock,omitempty"`
+	Revoke []CutoverRevoke `bson:"revoke,omitempty"`
+}
+
+// CutoverRevoke stores roles of a source user to revoke with the role lock
+type CutoverRevoke struct {
+	DB    string        `bson:"db"`
+	Roles []interface{} `bson:"roles"`
+	User  string        `bson:"user"`
+}
+
+// CutoverReport stores progress and results of a cutover
+type CutoverReport struct {
+	BeginTime     time.Time                      `bson:"begin_time" json:"begin_time"`
+	Counts        []NamespaceCount               `bson:"counts" json:"counts"`
+	EndTime       time.Time                      `bson:"end_time" json:"end_time"`
+	ID            string                         `bson:"_id" json:"-"`
+	Lock          string                         `bson:"lock" json:"lock"`
+	Message       string                         `bson:"message,omitempty" json:"message,omitempty"`
+	Mismatches    int                            `bson:"mismatches" json:"mismatches"`
+	Positions     map[string]primitive.Timestamp `bson:"positions" json:"positions"`
+	RequestedTime time.Time                      `bson:"requested_time" json:"requested_time"`
+	Status        string                         `bson:"status" json:"status"`
+}
+
+// NamespaceCount stores document counts of a namespace of source and target
+type NamespaceCount struct {
+	Namespace string `bson:"ns" json:"ns"`
+	Source    int64  `bson:"source" json:"source"`
+	Target    int64  `bson:"target" json:"target"`
+}
+
+// ValidateCutoverOptions sets defaults and validates cutover configurations
+func ValidateCutoverOptions(opts *CutoverOptions) error {
+	if opts.Lag <= 0 {
+		opts.Lag = CutoverLag
+	}
+	if opts.Lock == "" {
+		opts.Lock = CutoverLockNone
+	} else if opts.Lock != CutoverLockFsync && opts.Lock != CutoverLockNone && opts.Lock != CutoverLockRole {
+		return fmt.Errorf(`cutover lock must be one of %v, %v, or %v`, CutoverLockFsync, CutoverLockNone, CutoverLockRole)
+	}
+	if opts.Lock == CutoverLockRole && len(opts.Revoke) == 0 {
+		return fmt.Errorf(`cutover lock %v requires users and roles to "revoke"`, CutoverLockRole)
+	}
+	for _, revoke := range opts.Revoke {
+		if revoke.User == "" || revoke.DB == "" || len(revoke.Roles) == 0 {
+			return fmt.Errorf(`cutover "revoke" requires "user", "db", and "roles"`)
+		}
+	}
+	return nil
+}
+
+// RequestCutover requests a running migration to cut over and waits for the report
+func RequestCutover(filename string) error {
+	logger := gox.GetLogger("RequestCutover")
+	inst, err := NewMigratorInstance(filename)
+	if err != nil {
+		return fmt.Errorf("NewMigratorInstance failed: %v", err)
+	}
+	ws := inst.Workspace()
+	if err = ws.RequestCutover(); err != nil {
+		return fmt.Errorf("RequestCutover failed: %v", err)
+	}
+	logger.Remark("cutover requested")
+	requested := time.Now()
+	status := CutoverRequested
+	for {
+		time.Sleep(CutoverCheckInterval)
+		report, err := ws.GetCutoverReport()
+		if err != nil {
+			return fmt.Errorf("GetCutoverReport failed: %v", err)
+		}
+		if report.Status == CutoverRequested && time.Since(requested) > CutoverRequestTimeout {
+			return fmt.Errorf("no running migration picked up the request in %v", CutoverRequestTimeout)
+		}
+		if report.Status != status {
+			status = report.Status
+			logger.Infof("cutover %v", status)
+		}
+		if status == CutoverCompleted || status == CutoverFailed {
+			data, _ := json.MarshalIndent(report, "", "  ")
+			logger.Info(string(data))
+			if status == CutoverFailed {
+				return fmt.Errorf("cutover failed: %v", report.Message)
+			}
+			return nil
+		}
+	}
+}
+
+// WatchCutover cuts over once requested and calls stop to stop streaming after a successful cutover
+func WatchCutover(ctx context.Context, stop context.CancelFunc) {
+	logger := gox.GetLogger("WatchCutover")
+	ws := GetMigratorInstance().Workspace()
+	for sleepWithContext(ctx, CutoverCheckInterval) {
+		report, err := ws.GetCutoverReport()
+		if err != nil || report.Status != CutoverRequested {
+			continue
+		}
+		if err = Cutover(ctx, report); err != nil {
+			logger.Errorf("cutover failed: %v", err)
+			report.Status = CutoverFailed
+			report.Message = err.Error()
+			report.EndTime = time.Now()
+			ws.SaveCutoverReport(report)
+			continue
+		}
+		logger.Remark("cutover completed, stop streaming")
+		stop()
+		return
+	}
+}
+
+// Cutover waits for lags of all streams under the threshold, stops writes to source, drains oplogs
+// to final timestamps, and verifies hashes of copied ranges and document counts.  Writes to source are
+// resumed if it fails after stopping them.
+func Cutover(ctx context.Context, report *CutoverReport) (err error) {
+	inst := GetMigratorInstance()
+	logger := gox.GetLogger("Cutover")
+	ws := inst.Workspace()
+	opts := inst.Cutover
+	if opts == nil {
+		opts = &CutoverOptions{}
+		ValidateCutoverOptions(opts)
+	}
+	report.BeginTime = time.Now()
+	report.Lock = opts.Lock
+	setStatus := func(status string) error {
+		report.Status = status
+		msg := fmt.Sprintf("cutover %v", status)
+		logger.Remark(msg)
+		ws.Log(msg)
+		return ws.SaveCutoverReport(report)
+	}
+
+	if err := setStatus(CutoverWaiting); err != nil {
+		return fmt.Errorf("SaveCutoverReport failed: %v", err)
+	}
+	threshold := time.Duration(opts.Lag) * time.Second
+	for !isStreamersReady(threshold) {
+		if !sleepWithContext(ctx, time.Second) {
+			return ctx.Err()
+		}
+	}
+
+	setStatus(CutoverLocking)
+	unlock, err := lockSource(opts)
+	if err != nil {
+		return fmt.Errorf("lock source failed: %v", err)
+	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		logger.Warnf("cutover failed, unlock source: %v", err)
+		if uerr := unlock(); uerr != nil {
+			logger.Errorf("unlock source failed: %v", uerr)
+		}
+	}()
+	finalTime := time.Now()
+	positions, err := getFinalPositions(finalTime)
+	if err != nil {
+		return fmt.Errorf("getFinalPositions failed: %v", err)
+	}
+	report.Positions = positions
+
+	setStatus(CutoverDraining)
+	for !isStreamersDrained(positions, finalTime) {
+		if !sleepWithContext(ctx, time.Second) {
+			return ctx.Err()
+		}
+	}
+
+	setStatus(CutoverVerifying)
+	tasks, err := ws.FindCompletedSubTasks()
+	if err != nil {
+		return fmt.Errorf("FindCompletedSubTasks failed: %v", err)
+	}
+	if err = ws.DropMismatches(); err != nil {
+		return fmt.Errorf("DropMismatches failed: %v", err)
+	}
+	logger.Infof("verify %v range(s)", len(tasks))
+	mismatched, err := VerifyTasks(ctx, tasks)
+	if err != nil {
+		return fmt.Errorf("VerifyTasks failed: %v", err)
+	} else if report.Mismatches = len(mismatched); report.Mismatches > 0 {
+		return fmt.Errorf("%v of %v range(s) mismatched, see %v.%v", report.Mismatches, len(tasks),
+			MetaDBName, MetaMismatches)
+	}
+	if report.Counts, err = CountNamespaces(); err != nil {
+		return fmt.Errorf("CountNamespaces failed: %v", err)
+	}
+	for _, count := range report.Counts {
+		if count.Source != count.Target {
+			return fmt.Errorf("%v has %v document(s) in source but %v in target", count.Namespace,
+				count.Source, count.Target)
+		}
+	}
+	report.EndTime = time.Now()
+	return setStatus(CutoverCompleted)
+}
+
+// isStreamersReady returns true if all streams are live streaming with lags under a threshold
+func isStreamersReady(threshold time.Duration) bool {
+	streamers := GetMigratorInstance().Streamers()
+	if len(streamers) == 0 {
+		return false
+	}
+	for _, streamer := range streamers {
+		if streamer.IsCache() || streamer.GetLag() > threshold {
+			return false
+		}
+	}
+	return true
+}
+
+// isStreamersDrained returns true if all streams applied oplogs up to their final positions
+func isStreamersDrained(positions map[string]primitive.Timestamp, finalTime time.Time) bool {
+	for _, streamer := range GetMigratorInstance().Streamers() {
+		if !streamer.IsDrained(positions[streamer.SetName], finalTime) {
+			return false
+		}
+	}
+	return true
+}
+
+// lockSource stops writes to source by fsyncLock of all replicas or revoking roles from users, it returns
+// a function resuming writes.  Replicas locked or roles revoked are undone if it fails.
+func lockSource(opts *CutoverOptions) (func() error, error) {
+	inst := GetMigratorInstance()
+	ctx := context.Background()
+	undos := []func() error{}
+	unlock := func() error {
+		var err error
+		for i := len(undos) - 1; i >= 0; i-- {
+			if uerr := undos[i](); uerr != nil && err == nil {
+				err = uerr
+			}
+		}
+		return err
+	}
+	rollback := func(err error) (func() error, error) {
+		if uerr := unlock(); uerr != nil {
+			return nil, fmt.Errorf("%v, and undo failed: %v", err, uerr)
+		}
+		return nil, err
+	}
+	if opts.Lock == CutoverLockFsync {
+		for setName, replica := range inst.Replicas() {
+			client, err := GetMongoClient(replica)
+			if err != nil {
+				return rollback(fmt.Errorf("GetMongoClient failed: %v", err))
+			}
+			if err = client.Database("admin").RunCommand(ctx, bson.D{{"fsync", 1}, {"lock", true}}).Err(); err != nil {
+				return rollback(fmt.Errorf("%v fsyncLock failed: %v", setName, err))
+			}
+			setName := setName
+			undos = append(undos, func() error {
+				if err := client.Database("admin").RunCommand(ctx, bson.D{{"fsyncUnlock", 1}}).Err(); err != nil {
+					return fmt.Errorf("%v fsyncUnlock failed: %v", setName, err)
+				}
+				return nil
+			})
+		}
+	} else if opts.Lock == CutoverLockRole {
+		client, err := GetMongoClient(inst.Source)
+		if err != nil {
+			return nil, fmt.Errorf("GetMongoClient failed: %v", err)
+		}
+		for _, revoke := range opts.Revoke {
+			cmd := bson.D{{"revokeRolesFromUser", revoke.User}, {"roles", revoke.Roles}}
+			if err = client.Database(revoke.DB).RunCommand(ctx, cmd).Err(); err != nil {
+				return rollback(fmt.Errorf("revoke roles from %v failed: %v", revoke.User, err))
+			}
+			revoke := revoke
+			undos = append(undos, func() error {
+				cmd := bson.D{{"grantRolesToUser", revoke.User}, {"roles", revoke.Roles}}
+				if err := client.Database(revoke.DB).RunCommand(ctx, cmd).Err(); err != nil {
+					return fmt.Errorf("grant roles to %v failed: %v", revoke.User, err)
+				}
+				return nil
+			})
+		}
+	}
+	return unlock, nil
+}
+
+// getFinalPositions returns the last oplog timestamps of all streams after writes stopped, the time of
+// the final call for change streams
+func getFinalPositions(finalTime time.Time) (map[string]primitive.Timestamp, error) {
+	inst := GetMigratorInstance()
+	positions := map[string]primitive.Timestamp{}
+	for setName, uri := range inst.Streams() {
+		if inst.Stream == StreamChangeStream {
+			positions[setName] = primitive.Timestamp{T: uint32(finalTime.Unix())}
+			continue
+		}
+		client, err := GetMongoClient(uri)
+		if err != nil {
+			return nil, fmt.Errorf("GetMongoClient failed: %v", err)
+		}
+		window, err := GetOplogWindow(client, setName)
+		if err != nil {
+			return nil, fmt.Errorf("%v GetOplogWindow failed: %v", setName, err)
+		}
+		positions[setName] = window.Last
+	}
+	return positions, nil
+}
+
+// CountNamespaces returns document counts of qualified namespaces of source and target, sampled
+// namespaces are excluded
+func CountNamespaces() ([]NamespaceCount, error) {
+	inst := GetMigratorInstance()
+	ctx := context.Background()
+	source, err := GetMongoClient(inst.Source)
+	if err != nil {
+		return nil, fmt.Errorf("GetMongoClient failed: %v", err)
+	}
+	target, err := GetMongoClient(inst.Target)
+	if err != nil {
+		return nil, fmt.Errorf("GetMongoClient failed: %v", err)
+	}
+	namespaces, err := GetQualifiedNamespaces(source, true, MetaDBName)
+	if err != nil {
+		return nil, fmt.Errorf("GetQualifiedNamespaces failed: %v", err)
+	}
+	counts := []NamespaceCount{}
+	for _, ns := range namespaces {
+		if inst.SkipNamespace(ns) {
+			continue
+		}
+		query := bson.D{}
+		if include := inst.GetInclude(ns); include != nil {
+			if include.Limit > 0 {
+				continue
+			}
+			if len(include.Filter) > 0 {
+				query = include.Filter
+			}
+		}
+		count := NamespaceCount{Namespace: ns}
+		dbName, collName := mdb.SplitNamespace(ns)
+		if count.Source, err = source.Database(dbName).Collection(collName).CountDocuments(ctx, query); err != nil {
+			return nil, fmt.Errorf("%v CountDocuments failed: %v", ns, err)
+		}
+		dbName, collName = mdb.SplitNamespace(inst.GetToNamespace(ns))
+		if count.Target, err = target.Database(dbName).Collection(collName).CountDocuments(ctx, bson.D{}); err != nil {
+			return nil, fmt.Errorf("%v CountDocuments failed: %v", ns, err)
+		}
+		counts = append(counts, count)
+	}
+	return counts, nil
+}
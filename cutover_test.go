@@ -0,0 +1,67 @@
+// Copyright Kuei-chun Chen, 2022-present. All rights reserved.
+
+package hummingbird
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestValidateCutoverOptions(t *testing.T) {
+	opts := &CutoverOptions{}
+	err := ValidateCutoverOptions(opts)
+	assertEqual(t, nil, err)
+	assertEqual(t, CutoverLag, opts.Lag)
+	assertEqual(t, CutoverLockNone, opts.Lock)
+
+	opts.Lock = CutoverLockFsync
+	err = ValidateCutoverOptions(opts)
+	assertEqual(t, nil, err)
+
+	opts.Lock = "unknown"
+	err = ValidateCutoverOptions(opts)
+	assertNotEqual(t, nil, err)
+
+	opts.Lock = CutoverLockRole
+	err = ValidateCutoverOptions(opts)
+	assertNotEqual(t, nil, err)
+
+	opts.Revoke = []CutoverRevoke{{DB: "admin", User: "app"}}
+	err = ValidateCutoverOptions(opts)
+	assertNotEqual(t, nil, err)
+
+	opts.Revoke[0].Roles = []interface{}{"readWrite"}
+	err = ValidateCutoverOptions(opts)
+	assertEqual(t, nil, err)
+}
+
+func TestValidateMigratorConfigCutover(t *testing.T) {
+	inst := &Migrator{Command: CommandAll, Cutover: &CutoverOptions{Lock: "unknown"}, Source: TestSourceURI,
+		Target: TestTargetURI}
+	err := ValidateMigratorConfig(inst)
+	assertNotEqual(t, nil, err)
+
+	inst.Cutover.Lock = CutoverLockFsync
+	err = ValidateMigratorConfig(inst)
+	assertEqual(t, nil, err)
+	assertEqual(t, CutoverLag, inst.Cutover.Lag)
+}
+
+func TestIsDrained(t *testing.T) {
+	streamer := OplogStreamer{SetName: "replset"}
+	final := primitive.Timestamp{T: 1650000000, I: 2}
+	finalTime := time.Now()
+	assertEqual(t, false, streamer.IsDrained(final, finalTime))
+
+	streamer.setSynced(primitive.Timestamp{T: 1650000000, I: 1}, false)
+	assertEqual(t, false, streamer.IsDrained(final, finalTime))
+
+	streamer.setSynced(final, false)
+	assertEqual(t, true, streamer.IsDrained(final, finalTime))
+
+	streamer = OplogStreamer{SetName: ChangeStreamName}
+	streamer.setSynced(primitive.Timestamp{}, true)
+	assertEqual(t, true, streamer.IsDrained(final, finalTime.Add(-time.Minute)))
+}
@@ -32,17 +32,39 @@ const (
 
 // TaskStatusCounts stores counts of all status
 type TaskStatusCounts struct {
-	Added      int32
-	Completed  int32
-	Failed     int32
-	Processing int32
-	Splitting  int32
+	Added          int32
+	Completed      int32
+	CompletedBytes int64
+	Failed         int32
+	Processing     int32
+	Splitting      int32
+	TotalBytes     int64
 }
 
-// DataCopier copies data from source to target
-func DataCopier() error {
+// GetProgress returns the ratio of completed tasks, weighted by estimated bytes of tasks if known
+func (counts TaskStatusCounts) GetProgress() float64 {
+	if counts.TotalBytes > 0 {
+		return float64(counts.CompletedBytes) / float64(counts.TotalBytes)
+	}
+	total := counts.Added + counts.Completed + counts.Failed + counts.Processing + counts.Splitting
+	if total == 0 {
+		return 0
+	}
+	return float64(counts.Completed) / float64(total)
+}
+
+// EstimateRemaining returns the time remaining of a progress after an elapsed time
+func EstimateRemaining(percent float64, elapsed time.Duration) time.Duration {
+	if percent <= 0 {
+		return 0
+	}
+	return time.Duration(float64(elapsed) * (1 - percent) / percent)
+}
+
+// DataCopier copies data from source to target, it returns without waiting for tasks once the context
+// is canceled
+func DataCopier(ctx context.Context) error {
 	now := time.Now()
-	ctx := context.Background()
 	logger := gox.GetLogger("DataCopier")
 	inst := GetMigratorInstance()
 	ws := inst.Workspace()
@@ -73,54 +95,80 @@ func DataCopier() error {
 		if err != nil {
 			return fmt.Errorf("ParseURI failed: %v", err)
 		}
+		added := map[string]bool{} // a namespace matched by more than one include is copied once
 		for _, include := range includes {
-			dbName, collName := mdb.SplitNamespace(include.Namespace)
-			if collName == "" || collName == "*" { // expand to include all collections
-				var cursor *mongo.Cursor
-				if cursor, err = sourceClient.Database(dbName).ListCollections(ctx, bson.D{}); err != nil {
-					return err
+			namespaces, err := expandNamespaces(ctx, sourceClient, include.Namespace)
+			if err != nil {
+				return fmt.Errorf("expandNamespaces failed: %v", err)
+			}
+			for _, ns := range namespaces {
+				if added[ns] {
+					continue
 				}
-				for cursor.Next(ctx) {
-					var doc bson.M
-					if err = cursor.Decode(&doc); err != nil {
-						return fmt.Errorf("decode failed: %v", err)
-					}
-					if doc["name"] == nil {
-						continue
-					}
-					collName, ok := doc["name"].(string)
-					if !ok || (doc["type"] != "" && doc["type"] != "collection") || strings.HasPrefix(collName, "system.") {
-						continue
-					}
-					ns := fmt.Sprintf("%v.%v", dbName, doc["name"])
-					task := &Task{ID: primitive.NewObjectID(), IDs: []interface{}{}, Namespace: ns,
-						ParentID: nil, SetName: cs.ReplicaSet, Status: TaskAdded, Include: *include, UpdatedBy: "init"}
-					tasks = append(tasks, task)
+				added[ns] = true
+				matched := include
+				if inc := inst.GetInclude(ns); inc != nil { // the most specific include
+					matched = inc
 				}
-				cursor.Close(ctx)
-				continue
+				task := &Task{ID: primitive.NewObjectID(), IDs: []interface{}{}, Namespace: ns,
+					ParentID: nil, SetName: cs.ReplicaSet, Status: TaskAdded, Include: *matched, UpdatedBy: "init"}
+				tasks = append(tasks, task)
 			}
-			task := &Task{ID: primitive.NewObjectID(), IDs: []interface{}{}, Namespace: include.Namespace,
-				ParentID: nil, SetName: cs.ReplicaSet, Status: TaskAdded, Include: *include, UpdatedBy: "init"}
-			tasks = append(tasks, task)
 		}
 	}
 	ws.InsertTasks(tasks)
 	for i := 0; i < inst.Workers; i++ { // start all workers
 		procID := fmt.Sprintf("%v.%v", os.Getpid(), i+1)
-		go Worker(procID)
+		inst.Go(func() error { return Worker(ctx, procID) })
 		time.Sleep(10 * time.Millisecond)
 	}
-	if err = Splitter(tasks); err != nil {
+	if err = Splitter(ctx, tasks); err != nil {
 		return fmt.Errorf("Splitter failed: %v", err)
 	}
-	if err = Wait(); err != nil {
+	if err = Wait(ctx); err != nil {
 		return fmt.Errorf("Wait failed: %v", err)
 	}
+	if ctx.Err() != nil {
+		logger.Infof("data copy interrupted, took %v", time.Since(now))
+		return nil
+	}
 	logger.Infof("data copied, took %v", time.Since(now))
 	return nil
 }
 
+// expandNamespaces returns collections of a namespace, wildcard namespaces db.* and *.coll are expanded
+func expandNamespaces(ctx context.Context, client *mongo.Client, namespace string) ([]string, error) {
+	dbName, collName := mdb.SplitNamespace(namespace)
+	if dbName != "*" && collName != "" && collName != "*" {
+		return []string{namespace}, nil
+	}
+	dbNames := []string{dbName}
+	if dbName == "*" { // expand to the collection of all databases
+		var err error
+		if dbNames, err = GetQualifiedDBs(client, MetaDBName); err != nil {
+			return nil, err
+		}
+	}
+	namespaces := []string{}
+	for _, name := range dbNames {
+		filter := bson.D{{"type", "collection"}}
+		if dbName == "*" {
+			filter = append(filter, bson.E{Key: "name", Value: collName})
+		}
+		collNames, err := client.Database(name).ListCollectionNames(ctx, filter)
+		if err != nil {
+			return nil, err
+		}
+		for _, coll := range collNames {
+			if strings.HasPrefix(coll, "system.") {
+				continue
+			}
+			namespaces = append(namespaces, name+"."+coll)
+		}
+	}
+	return namespaces, nil
+}
+
 func getQualifiedCollections(uri string) ([]*Include, error) {
 	var includes []*Include
 	client, err := GetMongoClient(uri)
@@ -138,12 +186,12 @@ func getQualifiedCollections(uri string) ([]*Include, error) {
 	return includes, nil
 }
 
-// Wait waits for all tasks to be processed
-func Wait() error {
+// Wait waits for all tasks to be processed or the context to be canceled
+func Wait(ctx context.Context) error {
 	inst := GetMigratorInstance()
 	ws := inst.Workspace()
 	logger := gox.GetLogger()
-	for {
+	for ctx.Err() == nil {
 		counts, err := ws.CountAllStatus()
 		if err != nil {
 			return fmt.Errorf(`CountAllStatus failed: %v`, err)
@@ -151,14 +199,11 @@ func Wait() error {
 		if (counts.Added + counts.Processing) == 0 {
 			return nil
 		}
-		total := counts.Added + counts.Completed + counts.Failed + counts.Processing + counts.Splitting
-		percent := float64(counts.Completed) / float64(total)
-		elapsed := time.Since(inst.genesis)
-		millis := elapsed.Hours() + elapsed.Minutes() + elapsed.Seconds() + float64(elapsed.Milliseconds())
-		remaining := time.Duration(time.Duration(millis*(1-percent)/percent) * time.Millisecond)
+		percent := counts.GetProgress()
+		remaining := EstimateRemaining(percent, time.Since(inst.genesis))
 
 		eta := ""
-		if counts.Splitting == 0 {
+		if counts.Splitting == 0 && percent > 0 {
 			eta = fmt.Sprintf(", %v (%.1f%%) to go", remaining.Truncate(time.Second), (1-percent)*100)
 		}
 		logger.Infof("added:%v, completed:%v, failed:%v, processing:%v, splitting:%v%v",
@@ -171,6 +216,7 @@ func Wait() error {
 		if counts.Added < 100 {
 			unit = 10 * time.Second
 		}
-		time.Sleep(unit)
+		sleepWithContext(ctx, unit)
 	}
+	return nil
 }
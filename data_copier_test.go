@@ -5,6 +5,7 @@ package hummingbird
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/simagix/keyhole/mdb"
 )
@@ -29,7 +30,7 @@ func TestDataCopier(t *testing.T) {
 	ws := inst.Workspace()
 	err = ws.Reset()
 	assertEqual(t, nil, err)
-	err = DataCopier()
+	err = DataCopier(ctx)
 	assertEqual(t, nil, err)
 }
 
@@ -38,3 +39,20 @@ func TestGetQualifiedCollections(t *testing.T) {
 	assertEqual(t, nil, err)
 	assertNotEqual(t, 0, len(includes))
 }
+
+func TestGetProgress(t *testing.T) {
+	counts := TaskStatusCounts{Added: 3, Completed: 1}
+	assertEqual(t, 0.25, counts.GetProgress())
+
+	counts.CompletedBytes = 3 * mb
+	counts.TotalBytes = 4 * mb
+	assertEqual(t, 0.75, counts.GetProgress())
+
+	assertEqual(t, 0.0, TaskStatusCounts{}.GetProgress())
+}
+
+func TestEstimateRemaining(t *testing.T) {
+	assertEqual(t, 3*time.Minute, EstimateRemaining(0.25, time.Minute))
+	assertEqual(t, time.Duration(0), EstimateRemaining(1, time.Minute))
+	assertEqual(t, time.Duration(0), EstimateRemaining(0, time.Minute))
+}
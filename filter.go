@@ -0,0 +1,264 @@
+// Copyright Kuei-chun Chen, 2022-present. All rights reserved.
+
+package hummingbird
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MatchFilter returns true if a document matches a query filter.  Supported are $and, $or, $nor, $eq,
+// $ne, $gt, $gte, $lt, $lte, $in, $nin, and $exists, an error is returned for other operators and for
+// paths into arrays, so that the filter is evaluated by the server instead.
+func MatchFilter(doc bson.D, filter bson.D) (bool, error) {
+	for _, e := range filter {
+		matched, err := matchFilterElement(doc, e)
+		if err != nil || !matched {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// matchFilterElement matches a field condition or a logical operator of a filter
+func matchFilterElement(doc bson.D, e bson.E) (bool, error) {
+	switch e.Key {
+	case "$and", "$or", "$nor":
+		filters, ok := e.Value.(primitive.A)
+		if !ok || len(filters) == 0 {
+			return false, fmt.Errorf("%v requires a nonempty array", e.Key)
+		}
+		for _, f := range filters {
+			filter, ok := f.(bson.D)
+			if !ok {
+				return false, fmt.Errorf("%v requires an array of filters", e.Key)
+			}
+			matched, err := MatchFilter(doc, filter)
+			if err != nil {
+				return false, err
+			} else if e.Key == "$and" && !matched {
+				return false, nil
+			} else if e.Key == "$or" && matched {
+				return true, nil
+			} else if e.Key == "$nor" && matched {
+				return false, nil
+			}
+		}
+		return e.Key != "$or", nil
+	}
+	if strings.HasPrefix(e.Key, "$") {
+		return false, fmt.Errorf("operator %v is not supported", e.Key)
+	}
+	value, found, err := getFilterFieldValue(doc, e.Key)
+	if err != nil {
+		return false, err
+	}
+	if expr, ok := e.Value.(bson.D); ok && len(expr) > 0 && strings.HasPrefix(expr[0].Key, "$") {
+		for _, op := range expr {
+			matched, err := matchOperator(value, found, op.Key, op.Value)
+			if err != nil || !matched {
+				return false, err
+			}
+		}
+		return true, nil
+	}
+	return matchOperator(value, found, "$eq", e.Value)
+}
+
+// getFilterFieldValue returns value of a dotted path, an error if the path is into an array
+func getFilterFieldValue(doc bson.D, path string) (interface{}, bool, error) {
+	elems := strings.SplitN(path, ".", 2)
+	for _, v := range doc {
+		if v.Key != elems[0] {
+			continue
+		} else if len(elems) == 1 {
+			return v.Value, true, nil
+		}
+		switch sub := v.Value.(type) {
+		case bson.D:
+			return getFilterFieldValue(sub, elems[1])
+		case primitive.A:
+			return nil, false, fmt.Errorf("path %v into an array is not supported", path)
+		}
+		return nil, false, nil
+	}
+	return nil, false, nil
+}
+
+// matchOperator matches a field value by a query operator, an array matches if any of its elements does
+func matchOperator(value interface{}, found bool, operator string, arg interface{}) (bool, error) {
+	switch operator {
+	case "$exists":
+		exists, ok := arg.(bool)
+		if !ok {
+			exists = ToFloat64(arg) != 0
+		}
+		return found == exists, nil
+	case "$ne":
+		matched, err := matchOperator(value, found, "$eq", arg)
+		return !matched, err
+	case "$nin":
+		matched, err := matchOperator(value, found, "$in", arg)
+		return !matched, err
+	case "$in":
+		args, ok := arg.(primitive.A)
+		if !ok {
+			return false, fmt.Errorf("$in requires an array")
+		}
+		for _, a := range args {
+			if matched, err := matchOperator(value, found, "$eq", a); err != nil || matched {
+				return matched, err
+			}
+		}
+		return false, nil
+	case "$eq", "$gt", "$gte", "$lt", "$lte":
+	default:
+		return false, fmt.Errorf("operator %v is not supported", operator)
+	}
+	if _, ok := arg.(primitive.Regex); ok {
+		return false, fmt.Errorf("regular expressions are not supported")
+	}
+	if !found { // null matches missing fields
+		return arg == nil && operator != "$gt" && operator != "$lt", nil
+	}
+	if arr, ok := value.(primitive.A); ok {
+		if _, ok = arg.(primitive.A); ok { // matched as a whole
+			return isCompared(arr, operator, arg)
+		}
+		for _, elem := range arr {
+			if matched, err := isCompared(elem, operator, arg); err != nil || matched {
+				return matched, err
+			}
+		}
+		return false, nil
+	}
+	return isCompared(value, operator, arg)
+}
+
+// isCompared returns true if a value compared with an argument satisfies a comparison operator
+func isCompared(value interface{}, operator string, arg interface{}) (bool, error) {
+	n, ok, err := compareValues(value, arg)
+	if err != nil || !ok {
+		return false, err
+	}
+	switch operator {
+	case "$gt":
+		return n > 0, nil
+	case "$gte":
+		return n >= 0, nil
+	case "$lt":
+		return n < 0, nil
+	case "$lte":
+		return n <= 0, nil
+	}
+	return n == 0, nil
+}
+
+// compareValues returns -1, 0, or 1 comparing values of the same type, false if of different types, i.e.
+// a string and a number.  Numbers of different types are compared by values.  An error is returned if not
+// comparable in memory, i.e. a Decimal128, or documents and arrays not identical.
+func compareValues(a interface{}, b interface{}) (int, bool, error) {
+	if !isFilterScalar(a) || !isFilterScalar(b) {
+		at, adata, aerr := bson.MarshalValue(a)
+		bt, bdata, berr := bson.MarshalValue(b)
+		if aerr == nil && berr == nil && at == bt && bytes.Equal(adata, bdata) {
+			return 0, true, nil
+		} else if isFilterContainer(a) && isFilterScalar(b) || isFilterScalar(a) && isFilterContainer(b) {
+			return 0, false, nil
+		}
+		return 0, false, fmt.Errorf("comparing %T with %T is not supported", a, b)
+	}
+	switch x := a.(type) {
+	case nil:
+		return 0, b == nil, nil
+	case int32, int64, float64:
+		switch b.(type) {
+		case int32, int64, float64:
+		default:
+			return 0, false, nil
+		}
+		if i, ok := toInteger(a); ok {
+			if j, ok := toInteger(b); ok {
+				return compareInt64(i, j), true, nil
+			}
+		}
+		if f, g := ToFloat64(a), ToFloat64(b); f < g {
+			return -1, true, nil
+		} else if f > g {
+			return 1, true, nil
+		}
+		return 0, true, nil
+	case string:
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y), true, nil
+		}
+	case bool:
+		if y, ok := b.(bool); ok {
+			return compareInt64(boolToInt64(x), boolToInt64(y)), true, nil
+		}
+	case primitive.DateTime:
+		if y, ok := b.(primitive.DateTime); ok {
+			return compareInt64(int64(x), int64(y)), true, nil
+		}
+	case primitive.ObjectID:
+		if y, ok := b.(primitive.ObjectID); ok {
+			return bytes.Compare(x[:], y[:]), true, nil
+		}
+	case primitive.Timestamp:
+		if y, ok := b.(primitive.Timestamp); ok {
+			return primitive.CompareTimestamp(x, y), true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+// isFilterScalar returns true if a value is of a type compared in memory
+func isFilterScalar(v interface{}) bool {
+	switch v.(type) {
+	case nil, int32, int64, float64, string, bool, primitive.DateTime, primitive.ObjectID, primitive.Timestamp:
+		return true
+	}
+	return false
+}
+
+// isFilterContainer returns true if a value is a document or an array
+func isFilterContainer(v interface{}) bool {
+	switch v.(type) {
+	case bson.D, primitive.A:
+		return true
+	}
+	return false
+}
+
+// toInteger returns an integer value of int32 or int64
+func toInteger(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	}
+	return 0, false
+}
+
+// boolToInt64 returns 1 if true, 0 otherwise
+func boolToInt64(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// compareInt64 returns -1, 0, or 1 comparing two integers
+func compareInt64(a int64, b int64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
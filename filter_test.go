@@ -0,0 +1,65 @@
+// Copyright Kuei-chun Chen, 2022-present. All rights reserved.
+
+package hummingbird
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestMatchFilter(t *testing.T) {
+	var doc bson.D
+	err := bson.UnmarshalExtJSON([]byte(`{ "_id": 1, "color": "Black", "year": 2020, "price": 9.5,
+		"tags": [ "a", "b" ], "owner": { "name": "ken" }, "sold": null }`), false, &doc)
+	assertEqual(t, nil, err)
+	tests := map[string]bool{
+		`{ "color": "Black" }`:                                     true,
+		`{ "color": { "$in": [ "Black", "White" ] } }`:             true,
+		`{ "color": { "$nin": [ "Black", "White" ] } }`:            false,
+		`{ "year": { "$gte": 2020, "$lt": 2021 } }`:                true,
+		`{ "year": { "$gt": 2020.5 } }`:                            false,
+		`{ "price": { "$lte": 10 } }`:                              true,
+		`{ "tags": "b" }`:                                          true,
+		`{ "tags": [ "a", "b" ] }`:                                 true,
+		`{ "owner.name": { "$ne": "ken" } }`:                       false,
+		`{ "sold": null, "missing": null }`:                        true,
+		`{ "missing": { "$exists": true } }`:                       false,
+		`{ "$or": [ { "color": "White" }, { "year": 2020 } ] }`:    true,
+		`{ "$nor": [ { "color": "White" }, { "year": 2020 } ] }`:   false,
+		`{ "$and": [ { "color": "Black" }, { "year": "2020" } ] }`: false,
+	}
+	for filter, expected := range tests {
+		var f bson.D
+		err = bson.UnmarshalExtJSON([]byte(filter), false, &f)
+		assertEqual(t, nil, err)
+		matched, err := MatchFilter(doc, f)
+		assertEqual(t, nil, err)
+		assertEqual(t, expected, matched)
+	}
+	for _, filter := range []string{`{ "color": { "$regex": "^B" } }`, `{ "tags.0": "a" }`,
+		`{ "$where": "true" }`, `{ "price": { "$gt": { "$numberDecimal": "9" } } }`, `{ "owner": { "name": "ben" } }`} {
+		var f bson.D
+		err = bson.UnmarshalExtJSON([]byte(filter), false, &f)
+		assertEqual(t, nil, err)
+		_, err = MatchFilter(doc, f)
+		assertNotEqual(t, nil, err)
+	}
+}
+
+func TestMatchFilterDecimal(t *testing.T) {
+	var doc bson.D
+	err := bson.UnmarshalExtJSON([]byte(`{ "_id": 1, "price": { "$numberDecimal": "12.5" } }`), false, &doc)
+	assertEqual(t, nil, err)
+	var f bson.D
+	err = bson.UnmarshalExtJSON([]byte(`{ "price": { "$gt": 10 } }`), false, &f)
+	assertEqual(t, nil, err)
+	_, err = MatchFilter(doc, f)
+	assertNotEqual(t, nil, err) // looked up from the source
+
+	err = bson.UnmarshalExtJSON([]byte(`{ "price": { "$numberDecimal": "12.5" } }`), false, &f)
+	assertEqual(t, nil, err)
+	matched, err := MatchFilter(doc, f)
+	assertEqual(t, nil, err)
+	assertEqual(t, true, matched)
+}
@@ -3,6 +3,8 @@ module github.com/simagix/hummingbird
 go 1.19
 
 require (
+	github.com/golang/snappy v0.0.4
+	github.com/klauspost/compress v1.13.6
 	github.com/simagix/gox v0.2.4-0.20220226131255-b9dcc7e8afc1
 	github.com/simagix/keyhole v1.2.2-0.20220225131322-676854097886
 	go.mongodb.org/mongo-driver v1.8.3
@@ -11,8 +13,6 @@ require (
 
 require (
 	github.com/go-stack/stack v1.8.0 // indirect
-	github.com/golang/snappy v0.0.4 // indirect
-	github.com/klauspost/compress v1.13.6 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/xdg-go/pbkdf2 v1.0.0 // indirect
 	github.com/xdg-go/scram v1.0.2 // indirect
@@ -5,19 +5,31 @@ package hummingbird
 import (
 	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/simagix/keyhole/mdb"
 	"go.mongodb.org/mongo-driver/bson"
 )
 
+const (
+	// SampleFirst samples the first documents
+	SampleFirst = "first"
+	// SampleRandom samples random documents
+	SampleRandom = "random"
+	// SampleStride samples documents evenly strided across the _id range
+	SampleStride = "stride"
+)
+
 // Include stores namespace and query
 type Include struct {
-	Filter    bson.D   `bson:"filter,omitempty"`
-	Limit     int64    `bson:"limit,omitempty"`
-	Masks     []string `bson:"masks,omitempty"`
-	Method    string   `bson:"method,omitempty"`
-	Namespace string   `bson:"namespace"`
-	To        string   `bson:"to,omitempty"`
+	Filter     bson.D   `bson:"filter,omitempty"`
+	Limit      int64    `bson:"limit,omitempty"`
+	Masks      []string `bson:"masks,omitempty"`
+	Method     string   `bson:"method,omitempty"`
+	Namespace  string   `bson:"namespace"`
+	Sample     string   `bson:"sample,omitempty"`
+	To         string   `bson:"to,omitempty"`
+	Transforms []bson.D `bson:"transforms,omitempty"`
 }
 
 // Includes stores Include
@@ -35,48 +47,117 @@ func (p *Includes) String() string {
 	return str + "] }"
 }
 
-// Set sets flag var
+// Set sets flag var, keyed mask methods are checked against the secret by ValidateMigratorConfig
 func (p *Includes) Set(value string) error {
-	var err error
-	var include *Include
-	if include, err = GetInclude(value); err != nil {
+	include, err := parseInclude(value)
+	if err != nil {
+		return err
+	}
+	if err = configureInclude(include); err != nil {
 		return err
 	}
 	*p = append(*p, include)
 	return nil
 }
 
-// GetInclude returns Include
-func GetInclude(value string) (*Include, error) {
-	var err error
+// GetInclude returns Include, keyed mask methods require a secret
+func GetInclude(value string, secret string) (*Include, error) {
+	include, err := parseInclude(value)
+	if err != nil {
+		return include, err
+	}
+	return include, ValidateIncludes(Includes{include}, secret)
+}
+
+// parseInclude parses an Include from extended JSON
+func parseInclude(value string) (*Include, error) {
 	var include = &Include{}
-	if err = bson.UnmarshalExtJSON([]byte(value), false, include); err != nil {
+	if err := bson.UnmarshalExtJSON([]byte(value), false, include); err != nil {
 		return include, err
 	} else if include.Namespace == "" {
 		return include, errors.New(`invalid namespace`)
 	}
-
 	if len(include.Filter) == 0 {
 		include.Filter = bson.D{}
 	}
-	if len(include.Masks) > 0 { // mask fields
-		if err = ConfigureMaskOption(include); err != nil {
-			return include, err
+	return include, nil
+}
+
+// ValidateIncludes configures and validates includes of a configuration file or flags, keyed mask
+// methods require a secret
+func ValidateIncludes(includes Includes, secret string) error {
+	for _, include := range includes {
+		if err := configureInclude(include); err != nil {
+			return err
+		}
+		if len(include.Masks) > 0 && IsKeyedMaskMethod(include.Method) && secret == "" {
+			return fmt.Errorf(`%v, mask method %v requires a "secret"`, include.Namespace, include.Method)
 		}
 	}
-	return include, err
+	return nil
+}
+
+// configureInclude configures and validates mask, limit, transforms and "to" options of an include
+func configureInclude(include *Include) error {
+	if len(include.Masks) > 0 {
+		if err := ConfigureMaskOption(include); err != nil {
+			return err
+		}
+	}
+	if include.Limit != 0 || include.Sample != "" {
+		if err := ConfigureLimitOption(include); err != nil {
+			return err
+		}
+	}
+	if len(include.Transforms) > 0 {
+		if err := ValidateTransforms(include.Transforms); err != nil {
+			return fmt.Errorf(`%v, %v`, include.Namespace, err)
+		}
+	}
+	if include.To != "" && getWildcard(include.Namespace) != getWildcard(include.To) {
+		return fmt.Errorf(`%v, "to" %v must be a namespace of the same wildcard`, include.Namespace, include.To)
+	}
+	return nil
+}
+
+// getWildcard returns the wildcard form of a namespace, db.*, *.coll, or none
+func getWildcard(namespace string) string {
+	dbName, collName := mdb.SplitNamespace(namespace)
+	if collName == "" || collName == "*" {
+		return "db.*"
+	} else if dbName == "*" {
+		return "*.coll"
+	}
+	return ""
+}
+
+// ConfigureLimitOption assigns sample option
+func ConfigureLimitOption(include *Include) error {
+	if include.Limit < 0 {
+		return fmt.Errorf(`invalid limit %v`, include.Limit)
+	}
+	if include.Sample == "" {
+		include.Sample = SampleFirst
+	} else if include.Sample != SampleFirst && include.Sample != SampleRandom && include.Sample != SampleStride {
+		return fmt.Errorf(`invalid sample method %v`, include.Sample)
+	}
+	return nil
 }
 
 // ConfigureMaskOption assigns mask option
 func ConfigureMaskOption(include *Include) error {
-	dbName, collName := mdb.SplitNamespace(include.Namespace)
-	if dbName == "*" || collName == "*" {
-		return fmt.Errorf(`%v, wildcard is not supported with masking`, include.Namespace)
+	for _, field := range include.Masks {
+		if err := ValidateMaskField(field); err != nil {
+			return fmt.Errorf(`%v, %v`, include.Namespace, err)
+		}
 	}
 	if include.Method == "" {
 		include.Method = MaskDefault
-	} else if include.Method != MaskDefault && include.Method != MaskHEX && include.Method != MaskPartial {
-		return fmt.Errorf(`invalid mask method %v`, include.Method)
 	}
-	return nil
+	for _, method := range MaskMethods {
+		if include.Method == method {
+			return nil
+		}
+	}
+	return fmt.Errorf(`invalid mask method %v, supported methods are %v`, include.Method, strings.Join(MaskMethods, ", "))
 }
@@ -15,6 +15,10 @@ func TestSet(t *testing.T) {
 	assertEqual(t, nil, err)
 	assertEqual(t, "db.collection", (*includes)[0].Namespace)
 	includes.Set(`{ "namespace": "db.another" }`)
+	err = includes.Set(`{ "namespace": "db.masked", "masks": ["ssn"], "method": "hash" }`)
+	assertEqual(t, nil, err)
+	err = includes.Set(`{ "namespace": "db.masked", "masks": ["ssn"], "method": "unknown" }`)
+	assertNotEqual(t, nil, err)
 	str := includes.String()
 	assertNotEqual(t, "", str)
 }
@@ -23,21 +27,51 @@ func TestGetInclude(t *testing.T) {
 	var err error
 	var include *Include
 	str := `{ "namespace": "db.collection", "filter": {"a": {"$gt": {"$date": "2020-03-01T00:00:00.001Z"} }} }`
-	include, err = GetInclude(str)
+	include, err = GetInclude(str, "")
 	assertEqual(t, nil, err)
 	assertEqual(t, "db.collection", include.Namespace)
 
-	include, err = GetInclude(`{ "namespace": "" }`)
+	include, err = GetInclude(`{ "namespace": "" }`, "")
 	assertNotEqual(t, nil, err)
 
-	include, err = GetInclude(`invalid json`)
+	include, err = GetInclude(`invalid json`, "")
 	assertNotEqual(t, nil, err)
 
 	str = `{ "namespace": "db.collection", "filter": {"a": {"$gt": {"$date": "2020-03-01T00:00:00.001Z"} }}, "masks": ["name"] }`
-	include, err = GetInclude(str)
+	include, err = GetInclude(str, "")
+	assertEqual(t, nil, err)
+
+	str = `{ "namespace": "db.collection", "masks": ["ssn"], "method": "hash" }`
+	_, err = GetInclude(str, "")
+	assertNotEqual(t, nil, err)
+	_, err = GetInclude(str, "secret")
 	assertEqual(t, nil, err)
 }
 
+func TestValidateIncludes(t *testing.T) {
+	includes := Includes{&Include{Namespace: "db.collection", Masks: []string{"ssn"}, Method: MaskCard}}
+	err := ValidateIncludes(includes, "")
+	assertNotEqual(t, nil, err)
+	err = ValidateIncludes(includes, "secret")
+	assertEqual(t, nil, err)
+
+	includes = Includes{&Include{Namespace: "db.collection", Limit: 10}}
+	err = ValidateIncludes(includes, "")
+	assertEqual(t, nil, err)
+	assertEqual(t, SampleFirst, includes[0].Sample)
+
+	for _, include := range []*Include{{Namespace: "db.*", To: "newdb.coll"}, {Namespace: "*.coll", To: "newdb.coll"},
+		{Namespace: "db.coll", To: "newdb.*"}} {
+		err = ValidateIncludes(Includes{include}, "")
+		assertNotEqual(t, nil, err)
+	}
+	for _, include := range []*Include{{Namespace: "db.*", To: "newdb.*"}, {Namespace: "*.coll", To: "*.newcoll"},
+		{Namespace: "db.coll", To: "newdb.coll"}} {
+		err = ValidateIncludes(Includes{include}, "")
+		assertEqual(t, nil, err)
+	}
+}
+
 func TestConfigureMaskOption(t *testing.T) {
 	var err error
 	var include Include
@@ -59,3 +93,42 @@ func TestConfigureMaskOption(t *testing.T) {
 	err = ConfigureMaskOption(&include)
 	assertNotEqual(t, nil, err)
 }
+
+func TestConfigureLimitOption(t *testing.T) {
+	include := Include{Namespace: "db.collection", Limit: 1024}
+	err := ConfigureLimitOption(&include)
+	assertEqual(t, nil, err)
+	assertEqual(t, SampleFirst, include.Sample)
+
+	include = Include{Namespace: "db.collection", Limit: 1024, Sample: SampleStride}
+	err = ConfigureLimitOption(&include)
+	assertEqual(t, nil, err)
+
+	include = Include{Namespace: "db.collection", Limit: 1024, Sample: "unknown"}
+	err = ConfigureLimitOption(&include)
+	assertNotEqual(t, nil, err)
+
+	include = Include{Namespace: "db.collection", Limit: -1}
+	err = ConfigureLimitOption(&include)
+	assertNotEqual(t, nil, err)
+}
+
+func TestConfigureMaskOptionMethods(t *testing.T) {
+	for _, method := range MaskMethods {
+		include := Include{Namespace: "db.collection", Masks: []string{"name"}, Method: method}
+		err := ConfigureMaskOption(&include)
+		assertEqual(t, nil, err)
+	}
+}
+
+func TestConfigureMaskOptionWildcard(t *testing.T) {
+	include := Include{Namespace: "db.*", Masks: []string{"**.ssn"}}
+	err := ConfigureMaskOption(&include)
+	assertEqual(t, nil, err)
+	include = Include{Namespace: "*.users", Masks: []string{"contacts.$[].email"}}
+	err = ConfigureMaskOption(&include)
+	assertEqual(t, nil, err)
+	include = Include{Namespace: "db.users", Masks: []string{"ssn.**"}}
+	err = ConfigureMaskOption(&include)
+	assertNotEqual(t, nil, err)
+}
@@ -3,64 +3,353 @@
 package hummingbird
 
 import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 const (
+	// MaskCard uses deterministic credit card masking method
+	MaskCard = "card"
 	// MaskDefault uses default masking method
 	MaskDefault = "default"
+	// MaskEmail uses deterministic email masking method
+	MaskEmail = "email"
+	// MaskHash uses deterministic keyed masking method
+	MaskHash = "hash"
 	// MaskHEX uses HEX masking method
 	MaskHEX = "hex"
 	// MaskPartial uses partial masking method
 	MaskPartial = "partial"
+	// MaskPhone uses deterministic phone number masking method
+	MaskPhone = "phone"
 )
 
-// MaskFields mask all matched fields by traversing a doc
+// MaskMethods lists all supported masking methods
+var MaskMethods = []string{MaskCard, MaskDefault, MaskEmail, MaskHash, MaskHEX, MaskPartial, MaskPhone}
+
+// IsKeyedMaskMethod returns true if a method masks deterministically with a secret
+func IsKeyedMaskMethod(method string) bool {
+	return method == MaskCard || method == MaskEmail || method == MaskHash || method == MaskPhone
+}
+
+// MaskFields mask all matched fields by traversing a doc, a field is a dotted path of which an element
+// can be a key, * for any key, ** for any depth, $[] for all array elements, or a /regex/ of keys
 func MaskFields(doc *bson.D, fields []string, method string) {
+	masked := map[string]bool{} // paths masked, a value matched by more than one path is masked once
 	for _, field := range fields {
-		elems := strings.Split(field, ".")
-		maskDoc(*doc, elems, method)
+		elems := SplitMaskField(field)
+		maskDoc(*doc, elems, method, "", masked)
+	}
+}
+
+// MaskUpdateFields masks all matched fields of an update document, i.e. $set, of which keys can be dotted paths
+func MaskUpdateFields(doc *bson.D, fields []string, method string) {
+	for i, v := range *doc {
+		keys := strings.Split(v.Key, ".")
+		nested := wrapFieldPath(keys, v.Value)
+		MaskFields(&nested, fields, method)
+		(*doc)[i].Value = unwrapFieldPath(keys, nested)
 	}
 }
 
-func maskDoc(doc bson.D, elems []string, method string) {
+// SplitMaskField splits a mask field by dots, except those within a /regex/
+func SplitMaskField(field string) []string {
+	elems := []string{}
+	elem := ""
+	for _, c := range field {
+		if c == '.' && !(strings.HasPrefix(elem, "/") && (len(elem) == 1 || !strings.HasSuffix(elem, "/"))) {
+			elems = append(elems, elem)
+			elem = ""
+			continue
+		}
+		elem += string(c)
+	}
+	return append(elems, elem)
+}
+
+// ValidateMaskField returns error if a mask field is malformed
+func ValidateMaskField(field string) error {
+	elems := SplitMaskField(field)
+	for i, elem := range elems {
+		if elem == "" {
+			return fmt.Errorf(`invalid mask field "%v", empty element`, field)
+		} else if elem == "**" && i == len(elems)-1 {
+			return fmt.Errorf(`invalid mask field "%v", ** must be followed by a field`, field)
+		} else if isRegexElem(elem) {
+			if _, err := getMaskRegex(elem); err != nil {
+				return fmt.Errorf(`invalid mask field "%v": %v`, field, err)
+			}
+		}
+	}
+	return nil
+}
+
+// wrapFieldPath converts a dotted path and its value to a nested document, i.e. a.0.b to {a: [{b: value}]}
+func wrapFieldPath(keys []string, value interface{}) bson.D {
+	for i := len(keys) - 1; i > 0; i-- {
+		if _, err := strconv.Atoi(keys[i]); err == nil {
+			value = bson.A{value}
+		} else {
+			value = bson.D{{keys[i], value}}
+		}
+	}
+	return bson.D{{keys[0], value}}
+}
+
+// unwrapFieldPath returns the value of a nested document created by wrapFieldPath
+func unwrapFieldPath(keys []string, doc bson.D) interface{} {
+	var value interface{} = doc
+	for range keys {
+		if bsonD, ok := value.(bson.D); ok {
+			value = bsonD[0].Value
+		} else if bsonA, ok := value.(bson.A); ok {
+			value = bsonA[0]
+		}
+	}
+	return value
+}
+
+// maskDoc masks fields of a doc matched by path elements, paths of masked values are added to masked
+func maskDoc(doc bson.D, elems []string, method string, path string, masked map[string]bool) {
 	elem := elems[0]
-	elems = elems[1:]
+	if elem == "**" {
+		if len(elems) == 1 {
+			return
+		}
+		maskDoc(doc, elems[1:], method, path, masked) // zero level
+		for i, v := range doc {
+			doc[i].Value = maskNested(v.Value, elems, method, joinMaskPath(path, v.Key), masked)
+		}
+		return
+	}
 	for i, v := range doc {
-		if v.Key == elem {
-			if len(elems) == 0 {
-				doc[i].Value = getMaskedValue(doc[i].Value, method)
-			} else if bsonD, ok := doc[i].Value.(bson.D); ok {
-				maskDoc(bsonD, elems, method)
-			} else if bsonA, ok := doc[i].Value.(bson.A); ok {
-				for _, val := range bsonA {
-					if bsonD, ok = val.(bson.D); ok {
-						maskDoc(bsonD, elems, method)
-					}
-				}
+		if !isMaskKeyMatched(v.Key, elem) {
+			continue
+		}
+		if len(elems) == 1 {
+			doc[i].Value = maskPathValue(v.Value, method, joinMaskPath(path, v.Key), masked)
+		} else {
+			doc[i].Value = maskNested(v.Value, elems[1:], method, joinMaskPath(path, v.Key), masked)
+		}
+	}
+}
+
+// maskNested masks a sub document or all elements of an array
+func maskNested(v interface{}, elems []string, method string, path string, masked map[string]bool) interface{} {
+	if masked[path] {
+		return v
+	}
+	if bsonD, ok := v.(bson.D); ok {
+		maskDoc(bsonD, elems, method, path, masked)
+	} else if bsonA, ok := v.(bson.A); ok {
+		if elems[0] == "$[]" {
+			if elems = elems[1:]; len(elems) == 0 {
+				return maskPathValue(bsonA, method, path, masked)
 			}
-			return
 		}
+		for i := range bsonA {
+			bsonA[i] = maskNested(bsonA[i], elems, method, joinMaskPath(path, strconv.Itoa(i)), masked)
+		}
+	}
+	return v
+}
+
+// maskPathValue masks a value and its sub values unless they were masked already
+func maskPathValue(v interface{}, method string, path string, masked map[string]bool) interface{} {
+	if masked[path] {
+		return v
+	}
+	masked[path] = true
+	if bsonD, ok := v.(bson.D); ok {
+		for i, e := range bsonD {
+			bsonD[i].Value = maskPathValue(e.Value, method, joinMaskPath(path, e.Key), masked)
+		}
+		return bsonD
+	} else if bsonA, ok := v.(bson.A); ok {
+		for i := range bsonA {
+			bsonA[i] = maskPathValue(bsonA[i], method, joinMaskPath(path, strconv.Itoa(i)), masked)
+		}
+		return bsonA
+	}
+	return getMaskedValue(v, method)
+}
+
+func joinMaskPath(path string, key string) string {
+	if path == "" {
+		return key
 	}
+	return path + "." + key
+}
+
+var maskRegexMap sync.Map
+
+func isRegexElem(elem string) bool {
+	return len(elem) > 2 && strings.HasPrefix(elem, "/") && strings.HasSuffix(elem, "/")
+}
+
+func getMaskRegex(elem string) (*regexp.Regexp, error) {
+	if re, ok := maskRegexMap.Load(elem); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(elem[1 : len(elem)-1])
+	if err != nil {
+		return nil, err
+	}
+	maskRegexMap.Store(elem, re)
+	return re, nil
+}
+
+func isMaskKeyMatched(key string, elem string) bool {
+	if key == elem || elem == "*" {
+		return true
+	} else if isRegexElem(elem) {
+		re, err := getMaskRegex(elem)
+		return err == nil && re.MatchString(key)
+	}
+	return false
 }
 
 func getMaskedValue(v interface{}, method string) interface{} {
 	switch s := v.(type) {
 	case string:
-		if method == MaskPartial && len(s) > 4 {
-			reg, _ := regexp.Compile("[a-zA-Z0-9]")
-			return reg.ReplaceAllString(s[:len(s)-4], "X") + s[len(s)-4:]
-		} else if method == MaskHEX {
-			return primitive.NewObjectID().Hex()
-		} else { // maskDefault
-			reg, _ := regexp.Compile("[a-zA-Z0-9]")
-			return reg.ReplaceAllString(s, "X")
+		return getMaskedString(s, method)
+	case primitive.D:
+		for i := range s {
+			s[i].Value = getMaskedValue(s[i].Value, method)
+		}
+		return s
+	case primitive.A:
+		for i := range s {
+			s[i] = getMaskedValue(s[i], method)
 		}
+		return s
+	}
+	if method != MaskHEX && !IsKeyedMaskMethod(method) { // default and partial only mask strings
+		return v
+	}
+	switch s := v.(type) {
+	case int32:
+		return int32(binary.BigEndian.Uint32(getMaskBytes(v, method, 4)) >> 1)
+	case int64:
+		return int64(binary.BigEndian.Uint64(getMaskBytes(v, method, 8)) >> 1)
+	case float64:
+		return float64(binary.BigEndian.Uint32(getMaskBytes(v, method, 4))) / 100
+	case primitive.DateTime:
+		secs := binary.BigEndian.Uint32(getMaskBytes(v, method, 4)) >> 1
+		return primitive.NewDateTimeFromTime(time.Unix(int64(secs), 0))
+	case primitive.Binary:
+		return primitive.Binary{Subtype: s.Subtype, Data: getMaskBytes(v, method, len(s.Data))}
+	case primitive.ObjectID:
+		var oid primitive.ObjectID
+		copy(oid[:], getMaskBytes(v, method, len(oid)))
+		return oid
 	default:
 		return v
 	}
 }
+
+func getMaskedString(s string, method string) string {
+	if method == MaskPartial && len(s) > 4 {
+		reg, _ := regexp.Compile("[a-zA-Z0-9]")
+		return reg.ReplaceAllString(s[:len(s)-4], "X") + s[len(s)-4:]
+	} else if method == MaskHEX {
+		return primitive.NewObjectID().Hex()
+	} else if method == MaskHash {
+		return hex.EncodeToString(getMaskBytes(s, method, 12))
+	} else if method == MaskEmail {
+		n := strings.LastIndex(s, "@")
+		if n < 0 {
+			return substituteChars(s, getMaskBytes(s, method, len(s)))
+		}
+		return substituteChars(s[:n], getMaskBytes(s, method, n)) + s[n:]
+	} else if method == MaskPhone {
+		return substituteChars(s, getMaskBytes(s, method, len(s)))
+	} else if method == MaskCard {
+		return fixLuhnCheckDigit(substituteChars(s, getMaskBytes(s, method, len(s))))
+	}
+	// maskDefault
+	reg, _ := regexp.Compile("[a-zA-Z0-9]")
+	return reg.ReplaceAllString(s, "X")
+}
+
+// getMaskBytes returns n bytes to derive a masked value from, keyed hashes of the value for
+// deterministic methods, random bytes for hex, and zeros for the others
+func getMaskBytes(v interface{}, method string, n int) []byte {
+	data := make([]byte, n)
+	if method == MaskHEX {
+		rand.Read(data)
+		return data
+	} else if !IsKeyedMaskMethod(method) {
+		return data
+	}
+	_, value, _ := bson.MarshalValue(v)
+	secret := []byte{}
+	if inst := GetMigratorInstance(); inst != nil {
+		secret = []byte(inst.Secret)
+	}
+	var counter [4]byte
+	for i := 0; i < n; i += sha256.Size {
+		binary.BigEndian.PutUint32(counter[:], uint32(i/sha256.Size))
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(counter[:])
+		mac.Write(value)
+		copy(data[i:], mac.Sum(nil))
+	}
+	return data
+}
+
+// substituteChars replaces letters and digits using the key stream and keeps the others
+func substituteChars(s string, stream []byte) string {
+	chars := []byte(s)
+	for i, c := range chars {
+		if c >= '0' && c <= '9' {
+			chars[i] = '0' + stream[i]%10
+		} else if c >= 'a' && c <= 'z' {
+			chars[i] = 'a' + stream[i]%26
+		} else if c >= 'A' && c <= 'Z' {
+			chars[i] = 'A' + stream[i]%26
+		}
+	}
+	return string(chars)
+}
+
+// fixLuhnCheckDigit sets the last digit so that digits pass the Luhn check
+func fixLuhnCheckDigit(s string) string {
+	chars := []byte(s)
+	last := -1
+	sum := 0
+	double := true
+	for i := len(chars) - 1; i >= 0; i-- {
+		if chars[i] < '0' || chars[i] > '9' {
+			continue
+		}
+		if last < 0 {
+			last = i
+			continue
+		}
+		d := int(chars[i] - '0')
+		if double {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	if last >= 0 {
+		chars[last] = '0' + byte((10-sum%10)%10)
+	}
+	return string(chars)
+}
@@ -3,7 +3,10 @@
 package hummingbird
 
 import (
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -70,3 +73,147 @@ func TestGetMaskedValueUnique(t *testing.T) {
 	ret := getMaskedValue(s, MaskHEX).(string)
 	assertEqual(t, len(ret), 24)
 }
+
+func TestMaskUpdateFields(t *testing.T) {
+	var doc bson.D
+	err := bson.UnmarshalExtJSON([]byte(`{"ssn": "555-66-7878", "level2": {"ssn": "555-66-7878"},
+		"array.1.ssn": "555-66-7878", "color": "Red"}`), false, &doc)
+	assertEqual(t, nil, err)
+	MaskUpdateFields(&doc, []string{"ssn", "level2.ssn", "array.ssn"}, MaskDefault)
+	m := doc.Map()
+	assertEqual(t, "XXX-XX-XXXX", m["ssn"])
+	assertEqual(t, "XXX-XX-XXXX", m["level2"].(bson.D).Map()["ssn"])
+	assertEqual(t, "XXX-XX-XXXX", m["array.1.ssn"])
+	assertEqual(t, "Red", m["color"])
+}
+
+func TestGetMaskedValueHash(t *testing.T) {
+	migratorInstance = &Migrator{Secret: "secret"}
+	s := "555-66-7878"
+	ret := getMaskedValue(s, MaskHash).(string)
+	assertEqual(t, 24, len(ret))
+	assertEqual(t, ret, getMaskedValue(s, MaskHash))
+	assertNotEqual(t, ret, getMaskedValue("555-66-7879", MaskHash))
+	migratorInstance = &Migrator{Secret: "another"}
+	assertNotEqual(t, ret, getMaskedValue(s, MaskHash))
+}
+
+func TestGetMaskedValueEmail(t *testing.T) {
+	migratorInstance = &Migrator{Secret: "secret"}
+	s := "John.Doe99@example.com"
+	ret := getMaskedValue(s, MaskEmail).(string)
+	assertEqual(t, len(s), len(ret))
+	assertEqual(t, true, strings.HasSuffix(ret, "@example.com"))
+	assertEqual(t, ".", ret[4:5])
+	assertNotEqual(t, s, ret)
+	assertEqual(t, ret, getMaskedValue(s, MaskEmail))
+}
+
+func TestGetMaskedValuePhone(t *testing.T) {
+	migratorInstance = &Migrator{Secret: "secret"}
+	s := "+1 (555) 666-7878"
+	ret := getMaskedValue(s, MaskPhone).(string)
+	matched, _ := regexp.MatchString(`^\+\d \(\d{3}\) \d{3}-\d{4}$`, ret)
+	assertEqual(t, true, matched)
+	assertEqual(t, ret, getMaskedValue(s, MaskPhone))
+}
+
+func TestGetMaskedValueCard(t *testing.T) {
+	migratorInstance = &Migrator{Secret: "secret"}
+	for _, s := range []string{"4111-1111-1111-1111", "5500 0000 0000 0004", "378282246310005"} {
+		ret := getMaskedValue(s, MaskCard).(string)
+		assertEqual(t, len(s), len(ret))
+		assertEqual(t, ret, fixLuhnCheckDigit(ret))
+		assertEqual(t, ret, getMaskedValue(s, MaskCard))
+	}
+	assertEqual(t, "4111-1111-1111-1111", fixLuhnCheckDigit("4111-1111-1111-1110"))
+}
+
+func TestGetMaskedValueTypes(t *testing.T) {
+	migratorInstance = &Migrator{Secret: "secret"}
+	now := primitive.NewDateTimeFromTime(time.Now())
+	bin := primitive.Binary{Subtype: 4, Data: []byte("0123456789abcdef")}
+	oid := primitive.NewObjectID()
+	for _, method := range []string{MaskHash, MaskHEX} {
+		_, ok := getMaskedValue(int32(123), method).(int32)
+		assertEqual(t, true, ok)
+		_, ok = getMaskedValue(int64(123), method).(int64)
+		assertEqual(t, true, ok)
+		_, ok = getMaskedValue(float64(1.23), method).(float64)
+		assertEqual(t, true, ok)
+		dt := getMaskedValue(now, method).(primitive.DateTime)
+		assertNotEqual(t, now, dt)
+		b := getMaskedValue(bin, method).(primitive.Binary)
+		assertEqual(t, bin.Subtype, b.Subtype)
+		assertEqual(t, len(bin.Data), len(b.Data))
+		assertNotEqual(t, oid, getMaskedValue(oid, method))
+	}
+	assertEqual(t, getMaskedValue(int64(123), MaskHash), getMaskedValue(int64(123), MaskHash))
+	assertEqual(t, getMaskedValue(now, MaskHash), getMaskedValue(now, MaskHash))
+}
+
+func TestGetMaskedValueNonStrings(t *testing.T) {
+	now := primitive.NewDateTimeFromTime(time.Now())
+	for _, method := range []string{MaskDefault, MaskPartial} {
+		assertEqual(t, int32(123), getMaskedValue(int32(123), method))
+		assertEqual(t, float64(1.23), getMaskedValue(float64(1.23), method))
+		assertEqual(t, now, getMaskedValue(now, method))
+	}
+}
+
+func TestMaskFieldsOnce(t *testing.T) {
+	migratorInstance = &Migrator{Secret: "secret"}
+	masked := getMaskedValue("555-66-7878", MaskHash)
+	var doc bson.D
+	err := bson.UnmarshalExtJSON([]byte(`{"ssn": {"ssn": "555-66-7878"}, "a": {"b": "555-66-7878"}}`), false, &doc)
+	assertEqual(t, nil, err)
+	MaskFields(&doc, []string{"**.ssn", "a.b", "a"}, MaskHash)
+	m := doc.Map()
+	assertEqual(t, masked, m["ssn"].(bson.D).Map()["ssn"])
+	assertEqual(t, masked, m["a"].(bson.D).Map()["b"])
+}
+
+func TestMaskFieldsPatterns(t *testing.T) {
+	var doc bson.D
+	err := bson.UnmarshalExtJSON([]byte(`{"ssn": "555-66-7878", "ssn_alt": "555-66-7878",
+		"contacts": [{"email": "a@b.com"}, {"email": "c@d.com"}],
+		"matrix": [[{"ssn": "555-66-7878"}], [{"ssn": "555-66-7878"}]],
+		"deep": {"level2": {"level3": {"ssn": "555-66-7878"}}},
+		"tags": ["a1", "b2"]}`), false, &doc)
+	assertEqual(t, nil, err)
+	MaskFields(&doc, []string{"contacts.$[].email", "**.ssn", "/^ssn_/", "tags.$[]"}, MaskDefault)
+	m := doc.Map()
+	assertEqual(t, "XXX-XX-XXXX", m["ssn"])
+	assertEqual(t, "XXX-XX-XXXX", m["ssn_alt"])
+	for _, v := range m["contacts"].(bson.A) {
+		assertEqual(t, "X@X.XXX", v.(bson.D).Map()["email"])
+	}
+	for _, v := range m["matrix"].(bson.A) {
+		assertEqual(t, "XXX-XX-XXXX", v.(bson.A)[0].(bson.D).Map()["ssn"])
+	}
+	level3 := m["deep"].(bson.D).Map()["level2"].(bson.D).Map()["level3"].(bson.D)
+	assertEqual(t, "XXX-XX-XXXX", level3.Map()["ssn"])
+	assertEqual(t, "XX", m["tags"].(bson.A)[1])
+}
+
+func TestMaskUpdateFieldsPatterns(t *testing.T) {
+	var doc bson.D
+	err := bson.UnmarshalExtJSON([]byte(`{"contacts.1.email": "a@b.com", "deep.level2": {"ssn": "555-66-7878"},
+		"address.city": "Atlanta"}`), false, &doc)
+	assertEqual(t, nil, err)
+	MaskUpdateFields(&doc, []string{"contacts.$[].email", "**.ssn", "address"}, MaskDefault)
+	m := doc.Map()
+	assertEqual(t, "X@X.XXX", m["contacts.1.email"])
+	assertEqual(t, "XXX-XX-XXXX", m["deep.level2"].(bson.D).Map()["ssn"])
+	assertEqual(t, "XXXXXXX", m["address.city"])
+}
+
+func TestSplitMaskField(t *testing.T) {
+	elems := SplitMaskField(`contacts./^e.mail$/.value`)
+	assertEqual(t, 3, len(elems))
+	assertEqual(t, `/^e.mail$/`, elems[1])
+	assertEqual(t, nil, ValidateMaskField("**.ssn"))
+	assertNotEqual(t, nil, ValidateMaskField("ssn.**"))
+	assertNotEqual(t, nil, ValidateMaskField("a..b"))
+	assertNotEqual(t, nil, ValidateMaskField("/[/"))
+}
@@ -15,30 +15,50 @@ import (
 
 	"github.com/simagix/gox"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
 // Migrator stores migration configurations
 type Migrator struct {
-	Block    int      `bson:"block,omitempty"`
-	Command  string   `bson:"command"`
-	Includes Includes `bson:"includes,omitempty"`
-	IsDrop   bool     `bson:"drop,omitempty"`
-	License  string   `bson:"license,omitempty"`
-	Port     int      `bson:"port,omitempty"`
-	Source   string   `bson:"source"`
-	Spool    string   `bson:"spool,omitempty"`
-	Target   string   `bson:"target"`
-	Verbose  bool     `bson:"verbose,omitempty"`
-	Workers  int      `bson:"workers,omitempty"`
-	Yes      bool     `bson:"yes,omitempty"`
+	Appliers   int             `bson:"appliers,omitempty"`
+	Apply      string          `bson:"apply,omitempty"`
+	Archive    bool            `bson:"archive,omitempty"`
+	Block      int             `bson:"block,omitempty"`
+	BlockSize  int             `bson:"block_size,omitempty"`
+	Command    string          `bson:"command"`
+	Compressor string          `bson:"compressor,omitempty"`
+	Conflict   string          `bson:"conflict,omitempty"`
+	Cutover    *CutoverOptions `bson:"cutover,omitempty"`
+	Includes   Includes        `bson:"includes,omitempty"`
+	IsDrop     bool            `bson:"drop,omitempty"`
+	License    string          `bson:"license,omitempty"`
+	Port       int             `bson:"port,omitempty"`
+	Quota      int             `bson:"quota,omitempty"`
+	Secret     string          `bson:"secret,omitempty"`
+	Since      interface{}     `bson:"since,omitempty"`
+	Source     string          `bson:"source"`
+	Split      string          `bson:"split,omitempty"`
+	Spool      string          `bson:"spool,omitempty"`
+	Stream     string          `bson:"stream,omitempty"`
+	Target     string          `bson:"target"`
+	Verbose    bool            `bson:"verbose,omitempty"`
+	Workers    int             `bson:"workers,omitempty"`
+	Yes        bool            `bson:"yes,omitempty"`
 
+	cancel      context.CancelFunc
+	checker     *ConsistencyChecker
+	failure     error
 	genesis     time.Time
 	isExit      bool
 	included    map[string]*Include
 	mutex       sync.Mutex
 	replicas    map[string]string
+	running     sync.WaitGroup
+	sampled     map[string]map[string]bool
+	since       map[string]primitive.Timestamp
 	sourceStats *mdb.ClusterStats
+	spooler     *SpoolManager
 	streamers   []*OplogStreamer
 	targetStats *mdb.ClusterStats
 	workspace   Workspace
@@ -72,6 +92,10 @@ func NewMigratorInstance(filename string) (*Migrator, error) {
 	if err = inst.SourceStats().GetClusterStatsSummary(client); err != nil {
 		return migratorInstance, err
 	}
+	if inst.Stream == StreamChangeStream && !IsChangeStreamSupported(inst.SourceStats().Version) {
+		return migratorInstance, fmt.Errorf(`stream %v requires source v%v or later, found v%v, use %v instead`,
+			StreamChangeStream, ChangeStreamMinVersion, inst.SourceStats().Version, StreamOplog)
+	}
 	inst.targetStats = mdb.NewClusterStats("")
 	if client, err = GetMongoClient(inst.Target); err != nil {
 		return migratorInstance, fmt.Errorf("target GetMongoClient failed: %v", err)
@@ -86,9 +110,11 @@ func NewMigratorInstance(filename string) (*Migrator, error) {
 	}
 	// set uri map
 	inst.replicas = map[string]string{}
-	list, err := GetAllReplicas(inst.Source)
-	if err != nil {
-		return nil, fmt.Errorf("GetAllReplicas failed: %v", err)
+	list := []string{inst.Source} // a change stream reads the whole cluster from source
+	if inst.Stream != StreamChangeStream {
+		if list, err = GetAllReplicas(inst.Source); err != nil {
+			return nil, fmt.Errorf("GetAllReplicas failed: %v", err)
+		}
 	}
 	for _, replica := range list {
 		cs, err := mdb.ParseURI(replica)
@@ -127,12 +153,39 @@ func (inst *Migrator) AddOplogStreamer(streamer *OplogStreamer) {
 	inst.streamers = append(inst.streamers, streamer)
 }
 
+// Spooler returns the spool manager of cached oplog files
+func (inst *Migrator) Spooler() *SpoolManager {
+	inst.mutex.Lock()
+	defer inst.mutex.Unlock()
+	if inst.spooler == nil {
+		inst.spooler = NewSpoolManager(inst.Spool, inst.Compressor, int64(inst.Quota)*mb, inst.Archive)
+	}
+	return inst.spooler
+}
+
+// Checker returns the consistency checker of live streamed oplogs
+func (inst *Migrator) Checker() *ConsistencyChecker {
+	inst.mutex.Lock()
+	defer inst.mutex.Unlock()
+	if inst.checker == nil {
+		inst.checker = NewConsistencyChecker()
+	}
+	return inst.checker
+}
+
+// Streamers returns oplog streamers
+func (inst *Migrator) Streamers() []*OplogStreamer {
+	inst.mutex.Lock()
+	defer inst.mutex.Unlock()
+	return append([]*OplogStreamer{}, inst.streamers...)
+}
+
 // LiveStreamingOplogs set isExit to true
-func (inst *Migrator) LiveStreamingOplogs() {
+func (inst *Migrator) LiveStreamingOplogs(ctx context.Context) {
 	inst.mutex.Lock()
 	defer inst.mutex.Unlock()
 	for _, streamer := range inst.streamers {
-		streamer.LiveStream()
+		streamer.LiveStream(ctx)
 	}
 }
 
@@ -172,18 +225,23 @@ func (inst *Migrator) DropCollections() error {
 		}
 	} else {
 		for _, include := range inst.Included() {
-			dbName, collName := mdb.SplitNamespace(include.Namespace)
+			dbName, collName := mdb.SplitNamespace(inst.GetToNamespace(include.Namespace))
 			if collName == "" || collName == "*" {
 				logger.Debug("drop database " + dbName)
 				if err = client.Database(dbName).Drop(ctx); err != nil {
 					return err
 				}
-			} else {
-				if include.To != "" {
-					dbName, collName = mdb.SplitNamespace(include.To)
+				continue
+			}
+			dbNames := []string{dbName}
+			if dbName == "*" { // the collection of all databases
+				if dbNames, err = GetQualifiedDBs(client, MetaDBName); err != nil {
+					return err
 				}
-				logger.Infof("drop namespace %v.%v", dbName, collName)
-				if err = client.Database(dbName).Collection(collName).Drop(ctx); err != nil {
+			}
+			for _, name := range dbNames {
+				logger.Infof("drop namespace %v.%v", name, collName)
+				if err = client.Database(name).Collection(collName).Drop(ctx); err != nil {
 					return err
 				}
 			}
@@ -213,6 +271,14 @@ func (inst *Migrator) Workspace() Workspace {
 	return inst.workspace
 }
 
+// Streams returns names and URIs of oplog streams, a cluster-wide change stream or all shards/replicas
+func (inst *Migrator) Streams() map[string]string {
+	if inst.Stream == StreamChangeStream {
+		return map[string]string{ChangeStreamName: inst.Source}
+	}
+	return inst.replicas
+}
+
 // Included returns includes
 func (inst *Migrator) Included() map[string]*Include {
 	return inst.included
@@ -247,18 +313,78 @@ func (inst *Migrator) SkipNamespace(namespace string) bool {
 	if inst.included[collInAllDB] != nil || inst.included[namespace] != nil {
 		return false
 	}
+	if collName == "*" { // a database of which any collection is included
+		for ns := range inst.included {
+			if name, _ := mdb.SplitNamespace(ns); name == dbName || name == "*" {
+				return false
+			}
+		}
+	}
 	return true
 }
 
-// GetToNamespace returns target namespace
-func (inst *Migrator) GetToNamespace(ns string) string {
+// GetInclude returns the Include of a namespace, wildcard namespaces included
+func (inst *Migrator) GetInclude(namespace string) *Include {
 	if len(inst.included) == 0 {
+		return nil
+	}
+	if inst.included[namespace] != nil {
+		return inst.included[namespace]
+	}
+	dbName, collName := mdb.SplitNamespace(namespace)
+	if inst.included[dbName+".*"] != nil {
+		return inst.included[dbName+".*"]
+	}
+	return inst.included["*."+collName]
+}
+
+// hasFilters returns true if any included namespace has a filter
+func (inst *Migrator) hasFilters() bool {
+	for _, include := range inst.included {
+		if len(include.Filter) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// IsSampledID returns true if an _id of a namespace was sampled when splitting
+func (inst *Migrator) IsSampledID(namespace string, id interface{}) bool {
+	inst.mutex.Lock()
+	defer inst.mutex.Unlock()
+	if inst.sampled == nil {
+		inst.sampled = map[string]map[string]bool{}
+	}
+	if inst.sampled[namespace] == nil {
+		ids, err := inst.workspace.GetSampledIDs(namespace)
+		if err != nil {
+			gox.GetLogger("IsSampledID").Errorf("GetSampledIDs %v failed: %v", namespace, err)
+			return false
+		}
+		inst.sampled[namespace] = map[string]bool{}
+		for _, v := range ids {
+			inst.sampled[namespace][GetIDKey(v)] = true
+		}
+	}
+	return inst.sampled[namespace][GetIDKey(id)]
+}
+
+// GetToNamespace returns target namespace, a wildcard include maps the database, i.e. db.* to db2.*, or
+// the collection, i.e. *.coll to *.coll2
+func (inst *Migrator) GetToNamespace(ns string) string {
+	include := inst.GetInclude(ns)
+	if include == nil || include.To == "" {
 		return ns
 	}
-	if inst.included[ns] != nil && inst.included[ns].To != "" {
-		return inst.included[ns].To
+	dbName, collName := mdb.SplitNamespace(ns)
+	dbTo, collTo := mdb.SplitNamespace(include.To)
+	if dbTo == "*" {
+		dbTo = dbName
+	}
+	if collTo == "" || collTo == "*" {
+		collTo = collName
 	}
-	return ns
+	return dbTo + "." + collTo
 }
 
 // ReadMigratorConfig validates configuration from a file
@@ -282,11 +408,56 @@ func ValidateMigratorConfig(migrator *Migrator) error {
 		return fmt.Errorf(`source and target must have validate connection strings`)
 	} else if migrator.Workers > MaxNumberWorkers {
 		return fmt.Errorf("number of workers must be between 1 and %v", MaxNumberWorkers)
+	} else if migrator.Appliers > MaxNumberAppliers {
+		return fmt.Errorf("number of appliers must be between 1 and %v", MaxNumberAppliers)
 	} else if migrator.IsDrop && (migrator.Command == CommandData || migrator.Command == CommandDataOnly) {
 		return fmt.Errorf(`cannot set {"drop": true} when command is %v`, migrator.Command)
+	} else if migrator.IsDrop && migrator.Command == CommandOplog {
+		return fmt.Errorf(`cannot set {"drop": true} when command is %v`, migrator.Command)
+	} else if migrator.Since != nil && migrator.Command != CommandOplog {
+		return fmt.Errorf(`"since" is only supported when command is %v`, CommandOplog)
+	}
+	var err error
+	if migrator.since, err = ParseSince(migrator.Since); err != nil {
+		return fmt.Errorf(`invalid "since": %v`, err)
+	} else if _, ok := migrator.since[""]; !ok && len(migrator.since) > 0 && migrator.Stream == StreamChangeStream {
+		return fmt.Errorf(`"since" must be a single timestamp with %v`, StreamChangeStream)
 	}
 	var logger = gox.GetLogger("ValidateMigratorConfig")
 	var values []string
+	if migrator.Appliers < 1 {
+		values = append(values, fmt.Sprintf(`"appliers":%v`, 1))
+		migrator.Appliers = 1
+	}
+	if migrator.Compressor == "" {
+		values = append(values, fmt.Sprintf(`"compressor":"%v"`, CompressorGzip))
+		migrator.Compressor = CompressorGzip
+	} else if migrator.Compressor != CompressorGzip && migrator.Compressor != CompressorSnappy && migrator.Compressor != CompressorZstd {
+		return fmt.Errorf(`compressor must be one of %v, %v, or %v`, CompressorGzip, CompressorSnappy, CompressorZstd)
+	}
+	if migrator.Quota < 0 {
+		return fmt.Errorf(`quota must not be negative`)
+	}
+	if migrator.Conflict == "" {
+		values = append(values, fmt.Sprintf(`"conflict":"%v"`, ConflictIgnore))
+		migrator.Conflict = ConflictIgnore
+	} else if migrator.Conflict != ConflictFail && migrator.Conflict != ConflictIgnore && migrator.Conflict != ConflictUpsert {
+		return fmt.Errorf(`conflict must be one of %v, %v, or %v`, ConflictFail, ConflictIgnore, ConflictUpsert)
+	}
+	if migrator.Cutover != nil {
+		if err = ValidateCutoverOptions(migrator.Cutover); err != nil {
+			return err
+		}
+	}
+	if migrator.Apply == "" {
+		values = append(values, fmt.Sprintf(`"apply":"%v"`, ApplyNamespace))
+		migrator.Apply = ApplyNamespace
+	} else if migrator.Apply != ApplyNamespace && migrator.Apply != ApplyOrdered && migrator.Apply != ApplyParallel {
+		return fmt.Errorf(`apply must be one of %v, %v, or %v`, ApplyNamespace, ApplyOrdered, ApplyParallel)
+	}
+	if migrator.BlockSize < 0 {
+		return fmt.Errorf(`block_size must not be negative`)
+	}
 	if migrator.Block <= 0 {
 		values = append(values, fmt.Sprintf(`"block":%v`, MaxBlockSize))
 		migrator.Block = MaxBlockSize
@@ -299,6 +470,18 @@ func ValidateMigratorConfig(migrator *Migrator) error {
 		values = append(values, fmt.Sprintf(`"spool":"%v"`, DefaultSpool))
 		migrator.Spool = DefaultSpool
 	}
+	if migrator.Split == "" {
+		values = append(values, fmt.Sprintf(`"split":"%v"`, SplitAuto))
+		migrator.Split = SplitAuto
+	} else if migrator.Split != SplitAuto && migrator.Split != SplitScan {
+		return fmt.Errorf(`split must be either %v or %v`, SplitAuto, SplitScan)
+	}
+	if migrator.Stream == "" {
+		values = append(values, fmt.Sprintf(`"stream":"%v"`, StreamOplog))
+		migrator.Stream = StreamOplog
+	} else if migrator.Stream != StreamChangeStream && migrator.Stream != StreamOplog {
+		return fmt.Errorf(`stream must be either %v or %v`, StreamChangeStream, StreamOplog)
+	}
 	if migrator.Workers < 1 {
 		values = append(values, fmt.Sprintf(`"workers":%v`, NumberWorkers))
 		migrator.Workers = NumberWorkers
@@ -306,8 +489,7 @@ func ValidateMigratorConfig(migrator *Migrator) error {
 	if len(values) > 0 {
 		logger.Info("set default {", strings.Join(values, ","), "}")
 	}
-
-	return nil
+	return ValidateIncludes(migrator.Includes, migrator.Secret)
 }
 
 // checkIfBalancerDisabled returns balancer state
@@ -62,4 +62,149 @@ func TestSkipNamespace(t *testing.T) {
 	assertEqual(t, false, inst.SkipNamespace("dbname.collname"))
 	assertEqual(t, false, inst.SkipNamespace("db.collection"))
 	assertEqual(t, false, inst.SkipNamespace("database.coll"))
+	assertEqual(t, false, inst.SkipNamespace("dbname.*"))
+	assertEqual(t, true, inst.SkipNamespace("dbname.other"))
+	delete(inst.included, "*.coll")
+	assertEqual(t, true, inst.SkipNamespace("database.*"))
+}
+
+func TestIsSampledID(t *testing.T) {
+	inst := &Migrator{sampled: map[string]map[string]bool{}}
+	inst.sampled[TestNS] = map[string]bool{GetIDKey(int32(101)): true, GetIDKey("abc"): true}
+	assertEqual(t, true, inst.IsSampledID(TestNS, int32(101)))
+	assertEqual(t, true, inst.IsSampledID(TestNS, "abc"))
+	assertEqual(t, false, inst.IsSampledID(TestNS, int32(102)))
+}
+
+func TestValidateMigratorConfigMasks(t *testing.T) {
+	inst := &Migrator{Command: CommandAll, Source: TestSourceURI, Target: TestTargetURI,
+		Includes: Includes{&Include{Namespace: TestNS, Masks: []string{"ssn"}, Method: MaskHash}}}
+	err := ValidateMigratorConfig(inst)
+	assertNotEqual(t, nil, err)
+
+	inst.Secret = "secret"
+	err = ValidateMigratorConfig(inst)
+	assertEqual(t, nil, err)
+
+	inst.Includes[0].Method = "unknown"
+	err = ValidateMigratorConfig(inst)
+	assertNotEqual(t, nil, err)
+}
+
+func TestValidateMigratorConfigApply(t *testing.T) {
+	inst := &Migrator{Command: CommandAll, Source: TestSourceURI, Target: TestTargetURI}
+	err := ValidateMigratorConfig(inst)
+	assertEqual(t, nil, err)
+	assertEqual(t, ApplyNamespace, inst.Apply)
+
+	inst.Apply = ApplyOrdered
+	err = ValidateMigratorConfig(inst)
+	assertEqual(t, nil, err)
+
+	inst.Apply = "unknown"
+	err = ValidateMigratorConfig(inst)
+	assertNotEqual(t, nil, err)
+}
+
+func TestGetIncludeWildcard(t *testing.T) {
+	inst := &Migrator{included: map[string]*Include{}}
+	inst.included["db.*"] = &Include{Namespace: "db.*", Masks: []string{"**.ssn"}}
+	inst.included["*.coll"] = &Include{Namespace: "*.coll"}
+	inst.included["dbname.collname"] = &Include{Namespace: "dbname.collname"}
+	assertEqual(t, "db.*", inst.GetInclude("db.collection").Namespace)
+	assertEqual(t, "*.coll", inst.GetInclude("database.coll").Namespace)
+	assertEqual(t, "dbname.collname", inst.GetInclude("dbname.collname").Namespace)
+	assertEqual(t, (*Include)(nil), inst.GetInclude("dbname.other"))
+}
+
+func TestGetToNamespaceWildcard(t *testing.T) {
+	inst := &Migrator{included: map[string]*Include{}}
+	inst.included["db.*"] = &Include{Namespace: "db.*", To: "newdb.*"}
+	inst.included["*.coll"] = &Include{Namespace: "*.coll", To: "*.newcoll"}
+	inst.included["dbname.collname"] = &Include{Namespace: "dbname.collname", To: "newdb.newcoll"}
+	assertEqual(t, "newdb.collection", inst.GetToNamespace("db.collection"))
+	assertEqual(t, "database.newcoll", inst.GetToNamespace("database.coll"))
+	assertEqual(t, "newdb.newcoll", inst.GetToNamespace("dbname.collname"))
+	assertEqual(t, "dbname.other", inst.GetToNamespace("dbname.other"))
+	assertEqual(t, "*.newcoll", inst.GetToNamespace("*.coll"))
+}
+
+func TestValidateMigratorConfigConflict(t *testing.T) {
+	inst := &Migrator{Command: CommandAll, Source: TestSourceURI, Target: TestTargetURI}
+	err := ValidateMigratorConfig(inst)
+	assertEqual(t, nil, err)
+	assertEqual(t, ConflictIgnore, inst.Conflict)
+
+	inst.Conflict = ConflictUpsert
+	err = ValidateMigratorConfig(inst)
+	assertEqual(t, nil, err)
+
+	inst.Conflict = "unknown"
+	err = ValidateMigratorConfig(inst)
+	assertNotEqual(t, nil, err)
+}
+
+func TestValidateMigratorConfigStream(t *testing.T) {
+	inst := &Migrator{Command: CommandAll, Source: TestSourceURI, Target: TestTargetURI}
+	err := ValidateMigratorConfig(inst)
+	assertEqual(t, nil, err)
+	assertEqual(t, StreamOplog, inst.Stream)
+
+	inst.Stream = StreamChangeStream
+	err = ValidateMigratorConfig(inst)
+	assertEqual(t, nil, err)
+
+	inst.Stream = "unknown"
+	err = ValidateMigratorConfig(inst)
+	assertNotEqual(t, nil, err)
+}
+
+func TestValidateMigratorConfigSpool(t *testing.T) {
+	inst := &Migrator{Command: CommandAll, Source: TestSourceURI, Target: TestTargetURI}
+	err := ValidateMigratorConfig(inst)
+	assertEqual(t, nil, err)
+	assertEqual(t, CompressorGzip, inst.Compressor)
+
+	inst.Compressor = CompressorZstd
+	err = ValidateMigratorConfig(inst)
+	assertEqual(t, nil, err)
+
+	inst.Compressor = "lz4"
+	err = ValidateMigratorConfig(inst)
+	assertNotEqual(t, nil, err)
+
+	inst.Compressor = CompressorSnappy
+	inst.Quota = -1
+	err = ValidateMigratorConfig(inst)
+	assertNotEqual(t, nil, err)
+}
+
+func TestValidateMigratorConfigSince(t *testing.T) {
+	inst := &Migrator{Command: CommandOplog, Source: TestSourceURI, Target: TestTargetURI}
+	err := ValidateMigratorConfig(inst)
+	assertEqual(t, nil, err)
+	assertEqual(t, 0, len(inst.since))
+
+	inst.Since = "1650000000"
+	err = ValidateMigratorConfig(inst)
+	assertEqual(t, nil, err)
+	assertEqual(t, 1, len(inst.since))
+
+	inst.Since = "yesterday"
+	err = ValidateMigratorConfig(inst)
+	assertNotEqual(t, nil, err)
+
+	inst.Since = map[string]interface{}{"shard01": "1650000000"}
+	inst.Stream = StreamChangeStream
+	err = ValidateMigratorConfig(inst)
+	assertNotEqual(t, nil, err)
+
+	inst.Stream = StreamOplog
+	inst.IsDrop = true
+	err = ValidateMigratorConfig(inst)
+	assertNotEqual(t, nil, err)
+
+	inst = &Migrator{Command: CommandAll, Since: "1650000000", Source: TestSourceURI, Target: TestTargetURI}
+	err = ValidateMigratorConfig(inst)
+	assertNotEqual(t, nil, err)
 }
@@ -3,6 +3,7 @@
 package hummingbird
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
@@ -16,6 +17,8 @@ const (
 	DefaultSpool = "./spool"
 	// MaxBlockSize defines max batch size of a task
 	MaxBlockSize = 10000
+	// MaxNumberAppliers defines max number of concurrent oplog appliers of a replica set
+	MaxNumberAppliers = 32
 	// MaxNumberWorkers defines max number of concurrent workers
 	MaxNumberWorkers = 16
 	// NumberWorkers defines max number of concurrent workers
@@ -45,11 +48,21 @@ var fullVersion = "simagix/neutrino"
 func Neutrino(version string) error {
 	fullVersion = version
 	compare := flag.String("compare", "", "deep two clusters")
+	config := flag.String("config", "", "-oplog replay with includes, masks, and mappings of a configuration file")
+	cutover := flag.String("cutover", "", "cut over a running migration of a configuration file")
+	from := flag.String("from", "", "-oplog from a timestamp, seconds[.ordinal] or RFC3339")
+	ns := flag.String("ns", "", "-oplog of a namespace")
+	op := flag.String("op", "", "-oplog of an operation, i|u|d|c|n")
+	oplog := flag.String(CommandOplog, "", "list|dump|replay cached oplogs of files or directories")
 	resume := flag.String("resume", "", "resume a migration from a configuration file")
 	sim := flag.String("sim", "", "simulate data gen")
 	start := flag.String("start", "", "start a migration from a configuration file")
+	target := flag.String("target", "", "target connection string to -oplog replay")
+	to := flag.String("to", "", "-oplog to a timestamp, seconds[.ordinal] or RFC3339")
+	verify := flag.String("verify", "", "verify copied data and re-copy mismatched ranges of a configuration file")
 	ver := flag.Bool("version", false, "print version info")
 	worker := flag.String("worker", "", "start a neutrino worker")
+	workspace := flag.String("workspace", "", "connection string to record conflicts of -oplog replay")
 
 	flag.Parse()
 	flagset := make(map[string]bool)
@@ -60,14 +73,27 @@ func Neutrino(version string) error {
 		return nil
 	}
 	logger := gox.GetLogger(version, false) // print version and disable in-mem logs
+	ctx, cancel := NotifyShutdown(context.Background())
+	defer cancel()
 	if *compare != "" {
 		return Compare(*compare)
+	} else if *cutover != "" {
+		return RequestCutover(*cutover)
+	} else if *oplog != "" {
+		filter, err := NewOplogFilter(*ns, *op, *from, *to)
+		if err != nil {
+			return fmt.Errorf("NewOplogFilter failed: %v", err)
+		}
+		return InspectOplogs(*oplog, flag.Args(), filter,
+			ReplayOptions{Config: *config, Target: *target, Workspace: *workspace})
 	} else if *resume != "" {
-		return Resume(*resume)
+		return Resume(ctx, *resume)
 	} else if *sim != "" {
 		return Simulate(*sim)
 	} else if *start != "" {
-		return Start(*start)
+		return Start(ctx, *start)
+	} else if *verify != "" {
+		return Verify(ctx, *verify)
 	} else if *worker != "" {
 		inst, err := NewMigratorInstance(*worker)
 		if err != nil {
@@ -77,10 +103,16 @@ func Neutrino(version string) error {
 		for i := 0; i < inst.Workers; i++ { // start all workers
 			procID := fmt.Sprintf("%v.%v", os.Getpid(), i+1)
 			wg.Add(1)
-			go Worker(procID)
+			go func(procID string) {
+				defer wg.Done()
+				Worker(ctx, procID)
+			}(procID)
 			time.Sleep(10 * time.Millisecond)
 		}
 		wg.Wait()
+		if ctx.Err() != nil {
+			logger.Remark("workers shut down gracefully")
+		}
 		return nil
 	}
 	logger.Info(version)
@@ -0,0 +1,218 @@
+// Copyright Kuei-chun Chen, 2022-present. All rights reserved.
+
+package hummingbird
+
+import (
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/simagix/gox"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// OplogApplier applies oplogs concurrently by partitions of hashed namespace and _id, so that oplogs
+// of a document are applied in order.  DDL commands are barriers applied alone in order, and a transaction
+// waits only for partitions of its documents.
+type OplogApplier struct {
+	SetName string
+
+	errs       chan error // errors since last flush
+	mutex      sync.Mutex
+	partitions []*oplogPartition
+}
+
+type oplogPartition struct {
+	depth   int
+	pending sync.WaitGroup
+	queue   chan []Oplog
+	waiting []primitive.Timestamp // first timestamps of queued batches
+}
+
+// NewOplogApplier returns an OplogApplier and starts its applier goroutines
+func NewOplogApplier(setName string, appliers int) *OplogApplier {
+	if appliers < 1 {
+		appliers = 1
+	}
+	applier := &OplogApplier{SetName: setName, errs: make(chan error, appliers)}
+	for i := 0; i < appliers; i++ {
+		partition := &oplogPartition{queue: make(chan []Oplog, appliers)}
+		applier.partitions = append(applier.partitions, partition)
+		go applier.run(partition)
+	}
+	return applier
+}
+
+// run applies oplogs of a partition until its queue is closed
+func (a *OplogApplier) run(partition *oplogPartition) {
+	logger := gox.GetLogger("OplogApplier")
+	for oplogs := range partition.queue {
+		if _, err := BulkWriteOplogs(oplogs); err != nil { // returned by Flush
+			logger.Errorf("%v BulkWriteOplogs failed: %v", a.SetName, err)
+			select {
+			case a.errs <- err:
+			default: // earlier errors are pending
+			}
+		}
+		a.mutex.Lock()
+		partition.depth -= len(oplogs)
+		partition.waiting = partition.waiting[1:]
+		a.mutex.Unlock()
+		partition.pending.Done()
+	}
+}
+
+// Apply dispatches oplogs to partitions, it returns before oplogs are applied unless barriers are met
+func (a *OplogApplier) Apply(oplogs []Oplog) {
+	if len(a.partitions) == 1 {
+		a.dispatch(0, oplogs)
+		return
+	}
+	batches := make([][]Oplog, len(a.partitions))
+	flush := func() {
+		for i, batch := range batches {
+			if len(batch) > 0 {
+				a.dispatch(i, batch)
+			}
+		}
+		batches = make([][]Oplog, len(a.partitions))
+	}
+	for _, oplog := range oplogs {
+		if oplog.Operation != "c" {
+			n := GetOplogPartition(oplog, len(a.partitions))
+			batches[n] = append(batches[n], oplog)
+			continue
+		}
+		partitions := getTxnPartitions(oplog, len(a.partitions))
+		if len(partitions) == 1 { // in order with other oplogs of the partition
+			batches[partitions[0]] = append(batches[partitions[0]], oplog)
+			continue
+		}
+		flush()
+		if len(partitions) == 0 { // a DDL command is a barrier
+			a.wait(nil)
+			a.dispatch(0, []Oplog{oplog})
+			a.wait([]int{0})
+			continue
+		}
+		a.wait(partitions)
+		a.dispatch(partitions[0], []Oplog{oplog})
+		a.wait(partitions[:1])
+	}
+	flush()
+}
+
+func (a *OplogApplier) dispatch(n int, oplogs []Oplog) {
+	if len(oplogs) == 0 {
+		return
+	}
+	a.mutex.Lock()
+	a.partitions[n].depth += len(oplogs)
+	a.partitions[n].waiting = append(a.partitions[n].waiting, oplogs[0].Timestamp)
+	a.mutex.Unlock()
+	a.partitions[n].pending.Add(1)
+	a.partitions[n].queue <- oplogs
+}
+
+// wait waits until dispatched oplogs of partitions are applied, all partitions if nil
+func (a *OplogApplier) wait(partitions []int) {
+	if partitions == nil {
+		for _, partition := range a.partitions {
+			partition.pending.Wait()
+		}
+		return
+	}
+	for _, n := range partitions {
+		a.partitions[n].pending.Wait()
+	}
+}
+
+// Flush waits until all dispatched oplogs are applied and returns the first error since last flush
+func (a *OplogApplier) Flush() error {
+	a.wait(nil)
+	var err error
+	for {
+		select {
+		case e := <-a.errs:
+			if err == nil {
+				err = e
+			}
+		default:
+			return err
+		}
+	}
+}
+
+// Close waits until all dispatched oplogs are applied and stops applier goroutines
+func (a *OplogApplier) Close() error {
+	err := a.Flush()
+	for _, partition := range a.partitions {
+		close(partition.queue)
+	}
+	return err
+}
+
+// GetQueueDepths returns number of oplogs waiting to be applied by partitions
+func (a *OplogApplier) GetQueueDepths() []int {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	depths := []int{}
+	for _, partition := range a.partitions {
+		depths = append(depths, partition.depth)
+	}
+	return depths
+}
+
+// GetLag returns time since the oldest oplog waiting to be applied, 0 if none
+func (a *OplogApplier) GetLag() time.Duration {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	lag := time.Duration(0)
+	for _, partition := range a.partitions {
+		if len(partition.waiting) == 0 {
+			continue
+		}
+		if d := time.Since(time.Unix(int64(partition.waiting[0].T), 0)); d > lag {
+			lag = d
+		}
+	}
+	return lag.Truncate(time.Second)
+}
+
+// GetOplogPartition returns partition of an oplog by hashing its namespace and _id
+func GetOplogPartition(oplog Oplog, partitions int) int {
+	if partitions < 2 {
+		return 0
+	}
+	h := fnv.New32a()
+	h.Write([]byte(oplog.Namespace))
+	h.Write([]byte(GetIDKey(getOplogID(oplog))))
+	return int(h.Sum32() % uint32(partitions))
+}
+
+// getTxnPartitions returns sorted partitions of writes of a transaction, none if a DDL command
+func getTxnPartitions(oplog Oplog, partitions int) []int {
+	ops, ok := oplog.Object.Map()["applyOps"].(primitive.A)
+	if !ok {
+		return nil
+	}
+	found := map[int]bool{}
+	list := []int{}
+	for _, op := range ops {
+		var inlog Oplog
+		data, err := bson.Marshal(op)
+		if err != nil {
+			return nil
+		} else if err = bson.Unmarshal(data, &inlog); err != nil || inlog.Operation == "c" {
+			return nil // a barrier
+		}
+		if n := GetOplogPartition(inlog, partitions); !found[n] {
+			found[n] = true
+			list = append(list, n)
+		}
+	}
+	sort.Ints(list)
+	return list
+}
@@ -0,0 +1,49 @@
+// Copyright Kuei-chun Chen, 2022-present. All rights reserved.
+
+package hummingbird
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestGetOplogPartition(t *testing.T) {
+	insert := Oplog{Namespace: TestNS, Operation: "i", Object: bson.D{{"_id", 101}, {"a", 1}}}
+	update := Oplog{Namespace: TestNS, Operation: "u", Object: bson.D{{"$set", bson.D{{"a", 2}}}},
+		Query: bson.D{{"_id", 101}}}
+	remove := Oplog{Namespace: TestNS, Operation: "d", Object: bson.D{{"_id", 101}}}
+	n := GetOplogPartition(insert, 8)
+	assertEqual(t, n, GetOplogPartition(update, 8))
+	assertEqual(t, n, GetOplogPartition(remove, 8))
+	assertEqual(t, 0, GetOplogPartition(insert, 1))
+
+	partitions := map[int]bool{}
+	for i := 0; i < 100; i++ {
+		partitions[GetOplogPartition(Oplog{Namespace: TestNS, Operation: "d", Object: bson.D{{"_id", i}}}, 8)] = true
+	}
+	assertEqual(t, 8, len(partitions))
+}
+
+func TestGetTxnPartitions(t *testing.T) {
+	ops := bson.A{}
+	for i := 0; i < 20; i++ {
+		ops = append(ops, bson.D{{"op", "i"}, {"ns", TestNS}, {"o", bson.D{{"_id", i}}}})
+	}
+	txn := Oplog{Namespace: "admin.$cmd", Operation: "c", Object: bson.D{{"applyOps", ops[:1]}}}
+	partitions := getTxnPartitions(txn, 8)
+	assertEqual(t, 1, len(partitions))
+	assertEqual(t, GetOplogPartition(Oplog{Namespace: TestNS, Operation: "i", Object: bson.D{{"_id", 0}}}, 8), partitions[0])
+	txn.Object = bson.D{{"applyOps", ops}}
+	assertEqual(t, 8, len(getTxnPartitions(txn, 8)))
+
+	drop := Oplog{Namespace: "testdb.$cmd", Operation: "c", Object: bson.D{{"drop", "neutrino"}}}
+	assertEqual(t, 0, len(getTxnPartitions(drop, 8)))
+}
+
+func TestOplogApplierClose(t *testing.T) {
+	applier := NewOplogApplier("replset", 4)
+	assertEqual(t, 4, len(applier.GetQueueDepths()))
+	assertEqual(t, nil, applier.Flush())
+	assertEqual(t, nil, applier.Close())
+}
@@ -0,0 +1,185 @@
+// Copyright Kuei-chun Chen, 2022-present. All rights reserved.
+
+package hummingbird
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/simagix/gox"
+	"github.com/simagix/keyhole/mdb"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// CmdCollMod modifies a collection
+	CmdCollMod = "collMod"
+	// CmdCommitIndexBuild commits a two-phase index build
+	CmdCommitIndexBuild = "commitIndexBuild"
+	// CmdCreate creates a collection
+	CmdCreate = "create"
+	// CmdCreateIndexes creates indexes
+	CmdCreateIndexes = "createIndexes"
+	// CmdDrop drops a collection
+	CmdDrop = "drop"
+	// CmdDropDatabase drops a database
+	CmdDropDatabase = "dropDatabase"
+	// CmdDropIndexes drops indexes
+	CmdDropIndexes = "dropIndexes"
+	// CmdRenameCollection renames a collection
+	CmdRenameCollection = "renameCollection"
+)
+
+// getCommandNamespace returns the namespace a command oplog applies to
+func getCommandNamespace(oplog Oplog) string {
+	if len(oplog.Object) == 0 {
+		return ""
+	}
+	dbName, _ := mdb.SplitNamespace(oplog.Namespace)
+	cmd := oplog.Object[0]
+	switch cmd.Key {
+	case CmdDropDatabase:
+		return dbName + ".*"
+	case CmdRenameCollection:
+		ns, _ := cmd.Value.(string)
+		return ns
+	case CmdCollMod, CmdCommitIndexBuild, CmdCreate, CmdCreateIndexes, CmdDrop, CmdDropIndexes:
+		collName, _ := cmd.Value.(string)
+		return dbName + "." + collName
+	}
+	return ""
+}
+
+// getRenameTarget returns the target namespace of a renameCollection oplog
+func getRenameTarget(oplog Oplog) string {
+	if len(oplog.Object) == 0 || oplog.Object[0].Key != CmdRenameCollection {
+		return ""
+	}
+	for _, v := range oplog.Object[1:] {
+		if v.Key == "to" {
+			ns, _ := v.Value.(string)
+			return ns
+		}
+	}
+	return ""
+}
+
+// getCommandWriteModels returns a DDL command, mapped to the target namespace, from an oplog
+func getCommandWriteModels(oplog Oplog) []OplogWriteModel {
+	if len(oplog.Object) == 0 {
+		return nil
+	}
+	inst := GetMigratorInstance()
+	logger := gox.GetLogger("GetWriteModels")
+	cmd := oplog.Object[0]
+	ns := getCommandNamespace(oplog)
+	toDB, toColl := mdb.SplitNamespace(inst.GetToNamespace(ns))
+	command := bson.D{}
+	switch cmd.Key {
+	case CmdDropDatabase:
+		if len(inst.Included()) > 0 && inst.GetInclude(ns) == nil {
+			return getDropCollectionWriteModels(ns)
+		}
+		command = bson.D{{CmdDropDatabase, 1}}
+	case CmdRenameCollection:
+		to := getRenameTarget(oplog)
+		if inst.SkipNamespace(to) { // renamed out of included collections
+			command = bson.D{{CmdDrop, toColl}}
+			break
+		} else if inst.SkipNamespace(ns) { // renamed from a collection not migrated
+			logger.Warnf("%v renamed to %v, documents of %v are not migrated", ns, to, to)
+			if !isDropTarget(oplog) {
+				return nil
+			}
+			toDB, toColl = mdb.SplitNamespace(inst.GetToNamespace(to))
+			command = bson.D{{CmdDrop, toColl}}
+			break
+		}
+		toDB = "admin"
+		command = bson.D{{CmdRenameCollection, inst.GetToNamespace(ns)}}
+		for _, v := range oplog.Object[1:] {
+			if v.Key == "to" {
+				command = append(command, bson.E{Key: "to", Value: inst.GetToNamespace(to)})
+			} else if v.Key == "dropTarget" || v.Key == "stayTemp" {
+				if b, ok := v.Value.(bool); ok {
+					command = append(command, bson.E{Key: v.Key, Value: b})
+				}
+			}
+		}
+	case CmdCommitIndexBuild:
+		command = bson.D{{CmdCreateIndexes, toColl}}
+		for _, v := range oplog.Object[1:] {
+			if v.Key == "indexes" {
+				command = append(command, v)
+			}
+		}
+	case CmdCreateIndexes: // an index spec follows
+		spec := bson.D{}
+		for _, v := range oplog.Object[1:] {
+			spec = append(spec, v)
+		}
+		command = bson.D{{CmdCreateIndexes, toColl}, {"indexes", bson.A{spec}}}
+	case CmdCollMod, CmdCreate, CmdDrop, CmdDropIndexes:
+		command = bson.D{{cmd.Key, toColl}}
+		for _, v := range oplog.Object[1:] {
+			if v.Key == "idIndex" { // created by default
+				continue
+			}
+			command = append(command, v)
+		}
+	default:
+		logger.Debugf("command %v is ignored", cmd.Key)
+		return nil
+	}
+	return []OplogWriteModel{{Namespace: toDB + ".$cmd", Operation: "c", Command: command}}
+}
+
+// isDropTarget returns true if a renameCollection oplog drops an existing target collection
+func isDropTarget(oplog Oplog) bool {
+	for _, v := range oplog.Object[1:] {
+		if v.Key == "dropTarget" {
+			b, ok := v.Value.(bool)
+			return !ok || b // a UUID of the dropped collection
+		}
+	}
+	return false
+}
+
+// getDropCollectionWriteModels returns drop commands of included collections of a database dropped
+// while not all of its collections are included
+func getDropCollectionWriteModels(ns string) []OplogWriteModel {
+	inst := GetMigratorInstance()
+	dbName, _ := mdb.SplitNamespace(ns)
+	namespaces := []string{}
+	for name := range inst.Included() {
+		if db, collName := mdb.SplitNamespace(name); db == dbName || db == "*" {
+			namespaces = append(namespaces, dbName+"."+collName)
+		}
+	}
+	sort.Strings(namespaces)
+	wmodels := []OplogWriteModel{}
+	for _, name := range namespaces {
+		toDB, toColl := mdb.SplitNamespace(inst.GetToNamespace(name))
+		wmodels = append(wmodels, OplogWriteModel{Namespace: toDB + ".$cmd", Operation: "c",
+			Command: bson.D{{CmdDrop, toColl}}})
+	}
+	return wmodels
+}
+
+// RunCommandWriteModel runs a DDL command at target, errors of existing or missing namespaces are ignored,
+// and conflicting indexes are returned as errors
+func RunCommandWriteModel(client *mongo.Client, wmodel OplogWriteModel) error {
+	dbName, _ := mdb.SplitNamespace(wmodel.Namespace)
+	err := client.Database(dbName).RunCommand(context.Background(), wmodel.Command).Err()
+	if err == nil {
+		return nil
+	}
+	code := mdb.GetErrorCode(err)
+	if code == 26 || code == 27 || code == 48 { // NamespaceNotFound, IndexNotFound, NamespaceExists
+		gox.GetLogger("RunCommandWriteModel").Debugf("%v ignored: %v", Stringify(wmodel.Command), err)
+		return nil
+	}
+	return fmt.Errorf("%v failed: %v", Stringify(wmodel.Command), err)
+}
@@ -0,0 +1,60 @@
+// Copyright Kuei-chun Chen, 2022-present. All rights reserved.
+
+package hummingbird
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestGetCommandWriteModels(t *testing.T) {
+	migratorInstance = &Migrator{included: map[string]*Include{}}
+	migratorInstance.included["testdb.neutrino"] = &Include{Namespace: "testdb.neutrino", To: "newdb.vehicles"}
+	migratorInstance.included["testdb.renamed"] = &Include{Namespace: "testdb.renamed", To: "newdb.renamed"}
+	tests := [][]string{
+		{`{ "create": "neutrino", "idIndex": { "v": 2, "key": { "_id": 1 }, "name": "_id_" } }`,
+			`newdb.$cmd {"create":"vehicles"}`},
+		{`{ "drop": "neutrino" }`,
+			`newdb.$cmd {"drop":"vehicles"}`},
+		{`{ "createIndexes": "neutrino", "v": 2, "key": { "a": 1 }, "name": "a_1" }`,
+			`newdb.$cmd {"createIndexes":"vehicles","indexes":[{"v":2,"key":{"a":1},"name":"a_1"}]}`},
+		{`{ "commitIndexBuild": "neutrino", "indexBuildUUID": 1, "indexes": [ { "v": 2, "key": { "a": 1 }, "name": "a_1" } ] }`,
+			`newdb.$cmd {"createIndexes":"vehicles","indexes":[{"v":2,"key":{"a":1},"name":"a_1"}]}`},
+		{`{ "dropIndexes": "neutrino", "index": "a_1" }`,
+			`newdb.$cmd {"dropIndexes":"vehicles","index":"a_1"}`},
+		{`{ "collMod": "neutrino", "validationLevel": "off" }`,
+			`newdb.$cmd {"collMod":"vehicles","validationLevel":"off"}`},
+		{`{ "renameCollection": "testdb.neutrino", "to": "testdb.renamed", "stayTemp": false }`,
+			`admin.$cmd {"renameCollection":"newdb.vehicles","to":"newdb.renamed","stayTemp":false}`},
+		{`{ "renameCollection": "testdb.neutrino", "to": "testdb.other" }`,
+			`newdb.$cmd {"drop":"vehicles"}`},
+		{`{ "renameCollection": "testdb.other", "to": "testdb.renamed", "dropTarget": true }`,
+			`newdb.$cmd {"drop":"renamed"}`},
+	}
+	for _, test := range tests {
+		oplog := Oplog{Namespace: "testdb.$cmd", Operation: "c"}
+		err := bson.UnmarshalExtJSON([]byte(test[0]), false, &oplog.Object)
+		assertEqual(t, nil, err)
+		wmodels := GetWriteModels(oplog)
+		assertEqual(t, 1, len(wmodels))
+		assertEqual(t, "c", wmodels[0].Operation)
+		data, err := bson.MarshalExtJSON(wmodels[0].Command, false, false)
+		assertEqual(t, nil, err)
+		assertEqual(t, test[1], wmodels[0].Namespace+" "+string(data))
+	}
+	oplog := Oplog{Namespace: "testdb.$cmd", Operation: "c", Object: bson.D{{"startIndexBuild", "neutrino"}}}
+	assertEqual(t, 0, len(GetWriteModels(oplog)))
+	oplog.Object = bson.D{{"renameCollection", "testdb.other"}, {"to", "testdb.renamed"}, {"dropTarget", false}}
+	assertEqual(t, 0, len(GetWriteModels(oplog)))
+
+	oplog.Object = bson.D{{"dropDatabase", 1}} // only some collections are included
+	wmodels := GetWriteModels(oplog)
+	assertEqual(t, 2, len(wmodels))
+	assertEqual(t, `{"drop":"vehicles"}`, Stringify(wmodels[0].Command))
+	assertEqual(t, `{"drop":"renamed"}`, Stringify(wmodels[1].Command))
+	migratorInstance.included["testdb.*"] = &Include{Namespace: "testdb.*"}
+	wmodels = GetWriteModels(oplog)
+	assertEqual(t, 1, len(wmodels))
+	assertEqual(t, `testdb.$cmd {"dropDatabase":1}`, wmodels[0].Namespace+" "+Stringify(wmodels[0].Command))
+}
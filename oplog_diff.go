@@ -0,0 +1,79 @@
+// Copyright Kuei-chun Chen, 2022-present. All rights reserved.
+
+package hummingbird
+
+import (
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// GetDiffUpdates translates a $v:2 delta oplog diff to update documents to be applied in order, a
+// $set and $unset update followed by $push updates truncating resized arrays, inner arrays first.
+// A document diff has fields d (delete), u (update), i (insert), and s<field> (subdiff).  An array
+// diff has fields a (true), l (new length), u<index> (update), and s<index> (subdiff).
+func GetDiffUpdates(diff bson.D) []bson.D {
+	sets := bson.D{}
+	unsets := bson.D{}
+	resizes := []bson.D{}
+	translateDiff("", diff, &sets, &unsets, &resizes)
+	updates := []bson.D{}
+	update := bson.D{}
+	if len(sets) > 0 {
+		update = append(update, bson.E{Key: "$set", Value: sets})
+	}
+	if len(unsets) > 0 {
+		update = append(update, bson.E{Key: "$unset", Value: unsets})
+	}
+	if len(update) > 0 {
+		updates = append(updates, update)
+	}
+	for _, resize := range resizes {
+		updates = append(updates, bson.D{{"$push", resize}})
+	}
+	return updates
+}
+
+// translateDiff appends dotted paths of a diff to sets, unsets, and array truncations
+func translateDiff(path string, diff bson.D, sets *bson.D, unsets *bson.D, resizes *[]bson.D) {
+	isArray := false
+	for _, v := range diff {
+		if v.Key == "a" && v.Value == true {
+			isArray = true
+		}
+	}
+	var length interface{}
+	for _, v := range diff {
+		if v.Key == "a" {
+			continue
+		} else if v.Key == "l" && isArray {
+			length = v.Value
+		} else if v.Key == "d" && !isArray {
+			fields, _ := v.Value.(bson.D)
+			for _, field := range fields {
+				*unsets = append(*unsets, bson.E{Key: getDiffPath(path, field.Key), Value: ""})
+			}
+		} else if (v.Key == "u" || v.Key == "i") && !isArray {
+			fields, _ := v.Value.(bson.D)
+			for _, field := range fields {
+				*sets = append(*sets, bson.E{Key: getDiffPath(path, field.Key), Value: field.Value})
+			}
+		} else if strings.HasPrefix(v.Key, "u") && isArray {
+			*sets = append(*sets, bson.E{Key: getDiffPath(path, v.Key[1:]), Value: v.Value})
+		} else if strings.HasPrefix(v.Key, "s") {
+			if subdiff, ok := v.Value.(bson.D); ok {
+				translateDiff(getDiffPath(path, v.Key[1:]), subdiff, sets, unsets, resizes)
+			}
+		}
+	}
+	if length != nil { // after inner arrays
+		*resizes = append(*resizes, bson.D{{path, bson.D{{"$each", bson.A{}}, {"$slice", length}}}})
+	}
+}
+
+func getDiffPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,75 @@
+// Copyright Kuei-chun Chen, 2022-present. All rights reserved.
+
+package hummingbird
+
+import (
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestGetDiffUpdates(t *testing.T) {
+	tests := [][]string{
+		// update, insert, and delete fields
+		{`{ "u": { "a": 1 } }`, `{"$set":{"a":1}}`},
+		{`{ "i": { "b": "x" } }`, `{"$set":{"b":"x"}}`},
+		{`{ "d": { "c": false } }`, `{"$unset":{"c":""}}`},
+		{`{ "d": { "c": false }, "u": { "a": 1 }, "i": { "b": "x" } }`, `{"$set":{"a":1,"b":"x"},"$unset":{"c":""}}`},
+		// nested subdiffs
+		{`{ "sa": { "u": { "b": 1 }, "d": { "c": false } } }`, `{"$set":{"a.b":1},"$unset":{"a.c":""}}`},
+		{`{ "sa": { "sb": { "i": { "c": { "d": 1 } } } } }`, `{"$set":{"a.b.c":{"d":1}}}`},
+		// array diffs
+		{`{ "sarr": { "a": true, "u1": "y" } }`, `{"$set":{"arr.1":"y"}}`},
+		{`{ "sarr": { "a": true, "u2": 3, "u3": 4 } }`, `{"$set":{"arr.2":3,"arr.3":4}}`},
+		{`{ "sarr": { "a": true, "s0": { "u": { "qty": 2 } } } }`, `{"$set":{"arr.0.qty":2}}`},
+		{`{ "sarr": { "a": true, "l": 2 } }`, `{"$push":{"arr":{"$each":[],"$slice":2}}}`},
+		{`{ "sarr": { "a": true, "l": 3, "u2": "z" } }`, `{"$set":{"arr.2":"z"}}|{"$push":{"arr":{"$each":[],"$slice":3}}}`},
+		// nested arrays, inner arrays truncated first
+		{`{ "sarr": { "a": true, "l": 2, "s1": { "a": true, "l": 1, "s0": { "a": true, "u0": 9 } } } }`,
+			`{"$set":{"arr.1.0.0":9}}|{"$push":{"arr.1":{"$each":[],"$slice":1}}}|{"$push":{"arr":{"$each":[],"$slice":2}}}`},
+		{`{ "sarr": { "a": true, "s1": { "sitems": { "a": true, "l": 0 } } } }`,
+			`{"$push":{"arr.1.items":{"$each":[],"$slice":0}}}`},
+		// mixed
+		{`{ "u": { "a": 1 }, "d": { "b": false }, "sc": { "a": true, "l": 1, "s0": { "i": { "e": 1 } } } }`,
+			`{"$set":{"a":1,"c.0.e":1},"$unset":{"b":""}}|{"$push":{"c":{"$each":[],"$slice":1}}}`},
+		{`{}`, ``},
+	}
+	for _, test := range tests {
+		var diff bson.D
+		err := bson.UnmarshalExtJSON([]byte(test[0]), false, &diff)
+		assertEqual(t, nil, err)
+		updates := []string{}
+		for _, update := range GetDiffUpdates(diff) {
+			updates = append(updates, Stringify(update))
+		}
+		assertEqual(t, test[1], strings.Join(updates, "|"))
+	}
+}
+
+func TestGetWriteModelsDiff(t *testing.T) {
+	migratorInstance = &Migrator{included: map[string]*Include{}}
+	tests := map[string]int{
+		`{ "op": "u", "ns": "testdb.neutrino", "o": { "$v": 2, "diff": { "u": { "a": 1 }, "d": { "b": false } } }, "o2": { "_id": 1 } }`:   1,
+		`{ "op": "u", "ns": "testdb.neutrino", "o": { "$v": 2, "diff": { "sarr": { "a": true, "l": 1 } } }, "o2": { "_id": 1 } }`:          1,
+		`{ "op": "u", "ns": "testdb.neutrino", "o": { "$v": 2, "diff": { "sarr": { "a": true, "l": 2, "u1": 0 } } }, "o2": { "_id": 1 } }`: 2,
+		`{ "op": "u", "ns": "testdb.neutrino", "o": { "$v": 2, "diff": {} }, "o2": { "_id": 1 } }`:                                         0,
+		`{ "op": "u", "ns": "testdb.neutrino", "o": { "$v": 1, "$set": { "a": 1 }, "$unset": { "b": true } }, "o2": { "_id": 1 } }`:        1,
+	}
+	for doc, expected := range tests {
+		var oplog Oplog
+		err := bson.UnmarshalExtJSON([]byte(doc), false, &oplog)
+		assertEqual(t, nil, err)
+		wmodels := GetWriteModels(oplog)
+		assertEqual(t, expected, len(wmodels))
+	}
+	var oplog Oplog
+	doc := `{ "op": "u", "ns": "testdb.neutrino", "o": { "$v": 1, "$set": { "a": 1 }, "$unset": { "b": true } }, "o2": { "_id": 1 } }`
+	err := bson.UnmarshalExtJSON([]byte(doc), false, &oplog)
+	assertEqual(t, nil, err)
+	data, err := bson.MarshalExtJSON(GetWriteModels(oplog)[0].WriteModel, false, false)
+	assertEqual(t, nil, err)
+	if !strings.Contains(string(data), `"$set"`) || !strings.Contains(string(data), `"$unset"`) {
+		t.Fatalf("expected $set and $unset: %v", string(data))
+	}
+}
@@ -0,0 +1,309 @@
+// Copyright Kuei-chun Chen, 2022-present. All rights reserved.
+
+package hummingbird
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/simagix/gox"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// OplogDump dumps cached oplogs as extended JSON
+	OplogDump = "dump"
+	// OplogList lists cached oplog files with timestamp ranges and op counts
+	OplogList = "list"
+	// OplogReplay replays cached oplogs onto a target
+	OplogReplay = "replay"
+)
+
+// OplogFilter filters oplogs by namespace, operation, and an inclusive time range
+type OplogFilter struct {
+	From      *primitive.Timestamp
+	Namespace string
+	Operation string
+	To        *primitive.Timestamp
+}
+
+// ReplayOptions stores options of replaying oplogs
+type ReplayOptions struct {
+	Config    string // configuration file of includes, masks, and mappings
+	Target    string
+	Workspace string // connection string of _neutrino.conflicts, conflicts are not recorded if empty
+}
+
+// NewOplogFilter returns an OplogFilter, timestamps are seconds[.ordinal] or RFC3339
+func NewOplogFilter(namespace string, operation string, from string, to string) (OplogFilter, error) {
+	filter := OplogFilter{Namespace: namespace, Operation: operation}
+	for i, s := range []string{from, to} {
+		if s == "" {
+			continue
+		}
+		ts, err := ParseOplogTimestamp(s)
+		if err != nil {
+			return filter, err
+		}
+		if i == 0 {
+			filter.From = &ts
+		} else {
+			filter.To = &ts
+		}
+	}
+	return filter, nil
+}
+
+// Match returns true if an oplog matches the filter, an applyOps matches if any of its oplogs matches
+// the namespace
+func (f OplogFilter) Match(oplog Oplog) bool {
+	if f.Operation != "" && f.Operation != oplog.Operation {
+		return false
+	}
+	if f.From != nil && primitive.CompareTimestamp(oplog.Timestamp, *f.From) < 0 {
+		return false
+	}
+	if f.To != nil && primitive.CompareTimestamp(oplog.Timestamp, *f.To) > 0 {
+		return false
+	}
+	if f.Namespace == "" || oplog.Namespace == f.Namespace {
+		return true
+	}
+	if ops, ok := oplog.Object.Map()["applyOps"].(primitive.A); ok {
+		for _, op := range ops {
+			if doc, ok := op.(bson.D); ok && doc.Map()["ns"] == f.Namespace {
+				return true
+			}
+		}
+	}
+	return getCommandNamespace(oplog) == f.Namespace
+}
+
+// ParseOplogTimestamp parses a timestamp of seconds[.ordinal] or RFC3339
+func ParseOplogTimestamp(s string) (primitive.Timestamp, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return primitive.Timestamp{T: uint32(t.Unix())}, nil
+	}
+	toks := strings.SplitN(s, ".", 2)
+	secs, err := strconv.ParseUint(toks[0], 10, 32)
+	if err != nil {
+		return primitive.Timestamp{}, fmt.Errorf("invalid timestamp %v", s)
+	}
+	ts := primitive.Timestamp{T: uint32(secs)}
+	if len(toks) > 1 {
+		ordinal, err := strconv.ParseUint(toks[1], 10, 32)
+		if err != nil {
+			return ts, fmt.Errorf("invalid timestamp %v", s)
+		}
+		ts.I = uint32(ordinal)
+	}
+	return ts, nil
+}
+
+// InspectOplogs lists, dumps, or replays cached oplog files, paths are files or directories, the
+// default spool if none
+func InspectOplogs(action string, paths []string, filter OplogFilter, opts ReplayOptions) error {
+	if len(paths) == 0 {
+		paths = []string{DefaultSpool}
+	}
+	filenames, err := GetSpoolFiles(paths)
+	if err != nil {
+		return fmt.Errorf("GetSpoolFiles failed: %v", err)
+	}
+	switch action {
+	case OplogDump:
+		return DumpOplogs(os.Stdout, filenames, filter)
+	case OplogList:
+		return ListOplogs(os.Stdout, filenames, filter)
+	case OplogReplay:
+		if opts.Target == "" {
+			return fmt.Errorf("-target is required to replay oplogs")
+		}
+		return ReplayOplogs(filenames, filter, opts)
+	}
+	return fmt.Errorf("unsupported -oplog %v, must be one of %v, %v, or %v", action, OplogDump, OplogList, OplogReplay)
+}
+
+// GetSpoolFiles returns spooled oplog files of files and directories, including archived ones, sorted by
+// file names
+func GetSpoolFiles(paths []string) ([]string, error) {
+	filenames := []string{}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			filenames = append(filenames, path)
+			continue
+		}
+		filepath.WalkDir(path, func(s string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() && IsSpoolFile(d.Name()) {
+				filenames = append(filenames, s)
+			}
+			return nil
+		})
+	}
+	sort.Slice(filenames, func(i int, j int) bool {
+		return filepath.Base(filenames[i]) < filepath.Base(filenames[j])
+	})
+	return filenames, nil
+}
+
+// readOplogs calls a function with raw data and decoded oplogs matching the filter
+func readOplogs(filename string, filter OplogFilter, fn func(data []byte, oplog Oplog) error) error {
+	breader, err := NewBSONReader(filename)
+	if err != nil {
+		return fmt.Errorf("read %v failed: %v", filename, err)
+	}
+	for data := breader.Next(); data != nil; data = breader.Next() {
+		var oplog Oplog
+		if err = bson.Unmarshal(data, &oplog); err != nil {
+			return fmt.Errorf("%v Unmarshal failed: %v", filename, err)
+		}
+		if !filter.Match(oplog) {
+			continue
+		}
+		if err = fn(data, oplog); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListOplogs writes timestamp ranges and op counts by namespaces of oplog files
+func ListOplogs(w io.Writer, filenames []string, filter OplogFilter) error {
+	for _, filename := range filenames {
+		var first, last primitive.Timestamp
+		total := 0
+		counts := map[string]map[string]int{}
+		err := readOplogs(filename, filter, func(data []byte, oplog Oplog) error {
+			if total == 0 {
+				first = oplog.Timestamp
+			}
+			last = oplog.Timestamp
+			total++
+			if counts[oplog.Namespace] == nil {
+				counts[oplog.Namespace] = map[string]int{}
+			}
+			counts[oplog.Namespace][oplog.Operation]++
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%v\n", filename)
+		if total == 0 {
+			fmt.Fprintf(w, "  no oplogs\n")
+			continue
+		}
+		fmt.Fprintf(w, "  %v oplog(s) from %v to %v\n", total, formatOplogTimestamp(first), formatOplogTimestamp(last))
+		namespaces := []string{}
+		for ns := range counts {
+			namespaces = append(namespaces, ns)
+		}
+		sort.Strings(namespaces)
+		for _, ns := range namespaces {
+			ops := []string{}
+			for op, count := range counts[ns] {
+				ops = append(ops, fmt.Sprintf("%v:%v", op, count))
+			}
+			sort.Strings(ops)
+			fmt.Fprintf(w, "  %v %v\n", ns, strings.Join(ops, " "))
+		}
+	}
+	return nil
+}
+
+// DumpOplogs writes oplogs as extended JSON, one per line
+func DumpOplogs(w io.Writer, filenames []string, filter OplogFilter) error {
+	for _, filename := range filenames {
+		err := readOplogs(filename, filter, func(data []byte, oplog Oplog) error {
+			doc, err := bson.MarshalExtJSON(bson.Raw(data), false, false)
+			if err != nil {
+				return fmt.Errorf("MarshalExtJSON failed: %v", err)
+			}
+			fmt.Fprintf(w, "%v\n", string(doc))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReplayOplogs applies oplogs to a target in order.  Includes, masks, transforms, and mappings of a
+// configuration file are applied if given, and conflicts are recorded in a workspace only if given.
+func ReplayOplogs(filenames []string, filter OplogFilter, opts ReplayOptions) error {
+	var err error
+	logger := gox.GetLogger("ReplayOplogs")
+	inst := &Migrator{Conflict: ConflictIgnore}
+	if opts.Config != "" {
+		if inst, err = ReadMigratorConfig(opts.Config); err != nil {
+			return fmt.Errorf("ReadMigratorConfig failed: %v", err)
+		}
+	}
+	inst.Apply = ApplyOrdered
+	inst.Target = opts.Target
+	inst.included = map[string]*Include{}
+	for _, include := range inst.Includes {
+		inst.included[include.Namespace] = include
+	}
+	inst.workspace = Workspace{dbName: MetaDBName, dbURI: opts.Workspace}
+	prev := migratorInstance
+	migratorInstance = inst
+	defer func() { migratorInstance = prev }()
+	txns := NewTxnBuffer()
+	var results BulkWriteOplogsResult
+	var oplogs []Oplog
+	apply := func() error {
+		if len(oplogs) == 0 {
+			return nil
+		}
+		res, err := BulkWriteOplogs(txns.Assemble(oplogs))
+		if err != nil {
+			return fmt.Errorf("BulkWriteOplogs failed: %v", err)
+		}
+		results.add(*res)
+		oplogs = nil
+		return nil
+	}
+	for _, filename := range filenames {
+		logger.Infof("replay oplogs from %v", filename)
+		err := readOplogs(filename, filter, func(data []byte, oplog Oplog) error {
+			oplogs = append(oplogs, oplog)
+			if len(oplogs) >= MaxBatchSize {
+				return apply()
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if err := apply(); err != nil {
+		return err
+	}
+	if txns.Len() > 0 {
+		logger.Warnf("%v transaction(s) not committed in the range are skipped", txns.Len())
+	}
+	logger.Infof("replayed, inserted: %v, modified: %v, deleted: %v, upserted: %v, conflicts: %v",
+		results.InsertedCount, results.ModifiedCount, results.DeletedCount, results.UpsertedCount, results.ConflictCount)
+	return nil
+}
+
+func formatOplogTimestamp(ts primitive.Timestamp) string {
+	return fmt.Sprintf("%v.%v (%v)", ts.T, ts.I, time.Unix(int64(ts.T), 0).UTC().Format(time.RFC3339))
+}
@@ -0,0 +1,116 @@
+// Copyright Kuei-chun Chen, 2022-present. All rights reserved.
+
+package hummingbird
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/simagix/keyhole/mdb"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func getInspectorTestFile(t *testing.T) string {
+	var raws []byte
+	oplogs := []Oplog{
+		{Namespace: TestNS, Operation: "i", Object: bson.D{{"_id", 1}}, Timestamp: primitive.Timestamp{T: 1650000001}},
+		{Namespace: TestNS, Operation: "i", Object: bson.D{{"_id", 2}}, Timestamp: primitive.Timestamp{T: 1650000002}},
+		{Namespace: TestNS, Operation: "u", Object: bson.D{{"$set", bson.D{{"a", 1}}}}, Query: bson.D{{"_id", 1}},
+			Timestamp: primitive.Timestamp{T: 1650000003}},
+		{Namespace: "testdb.other", Operation: "d", Object: bson.D{{"_id", 1}}, Timestamp: primitive.Timestamp{T: 1650000004}},
+	}
+	for _, oplog := range oplogs {
+		data, err := bson.Marshal(oplog)
+		assertEqual(t, nil, err)
+		raws = append(raws, data...)
+	}
+	spooler := NewSpoolManager(t.TempDir(), CompressorZstd, 0, false)
+	filename, err := spooler.Write(context.Background(), "replset", raws, oplogs[0].Timestamp, oplogs[len(oplogs)-1].Timestamp)
+	assertEqual(t, nil, err)
+	return filename
+}
+
+func TestParseOplogTimestamp(t *testing.T) {
+	ts, err := ParseOplogTimestamp("1650000000.3")
+	assertEqual(t, nil, err)
+	assertEqual(t, primitive.Timestamp{T: 1650000000, I: 3}, ts)
+	ts, err = ParseOplogTimestamp("2022-04-15T05:20:00Z")
+	assertEqual(t, nil, err)
+	assertEqual(t, primitive.Timestamp{T: 1650000000}, ts)
+	_, err = ParseOplogTimestamp("yesterday")
+	assertNotEqual(t, nil, err)
+}
+
+func TestOplogFilterMatch(t *testing.T) {
+	oplog := Oplog{Namespace: TestNS, Operation: "i", Timestamp: primitive.Timestamp{T: 10}}
+	filter, err := NewOplogFilter(TestNS, "i", "5", "10")
+	assertEqual(t, nil, err)
+	assertEqual(t, true, filter.Match(oplog))
+	filter, _ = NewOplogFilter("", "u", "", "")
+	assertEqual(t, false, filter.Match(oplog))
+	filter, _ = NewOplogFilter("", "", "10.1", "")
+	assertEqual(t, false, filter.Match(oplog))
+
+	var txn Oplog
+	doc := `{ "op": "c", "ns": "admin.$cmd", "o": { "applyOps": [ { "op": "i", "ns": "testdb.neutrino", "o": { "_id": 1 } } ] } }`
+	err = bson.UnmarshalExtJSON([]byte(doc), false, &txn)
+	assertEqual(t, nil, err)
+	filter, _ = NewOplogFilter(TestNS, "", "", "")
+	assertEqual(t, true, filter.Match(txn))
+}
+
+func TestListOplogs(t *testing.T) {
+	filename := getInspectorTestFile(t)
+	var buf bytes.Buffer
+	err := ListOplogs(&buf, []string{filename}, OplogFilter{})
+	assertEqual(t, nil, err)
+	assertEqual(t, true, strings.Contains(buf.String(), "4 oplog(s) from 1650000001.0"))
+	assertEqual(t, true, strings.Contains(buf.String(), TestNS+" i:2 u:1"))
+	assertEqual(t, true, strings.Contains(buf.String(), "testdb.other d:1"))
+}
+
+func TestDumpOplogs(t *testing.T) {
+	filename := getInspectorTestFile(t)
+	var buf bytes.Buffer
+	filter, err := NewOplogFilter(TestNS, "i", "", "")
+	assertEqual(t, nil, err)
+	err = DumpOplogs(&buf, []string{filename}, filter)
+	assertEqual(t, nil, err)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assertEqual(t, 2, len(lines))
+	var oplog Oplog
+	err = bson.UnmarshalExtJSON([]byte(lines[1]), false, &oplog)
+	assertEqual(t, nil, err)
+	assertEqual(t, int32(2), oplog.Object.Map()["_id"])
+}
+
+func TestReplayOplogs(t *testing.T) {
+	filename := getInspectorTestFile(t)
+	ctx := context.Background()
+	dbName, collName := mdb.SplitNamespace(TestNS)
+	client, err := GetMongoClient(TestTargetURI)
+	assertEqual(t, nil, err)
+	coll := client.Database(dbName).Collection(collName)
+	coll.Drop(ctx)
+	err = ReplayOplogs([]string{filename}, OplogFilter{Namespace: TestNS}, ReplayOptions{Target: TestTargetURI})
+	assertEqual(t, nil, err)
+	count, err := coll.CountDocuments(ctx, bson.D{{"a", 1}})
+	assertEqual(t, nil, err)
+	assertEqual(t, int64(1), count)
+}
+
+func TestReplayOplogsWithoutWorkspace(t *testing.T) {
+	prev := &Migrator{Target: TestTargetURI}
+	migratorInstance = prev
+	err := ReplayOplogs([]string{}, OplogFilter{}, ReplayOptions{Config: "testdata/nonexistent.json"})
+	assertNotEqual(t, nil, err)
+	err = ReplayOplogs([]string{}, OplogFilter{}, ReplayOptions{Target: TestTargetURI})
+	assertEqual(t, nil, err)
+	assertEqual(t, prev, GetMigratorInstance())
+
+	ws := Workspace{dbName: MetaDBName}
+	assertEqual(t, nil, ws.AddConflicts([]Conflict{{Namespace: TestNS, Reason: ReasonDuplicateKey}}))
+}
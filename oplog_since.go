@@ -0,0 +1,89 @@
+// Copyright Kuei-chun Chen, 2022-present. All rights reserved.
+
+package hummingbird
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/simagix/gox"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ParseSince parses a "since" value of a timestamp or a map of shard/replica names to timestamps, a
+// timestamp for all is keyed by an empty name
+func ParseSince(since interface{}) (map[string]primitive.Timestamp, error) {
+	tsMap := map[string]primitive.Timestamp{}
+	if since == nil {
+		return tsMap, nil
+	}
+	var doc bson.M
+	switch v := since.(type) {
+	case bson.D:
+		doc = v.Map()
+	case bson.M:
+		doc = v
+	case map[string]interface{}:
+		doc = v
+	default:
+		ts, err := parseSinceValue(v)
+		if err != nil {
+			return nil, err
+		}
+		tsMap[""] = ts
+		return tsMap, nil
+	}
+	for setName, value := range doc {
+		ts, err := parseSinceValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("%v %v", setName, err)
+		}
+		tsMap[setName] = ts
+	}
+	return tsMap, nil
+}
+
+// parseSinceValue parses a timestamp string of seconds[.ordinal] or RFC3339, or a BSON timestamp
+func parseSinceValue(value interface{}) (primitive.Timestamp, error) {
+	switch v := value.(type) {
+	case string:
+		return ParseOplogTimestamp(v)
+	case primitive.Timestamp:
+		return v, nil
+	}
+	return primitive.Timestamp{}, fmt.Errorf("invalid timestamp %v", value)
+}
+
+// GetSince returns the "since" timestamp of a shard/replica, nil if not given
+func (inst *Migrator) GetSince(setName string) *primitive.Timestamp {
+	if ts, ok := inst.since[setName]; ok {
+		return &ts
+	}
+	if ts, ok := inst.since[""]; ok {
+		return &ts
+	}
+	return nil
+}
+
+// SeedOplogCheckpoints saves "since" timestamps, or the current time if not given, as checkpoints of
+// all streams, so that oplogs are live streamed to an already seeded target without caching
+func SeedOplogCheckpoints() error {
+	inst := GetMigratorInstance()
+	logger := gox.GetLogger("SeedOplogCheckpoints")
+	ws := inst.Workspace()
+	now := primitive.Timestamp{T: uint32(time.Now().Unix())}
+	for setName := range inst.Streams() {
+		ts := inst.GetSince(setName)
+		if ts == nil && len(inst.since) > 0 {
+			return fmt.Errorf(`"since" timestamp of %v is missing`, setName)
+		} else if ts == nil {
+			ts = &now
+		}
+		logger.Infof("%v stream oplogs since %v", setName, time.Unix(int64(ts.T), 0).Format(time.RFC3339))
+		if err := ws.SaveCheckpoint(setName, *ts, nil, nil); err != nil {
+			return fmt.Errorf("SaveCheckpoint failed: %v", err)
+		}
+	}
+	return nil
+}
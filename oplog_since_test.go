@@ -0,0 +1,45 @@
+// Copyright Kuei-chun Chen, 2022-present. All rights reserved.
+
+package hummingbird
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestParseSince(t *testing.T) {
+	tsMap, err := ParseSince(nil)
+	assertEqual(t, nil, err)
+	assertEqual(t, 0, len(tsMap))
+
+	tsMap, err = ParseSince("1650000000.3")
+	assertEqual(t, nil, err)
+	assertEqual(t, primitive.Timestamp{T: 1650000000, I: 3}, tsMap[""])
+
+	tsMap, err = ParseSince(primitive.Timestamp{T: 1650000000, I: 1})
+	assertEqual(t, nil, err)
+	assertEqual(t, primitive.Timestamp{T: 1650000000, I: 1}, tsMap[""])
+
+	tsMap, err = ParseSince(bson.D{{"shard01", "2022-04-15T05:20:00Z"}, {"shard02", "1650000000"}})
+	assertEqual(t, nil, err)
+	assertEqual(t, 2, len(tsMap))
+	assertEqual(t, primitive.Timestamp{T: 1650000000}, tsMap["shard01"])
+	assertEqual(t, primitive.Timestamp{T: 1650000000}, tsMap["shard02"])
+
+	_, err = ParseSince(bson.D{{"shard01", "yesterday"}})
+	assertNotEqual(t, nil, err)
+
+	_, err = ParseSince(int32(1))
+	assertNotEqual(t, nil, err)
+}
+
+func TestGetSince(t *testing.T) {
+	inst := &Migrator{since: map[string]primitive.Timestamp{"shard01": {T: 1}}}
+	assertEqual(t, primitive.Timestamp{T: 1}, *inst.GetSince("shard01"))
+	assertEqual(t, (*primitive.Timestamp)(nil), inst.GetSince("shard02"))
+
+	inst.since[""] = primitive.Timestamp{T: 2}
+	assertEqual(t, primitive.Timestamp{T: 2}, *inst.GetSince("shard02"))
+}
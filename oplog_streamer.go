@@ -8,7 +8,6 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
-	"log"
 	"os"
 	"path/filepath"
 	"sort"
@@ -19,6 +18,7 @@ import (
 	"github.com/simagix/gox"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 const (
@@ -32,57 +32,117 @@ const (
 	OplogBatchSize = 10000
 )
 
-// OplogStreamer tails oplogs
+// OplogStreamer tails oplogs or a change stream
 type OplogStreamer struct {
 	SetName string
 	Spool   string
+	Stream  string
 	URI     string
 
+	applied primitive.Timestamp
+	applier *OplogApplier
 	cached  string
+	idle    time.Time
 	isCache bool
+	lag     time.Duration
 	mutex   sync.Mutex
+	synced  primitive.Timestamp
+	token   bson.Raw
 	ts      *primitive.Timestamp
+	txnTS   *primitive.Timestamp
+	txns    *TxnBuffer
+	window  *OplogWindowStatus
 }
 
 // Oplog stores an oplog
 type Oplog struct {
-	Hash      *int64              `bson:"h"`
-	Namespace string              `bson:"ns"`
-	Object    bson.D              `bson:"o"`
-	Operation string              `bson:"op"`
-	Query     bson.D              `bson:"o2,omitempty"`
+	Hash       *int64              `bson:"h"`
+	LSID       bson.D              `bson:"lsid,omitempty"`
+	Namespace  string              `bson:"ns"`
+	Object     bson.D              `bson:"o"`
+	Operation  string              `bson:"op"`
+	PrevOpTime *OpTime             `bson:"prevOpTime,omitempty"`
+	Query      bson.D              `bson:"o2,omitempty"`
+	Term       *int64              `bson:"t"`
+	Timestamp  primitive.Timestamp `bson:"ts"`
+	TxnNumber  *int64              `bson:"txnNumber,omitempty"`
+	Version    int                 `bson:"v"`
+}
+
+// OpTime stores an optime
+type OpTime struct {
 	Term      *int64              `bson:"t"`
 	Timestamp primitive.Timestamp `bson:"ts"`
-	Version   int                 `bson:"v"`
 }
 
-// OplogStreamers copies oplogs from source to target
-func OplogStreamers() error {
+// OplogStreamers copies oplogs from source to target, streamers save positions and exit once the context
+// is canceled
+func OplogStreamers(ctx context.Context) error {
 	logger := gox.GetLogger("OplogStreamer")
 	inst := GetMigratorInstance()
 	ws := inst.Workspace()
-	status := "stream oplogs"
+	status := fmt.Sprintf("stream oplogs, apply in %v mode", inst.Apply)
 	logger.Remark(status)
 	err := ws.Log(status)
 	if err != nil {
 		return fmt.Errorf("update status failed: %v", err)
 	}
-	for setName, replica := range inst.Replicas() {
+	for setName, replica := range inst.Streams() {
 		logger.Infof("stream %v (%v)", setName, RedactedURI(replica))
-		streamer := OplogStreamer{SetName: setName, Spool: inst.Workspace().spool,
-			URI: replica, isCache: true}
+		streamer := OplogStreamer{SetName: setName, Spool: inst.Workspace().spool, Stream: inst.Stream,
+			URI: replica, isCache: true, txns: NewTxnBuffer()}
 		streamer.ts = ws.GetOplogTimestamp(setName)
-		go func() {
-			err := streamer.CacheOplogs()
-			if err != nil {
-				log.Fatal(err)
+		streamer.txnTS = ws.GetTxnTimestamp(setName)
+		if inst.Stream == StreamChangeStream {
+			streamer.token = ws.GetResumeToken(setName)
+		}
+		if ws.IsLiveStreaming(setName) { // resume live streaming from the checkpoint
+			logger.Infof("%v resume live streaming from checkpoint", setName)
+			streamer.isCache = false
+			streamer.cached = streamer.getLastCachedFile()
+		}
+		if inst.Appliers > 1 {
+			streamer.applier = NewOplogApplier(setName, inst.Appliers)
+		}
+		if inst.Stream != StreamChangeStream {
+			inst.Go(func() error {
+				streamer.MonitorOplogWindow(ctx)
+				return nil
+			})
+		}
+		inst.Go(func() error {
+			if err := streamer.CacheOplogs(ctx); err != nil {
+				return fmt.Errorf("%v CacheOplogs failed: %v", setName, err)
 			}
-		}()
+			return nil
+		})
 		inst.AddOplogStreamer(&streamer)
 	}
+	inst.Go(func() error {
+		inst.Checker().Run(ctx)
+		return nil
+	})
 	return nil
 }
 
+// openCursor returns a cursor of oplogs or of a change stream by the stream type
+func (p *OplogStreamer) openCursor(client *mongo.Client, ts *primitive.Timestamp) (*OplogCursor, error) {
+	if p.Stream == StreamChangeStream {
+		return GetChangeStreamCursor(client, p.token, ts)
+	}
+	return GetOplogCursor(client, ts)
+}
+
+// saveResumeToken saves the resume token of a change stream
+func (p *OplogStreamer) saveResumeToken(token bson.Raw) error {
+	if p.Stream != StreamChangeStream || len(token) == 0 {
+		return nil
+	}
+	p.token = token
+	ws := GetMigratorInstance().Workspace()
+	return ws.SaveResumeToken(p.SetName, token)
+}
+
 // IsCache returns isCache
 func (p *OplogStreamer) IsCache() bool {
 	p.mutex.Lock()
@@ -90,26 +150,183 @@ func (p *OplogStreamer) IsCache() bool {
 	return p.isCache
 }
 
+// bulkWriteOplogs applies oplogs, transactions are buffered until committed
+func (p *OplogStreamer) bulkWriteOplogs(oplogs []Oplog) error {
+	if p.txns == nil {
+		p.txns = NewTxnBuffer()
+	}
+	oplogs = p.skipApplied(p.txns.Assemble(oplogs))
+	if p.applier != nil {
+		p.applier.Apply(oplogs)
+		return nil
+	}
+	_, err := BulkWriteOplogs(oplogs)
+	if err != nil {
+		gox.GetLogger().Errorf("%v BulkWriteOplogs failed: %v", p.SetName, err)
+	}
+	return err
+}
+
+// skipApplied removes oplogs before the checkpoint, read again to rebuild transactions pending commit
+func (p *OplogStreamer) skipApplied(oplogs []Oplog) []Oplog {
+	if p.applied.IsZero() {
+		return oplogs
+	}
+	unapplied := []Oplog{}
+	for _, oplog := range oplogs {
+		if primitive.CompareTimestamp(oplog.Timestamp, p.applied) >= 0 {
+			unapplied = append(unapplied, oplog)
+		}
+	}
+	return unapplied
+}
+
+// waitForApplied waits until all oplogs passed to bulkWriteOplogs are applied
+func (p *OplogStreamer) waitForApplied() error {
+	if p.applier != nil {
+		return p.applier.Flush()
+	}
+	return nil
+}
+
+// Close stops applier goroutines once oplogs are no longer applied
+func (p *OplogStreamer) Close() error {
+	if p.applier != nil {
+		return p.applier.Close()
+	}
+	return nil
+}
+
+// applyLiveOplogs applies oplogs and checkpoints the last applied if all are applied successfully
+func (p *OplogStreamer) applyLiveOplogs(oplogs []Oplog, token bson.Raw) error {
+	err := p.bulkWriteOplogs(oplogs)
+	if aerr := p.waitForApplied(); err == nil {
+		err = aerr
+	}
+	if err != nil { // the checkpoint stays at the last successful write
+		return err
+	}
+	if err = p.checkpoint(oplogs[len(oplogs)-1].Timestamp, token); err != nil {
+		gox.GetLogger().Errorf("%v checkpoint failed: %v", p.SetName, err)
+	}
+	GetMigratorInstance().Checker().Sample(p.SetName, oplogs)
+	return nil
+}
+
+// setSynced sets the position of the last oplog read with all read applied, and the time caught up with
+// the stream if idle
+func (p *OplogStreamer) setSynced(ts primitive.Timestamp, idle bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if primitive.CompareTimestamp(ts, p.synced) > 0 {
+		p.synced = ts
+	}
+	if idle {
+		p.idle = time.Now()
+	}
+}
+
+// IsDrained returns true if oplogs up to a final position were applied, or the stream was caught up
+// after the final position was taken
+func (p *OplogStreamer) IsDrained(final primitive.Timestamp, finalTime time.Time) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if primitive.CompareTimestamp(p.synced, final) >= 0 {
+		return true
+	}
+	return p.idle.After(finalTime.Add(2 * time.Second)) // beyond a pending await of the cursor
+}
+
+// checkpoint saves the position after the last applied oplog, and the first oplog of the oldest
+// transaction pending commit, so that resuming rebuilds buffered transactions without applying oplogs twice
+func (p *OplogStreamer) checkpoint(ts primitive.Timestamp, token bson.Raw) error {
+	next := primitive.Timestamp{T: ts.T, I: ts.I + 1}
+	var txnTS *primitive.Timestamp
+	if p.txns != nil {
+		txnTS = p.txns.GetOldestTimestamp()
+	}
+	p.mutex.Lock()
+	p.ts = &next
+	if len(token) > 0 {
+		p.token = token
+	}
+	p.mutex.Unlock()
+	ws := GetMigratorInstance().Workspace()
+	return ws.SaveCheckpoint(p.SetName, next, txnTS, token)
+}
+
+// GetCheckpoint returns the timestamp of the last checkpoint
+func (p *OplogStreamer) GetCheckpoint() *primitive.Timestamp {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.ts
+}
+
+// setLag sets lag and logs it with applier queue depths
+func (p *OplogStreamer) setLag(ts primitive.Timestamp) {
+	lag := time.Since(time.Unix(int64(ts.T), 0)).Truncate(time.Second)
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.applier == nil {
+		p.lag = lag
+		gox.GetLogger().Infof("%v: lag %v", p.SetName, lag)
+		return
+	}
+	if applierLag := p.applier.GetLag(); applierLag > lag {
+		lag = applierLag
+	}
+	p.lag = lag
+	gox.GetLogger().Infof("%v: lag %v, queues %v", p.SetName, lag, p.applier.GetQueueDepths())
+}
+
+// GetLag returns lag of applying oplogs
+func (p *OplogStreamer) GetLag() time.Duration {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.lag
+}
+
+// GetQueueDepths returns numbers of oplogs waiting by applier partitions
+func (p *OplogStreamer) GetQueueDepths() []int {
+	if p.applier == nil {
+		return []int{}
+	}
+	return p.applier.GetQueueDepths()
+}
+
 // LiveStream begin applying oplogs to target
-func (p *OplogStreamer) LiveStream() {
-	go func() {
-		for {
-			var err error
-			if p.cached, err = p.ApplyCachedOplogs(); err != nil {
-				log.Fatalf("ApplyCachedOplogs failed: %v", err)
-			}
-			if p.cached == "" {
-				break
-			}
+func (p *OplogStreamer) LiveStream(ctx context.Context) {
+	GetMigratorInstance().Go(func() error {
+		if err := p.applyAllCachedOplogs(ctx); err != nil {
+			return fmt.Errorf("%v ApplyCachedOplogs failed: %v", p.SetName, err)
+		}
+		if ctx.Err() != nil { // shutting down, the rest are applied after resuming
+			return nil
 		}
 		p.mutex.Lock()
 		p.isCache = false
 		p.mutex.Unlock()
-	}()
+		return nil
+	})
 }
 
-// CacheOplogs store oplogs in files
-func (p *OplogStreamer) CacheOplogs() error {
+// applyAllCachedOplogs applies cached oplogs until no more files are cached or the context is canceled
+func (p *OplogStreamer) applyAllCachedOplogs(ctx context.Context) error {
+	for ctx.Err() == nil {
+		cached, err := p.ApplyCachedOplogs(ctx)
+		if err != nil {
+			return err
+		}
+		if cached == "" {
+			return nil
+		}
+		p.cached = cached
+	}
+	return nil
+}
+
+// CacheOplogs store oplogs in files, oplogs in memory are spooled once the context is canceled
+func (p *OplogStreamer) CacheOplogs(ctx context.Context) error {
 	inst := GetMigratorInstance()
 	ws := inst.Workspace()
 	logger := gox.GetLogger()
@@ -121,21 +338,37 @@ func (p *OplogStreamer) CacheOplogs() error {
 		p.ts = &primitive.Timestamp{T: uint32(time.Now().Unix())}
 		ws.SaveOplogTimestamp(p.SetName, *p.ts)
 	}
+	if !p.IsCache() { // cached oplogs were applied
+		if err := p.LiveStreamOplogs(ctx, p.ts); err != nil {
+			return fmt.Errorf("oplogs live streaming failed: %v", err)
+		}
+		return nil
+	}
 	client, err := GetMongoClient(p.URI)
 	if err != nil {
 		return fmt.Errorf("GetMongoClient failed: %v", err)
 	}
-	cursor, err := GetTailableCursor(client, p.ts)
+	cursor, err := p.openCursor(client, p.ts)
 	if err != nil {
 		return fmt.Errorf("error finding oplog from %v since %v: %v", p.SetName,
 			time.Unix(int64(p.ts.T), 0).Format(time.RFC3339), err)
 	}
-	ctx := context.Background()
 	var raws bson.Raw
+	var first, last primitive.Timestamp // of oplogs in raws
+	spooler := inst.Spooler()
+	token := p.token // resume token of the last cached oplog
 	for p.IsCache() {
 		var oplog Oplog
-		if !cursor.TryNext(ctx) {
-			time.Sleep(1 * time.Millisecond)
+		if ctx.Err() != nil {
+			return p.spoolOnShutdown(raws, first, last, token)
+		}
+		var state int // oplogs after the saved position are read again after resuming if failed
+		if state, err = cursor.TryNext(ctx); err != nil && ctx.Err() == nil {
+			return fmt.Errorf("%v read oplogs failed: %v", p.SetName, err)
+		} else if state != CursorRead {
+			if state == CursorIdle {
+				time.Sleep(1 * time.Millisecond)
+			}
 			continue
 		}
 		if err = cursor.Decode(&oplog); err != nil {
@@ -144,19 +377,34 @@ func (p *OplogStreamer) CacheOplogs() error {
 		if oplog.Namespace == "" || SkipOplog(oplog) {
 			continue
 		}
-		if len(raws)+len(cursor.Current) > CacheDataSizeLimit {
-			ofile := fmt.Sprintf(`%v/%v.%v.bson.gz`, p.Spool, p.SetName, GetDateTime())
-			if err = gox.OutputGzipped(raws, ofile); err != nil {
-				return fmt.Errorf("OutputGzipped %v failed: %v", ofile, err)
+		if len(raws)+len(cursor.Current()) > CacheDataSizeLimit {
+			if _, err = spooler.Write(ctx, p.SetName, raws, first, last); err != nil {
+				if ctx.Err() != nil { // shut down while the quota is reached, read again after resuming
+					return nil
+				}
+				return fmt.Errorf("%v spool oplogs failed: %v", p.SetName, err)
 			}
-			var last Oplog
-			bson.Unmarshal(cursor.Current, &last)
-			if err = ws.SaveOplogTimestamp(p.SetName, last.Timestamp); err != nil {
+			if err = ws.SaveOplogTimestamp(p.SetName, oplog.Timestamp); err != nil {
 				return fmt.Errorf("RecordOplogTimestamp failed: %v", err)
 			}
+			if err = p.saveResumeToken(token); err != nil {
+				return fmt.Errorf("SaveResumeToken failed: %v", err)
+			}
 			raws = nil
 		}
-		raws = append(raws, cursor.Current...)
+		if len(raws) == 0 {
+			first = oplog.Timestamp
+		}
+		last = oplog.Timestamp
+		raws = append(raws, cursor.Current()...)
+		token = cursor.ResumeToken()
+	}
+	token = cursor.ResumeToken()                       // all read are cached or skipped
+	if err = p.applyAllCachedOplogs(ctx); err != nil { // spooled after the live stream caught up
+		return fmt.Errorf("ApplyCachedOplogs failed: %v", err)
+	}
+	if ctx.Err() != nil {
+		return p.spoolOnShutdown(raws, first, last, token)
 	}
 	if len(raws) > 0 {
 		logger.Infof("%v apply oplogs from memory", p.SetName)
@@ -176,58 +424,114 @@ func (p *OplogStreamer) CacheOplogs() error {
 			op = &oplog
 			oplogs = append(oplogs, oplog)
 			if len(oplogs) >= MaxBatchSize {
-				if _, err := BulkWriteOplogs(oplogs); err != nil {
-					logger.Errorf("BulkWriteOplogs failed: %v", err)
+				if err = p.bulkWriteOplogs(oplogs); err != nil {
+					return err
 				}
 				oplogs = nil
 			}
 		}
 		if len(oplogs) > 0 {
-			if _, err := BulkWriteOplogs(oplogs); err != nil {
-				logger.Errorf("BulkWriteOplogs failed: %v", err)
+			if err = p.bulkWriteOplogs(oplogs); err != nil {
+				return err
 			}
 		}
+		if err = p.waitForApplied(); err != nil {
+			return err
+		}
 		lag := time.Since(time.Unix(int64(op.Timestamp.T), 0)).Truncate(time.Second)
 		logger.Infof("%v lag %v, %v processed", p.SetName, lag, processed)
-		p.ts = &op.Timestamp
-		if err = ws.SaveOplogTimestamp(p.SetName, op.Timestamp); err != nil {
+		p.ts = &primitive.Timestamp{T: op.Timestamp.T, I: op.Timestamp.I + 1}
+		if err = ws.SaveOplogTimestamp(p.SetName, *p.ts); err != nil {
 			return fmt.Errorf("RecordOplogTimestamp failed: %v", err)
 		}
 	}
-	if err = p.LiveStreamOplogs(p.ts); err != nil {
+	if err = p.saveResumeToken(token); err != nil {
+		return fmt.Errorf("SaveResumeToken failed: %v", err)
+	}
+	if err = p.LiveStreamOplogs(ctx, p.ts); err != nil {
 		return fmt.Errorf("oplogs live streaming failed: %v", err)
 	}
 	return nil
 }
 
-// ApplyCachedOplogs applies cached oplogs to target
-func (p *OplogStreamer) ApplyCachedOplogs() (string, error) {
-	inst := GetMigratorInstance()
-	logger := gox.GetLogger()
-	ws := inst.Workspace()
-	status := fmt.Sprintf("%v apply cached oplogs", p.SetName)
-	logger.Remark(status)
-	ws.Log(status)
+// spoolOnShutdown spools oplogs in memory and saves the position after the last of them, so that a
+// resumed migration continues caching without missing or repeating oplogs
+func (p *OplogStreamer) spoolOnShutdown(raws bson.Raw, first primitive.Timestamp, last primitive.Timestamp,
+	token bson.Raw) error {
+	if len(raws) == 0 {
+		return nil
+	}
+	ws := GetMigratorInstance().Workspace()
+	ctx, cancel := context.WithTimeout(context.Background(), SpoolTimeout)
+	defer cancel()
+	filename, err := GetMigratorInstance().Spooler().Write(ctx, p.SetName, raws, first, last)
+	if err == context.DeadlineExceeded { // oplogs after the saved position are read again after resuming
+		gox.GetLogger().Warnf("%v spool quota reached, %v bytes of oplogs in memory not spooled", p.SetName, len(raws))
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("%v spool oplogs failed: %v", p.SetName, err)
+	}
+	if err = ws.SaveOplogTimestamp(p.SetName, primitive.Timestamp{T: last.T, I: last.I + 1}); err != nil {
+		return fmt.Errorf("RecordOplogTimestamp failed: %v", err)
+	}
+	if err = p.saveResumeToken(token); err != nil {
+		return fmt.Errorf("SaveResumeToken failed: %v", err)
+	}
+	gox.GetLogger().Infof("%v spooled oplogs to %v before shutdown", p.SetName, filename)
+	return nil
+}
+
+// getCachedFiles returns sorted names of cached oplog files after a file
+func (p *OplogStreamer) getCachedFiles(after string) []string {
 	filenames := []string{}
-	filepath.WalkDir(inst.Workspace().spool, func(s string, d fs.DirEntry, err error) error {
+	filepath.WalkDir(p.Spool, func(s string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
-		if strings.HasPrefix(d.Name(), p.SetName) && strings.HasSuffix(d.Name(), GZippedBSONFileExt) {
-			if s > p.cached {
+		if d.IsDir() && s != p.Spool { // i.e. archived
+			return filepath.SkipDir
+		}
+		if strings.HasPrefix(d.Name(), p.SetName+".") && IsSpoolFile(d.Name()) {
+			if s > after {
 				filenames = append(filenames, s)
 			}
 		}
 		return nil
 	})
+	sort.Slice(filenames, func(i int, j int) bool {
+		return filenames[i] < filenames[j]
+	})
+	return filenames
+}
+
+// getLastCachedFile returns name of the last cached oplog file, all were applied before live streaming
+func (p *OplogStreamer) getLastCachedFile() string {
+	filenames := p.getCachedFiles("")
+	if len(filenames) == 0 {
+		return ""
+	}
+	return filenames[len(filenames)-1]
+}
+
+// ApplyCachedOplogs applies cached oplogs to target and returns the last file applied, it stops after a
+// file once the context is canceled
+func (p *OplogStreamer) ApplyCachedOplogs(ctx context.Context) (string, error) {
+	inst := GetMigratorInstance()
+	logger := gox.GetLogger()
+	ws := inst.Workspace()
+	status := fmt.Sprintf("%v apply cached oplogs", p.SetName)
+	logger.Remark(status)
+	ws.Log(status)
+	filenames := p.getCachedFiles(p.cached)
 	if len(filenames) == 0 {
 		return "", nil
 	}
 	logger.Infof("%v has %v file(s)", p.SetName, len(filenames))
-	sort.Slice(filenames, func(i int, j int) bool {
-		return filenames[i] < filenames[j]
-	})
+	applied := ""
 	for _, filename := range filenames {
+		if ctx.Err() != nil {
+			break
+		}
 		logger.Infof("%v apply oplogs from %v", p.SetName, filename)
 		breader, err := NewBSONReader(filename)
 		if err != nil {
@@ -247,28 +551,35 @@ func (p *OplogStreamer) ApplyCachedOplogs() (string, error) {
 			op = &oplog
 			oplogs = append(oplogs, oplog)
 			if len(oplogs) >= MaxBatchSize {
-				if _, err := BulkWriteOplogs(oplogs); err != nil {
-					logger.Errorf("BulkWriteOplogs failed: %v", err)
+				if err = p.bulkWriteOplogs(oplogs); err != nil {
+					return applied, err
 				}
 				oplogs = nil
 			}
 		}
 		if len(oplogs) > 0 {
-			if _, err := BulkWriteOplogs(oplogs); err != nil {
-				logger.Errorf("BulkWriteOplogs failed: %v", err)
+			if err = p.bulkWriteOplogs(oplogs); err != nil {
+				return applied, err
 			}
 		}
+		if err = p.waitForApplied(); err != nil {
+			return applied, err
+		}
 		lag := time.Since(time.Unix(int64(op.Timestamp.T), 0)).Truncate(time.Second)
 		logger.Infof("%v lag %v, %v processed", p.SetName, lag, processed)
-		p.ts = &oplogs[len(oplogs)-1].Timestamp
+		p.ts = &primitive.Timestamp{T: op.Timestamp.T, I: op.Timestamp.I + 1}
+		if err = inst.Spooler().Release(filename); err != nil {
+			logger.Warnf("%v release %v failed: %v", p.SetName, filename, err)
+		}
+		applied = filename
 		time.Sleep(50 * time.Millisecond) // yield
 	}
-	return filenames[len(filenames)-1], nil
+	return applied, nil
 }
 
-// LiveStreamOplogs stream and apply oplogs
-func (p *OplogStreamer) LiveStreamOplogs(ts *primitive.Timestamp) error {
-	ctx := context.Background()
+// LiveStreamOplogs stream and apply oplogs, oplogs read are applied and checkpointed once the context is
+// canceled
+func (p *OplogStreamer) LiveStreamOplogs(ctx context.Context, ts *primitive.Timestamp) error {
 	inst := GetMigratorInstance()
 	ws := inst.Workspace()
 	logger := gox.GetLogger()
@@ -279,21 +590,40 @@ func (p *OplogStreamer) LiveStreamOplogs(ts *primitive.Timestamp) error {
 	if err != nil {
 		return fmt.Errorf("GetMongoClient failed: %v", err)
 	}
-	cursor, err := GetTailableCursor(client, ts)
+	from := ts
+	if p.txnTS != nil && ts != nil && primitive.CompareTimestamp(*p.txnTS, *ts) < 0 {
+		from = p.txnTS // rebuilds transactions pending commit, oplogs before ts were applied
+		p.applied = *ts
+	}
+	cursor, err := p.openCursor(client, from)
+	if err != nil {
+		return fmt.Errorf("error finding oplog from %v: %v", p.SetName, err)
+	}
 	var oplogs []Oplog
+	var read primitive.Timestamp // of the last oplog read
+	var readErr error
+	var token bson.Raw // resume token of the last oplog read
 	last := time.Now()
-	for {
+	for ctx.Err() == nil {
 		var oplog Oplog
-		if !cursor.TryNext(ctx) {
-			if len(oplogs) > 0 {
-				if _, err := BulkWriteOplogs(oplogs); err != nil {
-					logger.Errorf("BulkWriteOplogs failed: %v", err)
-				}
-				oplogs = nil
+		var state int // oplogs read are applied before returning if failed
+		if state, err = cursor.TryNext(ctx); err != nil && ctx.Err() == nil {
+			readErr = fmt.Errorf("%v read oplogs failed: %v", p.SetName, err)
+			break
+		} else if state == CursorSkipped {
+			continue
+		} else if state == CursorIdle {
+			if len(oplogs) == 0 {
+				p.setSynced(read, true)
+			} else if err = p.applyLiveOplogs(oplogs, token); err != nil {
+				return err // stops at the checkpoint, the failed batch is applied again after resuming
+			} else {
+				p.setSynced(read, true)
 			}
+			oplogs = nil
 			if time.Since(last) > 10*time.Second {
 				last = time.Now()
-				logger.Infof("%v lag 0s", p.SetName)
+				p.setLag(primitive.Timestamp{T: uint32(time.Now().Unix())})
 			}
 			time.Sleep(1 * time.Millisecond)
 			continue
@@ -301,23 +631,34 @@ func (p *OplogStreamer) LiveStreamOplogs(ts *primitive.Timestamp) error {
 		if err = cursor.Decode(&oplog); err != nil {
 			continue
 		}
+		read = oplog.Timestamp
 		if oplog.Namespace == "" || SkipOplog(oplog) {
 			continue
 		}
 		oplogs = append(oplogs, oplog)
+		token = cursor.ResumeToken()
 		if len(oplogs) >= MaxBatchSize || time.Since(last) > 10*time.Second {
 			last = time.Now()
 			if len(oplogs) == 0 {
-				logger.Infof("%v lag 0s", p.SetName)
+				p.setLag(primitive.Timestamp{T: uint32(time.Now().Unix())})
 				continue
 			}
-			if _, err := BulkWriteOplogs(oplogs); err != nil {
-				logger.Errorf("BulkWriteOplogs failed: %v", err)
+			if err = p.applyLiveOplogs(oplogs, token); err != nil {
+				return err
 			}
-			lag := time.Since(time.Unix(int64(oplogs[len(oplogs)-1].Timestamp.T), 0)).Truncate(time.Second)
-			logger.Infof("%v: lag %v", p.SetName, lag)
+			p.setSynced(read, false)
+			p.setLag(oplogs[len(oplogs)-1].Timestamp)
 			oplogs = nil
 		}
 	}
+	if len(oplogs) > 0 {
+		if err = p.applyLiveOplogs(oplogs, token); err != nil {
+			return err
+		}
+	}
+	if readErr != nil {
+		return readErr
+	}
+	logger.Infof("%v stopped live streaming", p.SetName)
 	return nil
 }
@@ -14,7 +14,7 @@ func TestOplogStreamers(t *testing.T) {
 	filename := "testdata/config.json"
 	_, err := NewMigratorInstance(filename)
 	assertEqual(t, nil, err)
-	err = OplogStreamers()
+	err = OplogStreamers(context.Background())
 	assertEqual(t, nil, err)
 }
 
@@ -27,7 +27,7 @@ func TestCacheOplogs(t *testing.T) {
 	streamer := OplogStreamer{SetName: replset, Spool: "./spool",
 		URI: TestReplicaURI, isCache: true}
 	go func() {
-		err := streamer.CacheOplogs()
+		err := streamer.CacheOplogs(ctx)
 		assertEqual(t, nil, err)
 	}()
 
@@ -43,7 +43,7 @@ func TestCacheOplogs(t *testing.T) {
 	DataGenMulti(client.Database(dbName), 4096, 3)
 
 	time.Sleep(1 * time.Second)
-	streamer.LiveStream()
+	streamer.LiveStream(ctx)
 	DataGenMulti(client.Database(dbName), 1024, 3)
 	time.Sleep(1 * time.Second)
 }
@@ -0,0 +1,179 @@
+// Copyright Kuei-chun Chen, 2022-present. All rights reserved.
+
+package hummingbird
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/simagix/keyhole/mdb"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TxnBuffer buffers oplogs of transactions not yet committed
+type TxnBuffer struct {
+	mutex  sync.Mutex
+	starts map[string]primitive.Timestamp
+	txns   map[string]primitive.A
+}
+
+// NewTxnBuffer returns a TxnBuffer
+func NewTxnBuffer() *TxnBuffer {
+	return &TxnBuffer{starts: map[string]primitive.Timestamp{}, txns: map[string]primitive.A{}}
+}
+
+// GetOldestTimestamp returns timestamp of the first oplog of the oldest pending transaction, nil if none
+func (b *TxnBuffer) GetOldestTimestamp() *primitive.Timestamp {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	var oldest *primitive.Timestamp
+	for _, ts := range b.starts {
+		if oldest == nil || primitive.CompareTimestamp(ts, *oldest) < 0 {
+			t := ts
+			oldest = &t
+		}
+	}
+	return oldest
+}
+
+// Len returns number of transactions pending commit
+func (b *TxnBuffer) Len() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return len(b.txns)
+}
+
+// Assemble buffers partial and prepared transactions, and returns oplogs where a committed
+// transaction becomes a single applyOps at its commit point and aborted ones are removed
+func (b *TxnBuffer) Assemble(oplogs []Oplog) []Oplog {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	assembled := []Oplog{}
+	for _, oplog := range oplogs {
+		if !IsTxnOplog(oplog) {
+			assembled = append(assembled, oplog)
+			continue
+		}
+		key := getTxnKey(oplog)
+		doc := oplog.Object.Map()
+		if ops, ok := doc["applyOps"].(primitive.A); ok {
+			if doc["partialTxn"] == true || doc["prepare"] == true {
+				if _, ok := b.starts[key]; !ok {
+					b.starts[key] = oplog.Timestamp
+				}
+				b.txns[key] = append(b.txns[key], ops...)
+				continue
+			}
+			if buffered, ok := b.txns[key]; ok { // last oplog of a chain
+				b.remove(key)
+				oplog.Object = bson.D{{"applyOps", append(buffered, ops...)}}
+			}
+			assembled = append(assembled, oplog)
+		} else if doc["commitTransaction"] != nil {
+			if buffered, ok := b.txns[key]; ok {
+				b.remove(key)
+				assembled = append(assembled, Oplog{LSID: oplog.LSID, Namespace: oplog.Namespace,
+					Object: bson.D{{"applyOps", buffered}}, Operation: "c",
+					Timestamp: oplog.Timestamp, TxnNumber: oplog.TxnNumber})
+			}
+		} else if doc["abortTransaction"] != nil {
+			b.remove(key)
+		} else {
+			assembled = append(assembled, oplog)
+		}
+	}
+	return assembled
+}
+
+func (b *TxnBuffer) remove(key string) {
+	delete(b.starts, key)
+	delete(b.txns, key)
+}
+
+// IsTxnOplog returns true if an oplog is written by a multi-document transaction
+func IsTxnOplog(oplog Oplog) bool {
+	return oplog.Operation == "c" && len(oplog.LSID) > 0 && oplog.TxnNumber != nil
+}
+
+// isChainedTxnOplog returns true if an oplog is a part of a transaction written in more than one oplog
+func isChainedTxnOplog(oplog Oplog) bool {
+	if !IsTxnOplog(oplog) {
+		return false
+	}
+	doc := oplog.Object.Map()
+	if doc["partialTxn"] == true || doc["prepare"] == true {
+		return true
+	}
+	return oplog.PrevOpTime != nil && !oplog.PrevOpTime.Timestamp.IsZero()
+}
+
+func getTxnKey(oplog Oplog) string {
+	return fmt.Sprintf("%v.%v", GetIDKey(oplog.LSID), *oplog.TxnNumber)
+}
+
+// ApplyTransaction applies write models of a committed transaction atomically in a session transaction
+func ApplyTransaction(client *mongo.Client, wmodels []OplogWriteModel, results *BulkWriteOplogsResult) error {
+	ctx := context.Background()
+	session, err := client.StartSession()
+	if err != nil {
+		return fmt.Errorf("StartSession failed: %v", err)
+	}
+	defer session.EndSession(ctx)
+	var txnResults BulkWriteOplogsResult
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		txnResults = BulkWriteOplogsResult{}
+		for i := 0; i < len(wmodels); {
+			wmodel := wmodels[i]
+			if wmodel.Operation == "c" {
+				dbName, _ := mdb.SplitNamespace(wmodel.Namespace)
+				if err := client.Database(dbName).RunCommand(sc, wmodel.Command).Err(); err != nil {
+					return nil, fmt.Errorf("%v failed: %v", Stringify(wmodel.Command), err)
+				}
+				txnResults.CommandCount++
+				i++
+				continue
+			}
+			models := []mongo.WriteModel{}
+			for ; i < len(wmodels) && wmodels[i].Namespace == wmodel.Namespace && wmodels[i].Operation != "c"; i++ {
+				models = append(models, getIdempotentWriteModel(wmodels[i]))
+			}
+			dbName, collName := mdb.SplitNamespace(wmodel.Namespace)
+			result, err := client.Database(dbName).Collection(collName).BulkWrite(sc, models, options.BulkWrite().SetOrdered(true))
+			if err != nil {
+				return nil, err
+			}
+			txnResults.DeletedCount += result.DeletedCount
+			txnResults.InsertedCount += result.InsertedCount
+			txnResults.ModifiedCount += result.ModifiedCount
+			txnResults.UpsertedCount += result.UpsertedCount
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return fmt.Errorf("WithTransaction failed: %v", err)
+	}
+	txnResults.TransactionCount = 1
+	results.add(txnResults)
+	return nil
+}
+
+// getIdempotentWriteModel replaces an insert with an upsert because a duplicate key aborts a transaction
+func getIdempotentWriteModel(wmodel OplogWriteModel) mongo.WriteModel {
+	insert, ok := wmodel.WriteModel.(*mongo.InsertOneModel)
+	if !ok {
+		return wmodel.WriteModel
+	}
+	doc, ok := insert.Document.(bson.D)
+	if !ok {
+		return wmodel.WriteModel
+	}
+	op := mongo.NewReplaceOneModel()
+	op.SetFilter(bson.D{{"_id", doc.Map()["_id"]}})
+	op.SetReplacement(doc)
+	op.SetUpsert(true)
+	return op
+}
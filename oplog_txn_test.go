@@ -0,0 +1,96 @@
+// Copyright Kuei-chun Chen, 2022-present. All rights reserved.
+
+package hummingbird
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func getTxnOplog(t *testing.T, txnNumber int64, ts uint32, object string) Oplog {
+	oplog := Oplog{LSID: bson.D{{"id", "session"}}, Namespace: "admin.$cmd", Operation: "c",
+		Timestamp: primitive.Timestamp{T: ts}, TxnNumber: &txnNumber}
+	err := bson.UnmarshalExtJSON([]byte(object), false, &oplog.Object)
+	assertEqual(t, nil, err)
+	return oplog
+}
+
+func TestTxnBufferAssemble(t *testing.T) {
+	migratorInstance = &Migrator{included: map[string]*Include{}}
+	insert := `{ "op": "i", "ns": "testdb.neutrino", "o": { "_id": 1 } }`
+	buffer := NewTxnBuffer()
+	oplogs := []Oplog{
+		getTxnOplog(t, 1, 1, `{ "applyOps": [`+insert+`], "partialTxn": true }`),
+		{Namespace: "testdb.neutrino", Operation: "d", Object: bson.D{{"_id", 2}}, Timestamp: primitive.Timestamp{T: 2}},
+		getTxnOplog(t, 2, 3, `{ "applyOps": [`+insert+`], "prepare": true }`),
+		getTxnOplog(t, 3, 4, `{ "applyOps": [`+insert+`], "partialTxn": true }`),
+	}
+	assembled := buffer.Assemble(oplogs)
+	assertEqual(t, 1, len(assembled))
+	assertEqual(t, "d", assembled[0].Operation)
+	assertEqual(t, 3, buffer.Len())
+
+	oplogs = []Oplog{
+		getTxnOplog(t, 1, 5, `{ "applyOps": [`+insert+`, `+insert+`], "count": 3 }`),
+		getTxnOplog(t, 3, 6, `{ "abortTransaction": 1 }`),
+		getTxnOplog(t, 2, 7, `{ "commitTransaction": 1 }`),
+		getTxnOplog(t, 4, 8, `{ "applyOps": [`+insert+`] }`),
+	}
+	assembled = buffer.Assemble(oplogs)
+	assertEqual(t, 0, buffer.Len())
+	assertEqual(t, 3, len(assembled))
+	assertEqual(t, 3, len(assembled[0].Object.Map()["applyOps"].(primitive.A)))
+	assertEqual(t, uint32(5), assembled[0].Timestamp.T)
+	assertEqual(t, 1, len(assembled[1].Object.Map()["applyOps"].(primitive.A)))
+	assertEqual(t, uint32(7), assembled[1].Timestamp.T)
+	assertEqual(t, uint32(8), assembled[2].Timestamp.T)
+	assertEqual(t, 3, len(GetWriteModels(assembled[0])))
+	assertEqual(t, 1, len(GetWriteModels(assembled[2])))
+}
+
+func TestSkipOplogTxn(t *testing.T) {
+	migratorInstance = &Migrator{included: map[string]*Include{}}
+	migratorInstance.included["testdb.neutrino"] = &Include{Namespace: "testdb.neutrino"}
+	skipped := `{ "op": "i", "ns": "testdb.skipped", "o": { "_id": 1 } }`
+	insert := `{ "op": "i", "ns": "testdb.neutrino", "o": { "_id": 1 } }`
+	assertEqual(t, true, SkipOplog(getTxnOplog(t, 1, 1, `{ "applyOps": [`+skipped+`] }`)))
+	assertEqual(t, false, SkipOplog(getTxnOplog(t, 1, 1, `{ "applyOps": [`+skipped+`], "partialTxn": true }`)))
+	oplog := getTxnOplog(t, 1, 1, `{ "applyOps": [`+skipped+`, `+insert+`] }`)
+	assertEqual(t, false, SkipOplog(oplog))
+	assertEqual(t, 1, len(GetWriteModels(oplog)))
+}
+
+func TestTxnBufferGetOldestTimestamp(t *testing.T) {
+	migratorInstance = &Migrator{included: map[string]*Include{}}
+	insert := `{ "op": "i", "ns": "testdb.neutrino", "o": { "_id": 1 } }`
+	buffer := NewTxnBuffer()
+	assertEqual(t, true, buffer.GetOldestTimestamp() == nil)
+	buffer.Assemble([]Oplog{
+		getTxnOplog(t, 1, 10, `{ "applyOps": [`+insert+`], "partialTxn": true }`),
+		getTxnOplog(t, 2, 11, `{ "applyOps": [`+insert+`], "partialTxn": true }`),
+		getTxnOplog(t, 1, 12, `{ "applyOps": [`+insert+`], "partialTxn": true }`),
+	})
+	assertEqual(t, uint32(10), buffer.GetOldestTimestamp().T)
+	buffer.Assemble([]Oplog{getTxnOplog(t, 1, 13, `{ "applyOps": [`+insert+`] }`)})
+	assertEqual(t, uint32(11), buffer.GetOldestTimestamp().T)
+	buffer.Assemble([]Oplog{getTxnOplog(t, 2, 14, `{ "abortTransaction": 1 }`)})
+	assertEqual(t, true, buffer.GetOldestTimestamp() == nil)
+}
+
+func TestSkipAppliedRebuildsTxn(t *testing.T) {
+	migratorInstance = &Migrator{included: map[string]*Include{}}
+	insert := `{ "op": "i", "ns": "testdb.neutrino", "o": { "_id": 1 } }`
+	streamer := OplogStreamer{applied: primitive.Timestamp{T: 12}, txns: NewTxnBuffer()}
+	oplogs := []Oplog{ // read again from the oldest pending transaction
+		getTxnOplog(t, 1, 10, `{ "applyOps": [`+insert+`], "partialTxn": true }`),
+		{Namespace: "testdb.neutrino", Operation: "d", Object: bson.D{{"_id", 2}}, Timestamp: primitive.Timestamp{T: 11}},
+		{Namespace: "testdb.neutrino", Operation: "d", Object: bson.D{{"_id", 3}}, Timestamp: primitive.Timestamp{T: 12}},
+		getTxnOplog(t, 1, 13, `{ "applyOps": [`+insert+`] }`),
+	}
+	unapplied := streamer.skipApplied(streamer.txns.Assemble(oplogs))
+	assertEqual(t, 2, len(unapplied))
+	assertEqual(t, uint32(12), unapplied[0].Timestamp.T)
+	assertEqual(t, 2, len(unapplied[1].Object.Map()["applyOps"].(primitive.A)))
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/simagix/gox"
@@ -19,8 +20,13 @@ import (
 
 // OplogWriteModel stores namespace and writeModel
 type OplogWriteModel struct {
+	Command    bson.D
+	ID         interface{}
+	IsLookup   bool
 	Namespace  string
 	Operation  string
+	Source     string
+	Timestamp  primitive.Timestamp
 	WriteModel mongo.WriteModel
 }
 
@@ -46,12 +52,15 @@ func SkipOplog(oplog Oplog) bool {
 	inst := GetMigratorInstance()
 	if collName == "$cmd" {
 		for _, v := range oplog.Object {
-			if v.Key == "dropDatabase" {
-				return inst.SkipNamespace(dbName + ".*")
-			} else if v.Key == "create" || v.Key == "createIndexes" || v.Key == "drop" || v.Key == "renameCollection" {
-				collName = v.Value.(string)
-				return inst.SkipNamespace(dbName + "." + collName)
+			if ns := getCommandNamespace(oplog); ns != "" {
+				if to := getRenameTarget(oplog); to != "" && !inst.SkipNamespace(to) {
+					return false
+				}
+				return inst.SkipNamespace(ns)
 			} else if v.Key == "applyOps" {
+				if isChainedTxnOplog(oplog) { // needed to commit a transaction
+					return false
+				}
 				oplogs, ok := v.Value.(primitive.A)
 				if !ok {
 					continue
@@ -65,9 +74,11 @@ func SkipOplog(oplog Oplog) bool {
 					if err = bson.Unmarshal(data, &doc); err != nil {
 						continue
 					}
-					return inst.SkipNamespace(doc.Namespace)
+					if !inst.SkipNamespace(doc.Namespace) {
+						return false
+					}
 				}
-				return false // unknown, keep it for further investigation
+				return len(oplogs) > 0
 			}
 		}
 		return false // unknown, keep it for further investigation
@@ -77,14 +88,30 @@ func SkipOplog(oplog Oplog) bool {
 
 // BulkWriteOplogsResult stores results
 type BulkWriteOplogsResult struct {
-	DeletedCount  int64
-	InsertedCount int64
-	ModifiedCount int64
-	UpsertedCount int64
-	TotalCount    int64
+	CommandCount     int64
+	ConflictCount    int64
+	DeletedCount     int64
+	InsertedCount    int64
+	ModifiedCount    int64
+	TotalCount       int64
+	TransactionCount int64
+	UpsertedCount    int64
+
+	err error // first error other than write errors, i.e. target unreachable
 }
 
-// BulkWriteOplogs applies oplogs in bulk
+const (
+	// ApplyNamespace groups writes of a batch by namespace
+	ApplyNamespace = "namespace"
+	// ApplyOrdered applies writes in oplog order, batching consecutive writes to the same namespace
+	ApplyOrdered = "ordered"
+	// ApplyParallel groups writes of a batch by namespace and applies namespaces concurrently
+	ApplyParallel = "parallel"
+)
+
+// BulkWriteOplogs applies oplogs in bulk, commands and transactions are applied in order and
+// CRUD writes in between are applied by the apply mode.  It returns an error on conflicts if the
+// conflict policy is fail, and on errors other than write errors, so that oplogs are applied again.
 func BulkWriteOplogs(oplogs []Oplog) (*BulkWriteOplogsResult, error) {
 	var results = BulkWriteOplogsResult{}
 	inst := GetMigratorInstance()
@@ -93,127 +120,445 @@ func BulkWriteOplogs(oplogs []Oplog) (*BulkWriteOplogsResult, error) {
 	if err != nil {
 		return &results, fmt.Errorf("GetMongoClient failed: %v", err)
 	}
-	opts := options.BulkWrite()
-	modelsMap := map[string][]OplogWriteModel{}
+	mode := inst.Apply
+	pending := []OplogWriteModel{}
+	isFailed := func() bool {
+		return results.err != nil || (inst.Conflict == ConflictFail && results.ConflictCount > 0)
+	}
 	for _, oplog := range oplogs {
 		if SkipOplog(oplog) {
 			continue
 		}
 		writeModels := GetWriteModels(oplog)
+		if IsTxnOplog(oplog) && len(writeModels) > 0 {
+			results.ConflictCount += int64(bulkWritePending(client, pending, mode, &results)) // writes before a transaction
+			pending = []OplogWriteModel{}
+			var unresolved int
+			writeModels, unresolved = lookupWriteModels(client, writeModels, &results)
+			results.ConflictCount += int64(unresolved)
+			if isFailed() {
+				break
+			} else if err = ApplyTransaction(client, writeModels, &results); err != nil { // no writes applied
+				logger.Warnf("ApplyTransaction exception: %v", err)
+				conflicts := []Conflict{}
+				for _, wmodel := range writeModels {
+					conflicts = append(conflicts, NewConflict(wmodel, ReasonTxnAborted+", "+err.Error()))
+				}
+				results.ConflictCount += int64(ResolveConflicts(client, conflicts, writeModels, &results))
+			}
+			if isFailed() {
+				break
+			}
+			continue
+		}
 		for _, wmodel := range writeModels {
-			modelsMap[wmodel.Namespace] = append(modelsMap[wmodel.Namespace], wmodel)
+			if wmodel.Operation != "c" {
+				pending = append(pending, wmodel)
+				continue
+			}
+			results.ConflictCount += int64(bulkWritePending(client, pending, mode, &results)) // writes before a command
+			pending = []OplogWriteModel{}
+			if isFailed() {
+				break
+			} else if err = RunCommandWriteModel(client, wmodel); err != nil {
+				logger.Warnf("RunCommandWriteModel exception: %v", err)
+				conflict := NewConflict(wmodel, err.Error())
+				conflict.Resolution = inst.Conflict
+				ws := inst.Workspace()
+				ws.AddConflicts([]Conflict{conflict})
+				results.ConflictCount++
+				continue
+			}
+			results.CommandCount++
+		}
+		if isFailed() {
+			break
 		}
 	}
-	ctx := context.Background()
-	var result *mongo.BulkWriteResult
-	missed := 0
-	for ns, wmodels := range modelsMap {
-		inserts := []mongo.WriteModel{}
-		others := []mongo.WriteModel{}
-		dbName, collName := mdb.SplitNamespace(ns)
-		coll := client.Database(dbName).Collection(collName)
-		for _, wmodel := range wmodels {
-			if wmodel.Operation == "i" {
-				if len(others) > 0 { // flush the others
-					opts.SetOrdered(true)
-					result, err = coll.BulkWrite(ctx, others, opts)
-					if result != nil {
-						results.DeletedCount += result.DeletedCount
-						results.ModifiedCount += result.ModifiedCount
-						results.UpsertedCount += result.UpsertedCount
-					}
-					updated := result.DeletedCount + result.ModifiedCount + result.UpsertedCount
-					if int(updated) != len(others) {
-						extra := 0
-						for _, other := range others[updated:] {
-							if result, err = coll.BulkWrite(ctx, []mongo.WriteModel{other}); err != nil {
-								logger.Warnf("BulkWrites exception: %v", err)
-							} else {
-								results.DeletedCount += result.DeletedCount
-								results.UpsertedCount += result.UpsertedCount
-								results.ModifiedCount += result.ModifiedCount
-								extra += int(result.DeletedCount + result.UpsertedCount + result.ModifiedCount)
-							}
-						}
-						missed += (len(others) - int(updated) - int(extra))
-					}
-					others = []mongo.WriteModel{}
-				}
-				inserts = append(inserts, wmodel.WriteModel)
-			} else {
-				if len(inserts) > 0 { // flush inserts
-					opts.SetOrdered(false)
-					if result, err = coll.BulkWrite(ctx, inserts, opts); err != nil {
-						if mdb.IsDuplicateKeyError(err) {
-							results.InsertedCount += int64(len(inserts))
-						} else {
-							logger.Warnf("BulkWrites exception: %v", err)
-						}
-					} else {
-						results.InsertedCount += result.InsertedCount
-					}
-					inserts = []mongo.WriteModel{}
-				}
-				others = append(others, wmodel.WriteModel)
+	if !isFailed() {
+		results.ConflictCount += int64(bulkWritePending(client, pending, mode, &results))
+	}
+	results.TotalCount = results.InsertedCount + results.ModifiedCount + results.DeletedCount + results.UpsertedCount +
+		results.CommandCount
+	if int(results.TotalCount) < len(oplogs) {
+		logger.Debugf("oplogs:%v, conflicts:%v, inserted:%v, modified:%v, deleted:%v, upserted:%v, commands:%v, transactions:%v",
+			len(oplogs), results.ConflictCount, results.InsertedCount, results.ModifiedCount, results.DeletedCount,
+			results.UpsertedCount, results.CommandCount, results.TransactionCount)
+	}
+	if results.err != nil {
+		return &results, fmt.Errorf("bulk write failed: %v", results.err)
+	} else if isFailed() {
+		return &results, fmt.Errorf("%v conflict(s) found, see %v.%v", results.ConflictCount, MetaDBName, MetaConflicts)
+	}
+	return &results, nil
+}
+
+// add accumulates counts of another result
+func (r *BulkWriteOplogsResult) add(other BulkWriteOplogsResult) {
+	r.CommandCount += other.CommandCount
+	r.ConflictCount += other.ConflictCount
+	r.DeletedCount += other.DeletedCount
+	r.InsertedCount += other.InsertedCount
+	r.ModifiedCount += other.ModifiedCount
+	r.TransactionCount += other.TransactionCount
+	r.UpsertedCount += other.UpsertedCount
+	if r.err == nil {
+		r.err = other.err
+	}
+}
+
+// GetWriteModelRuns splits write models into runs of the same namespace. In ordered mode, runs are
+// consecutive writes and never reordered, otherwise writes are grouped by namespace in order of appearance.
+func GetWriteModelRuns(wmodels []OplogWriteModel, mode string) [][]OplogWriteModel {
+	runs := [][]OplogWriteModel{}
+	if mode == ApplyOrdered {
+		for i, wmodel := range wmodels {
+			if i == 0 || wmodel.Namespace != wmodels[i-1].Namespace {
+				runs = append(runs, []OplogWriteModel{})
 			}
+			runs[len(runs)-1] = append(runs[len(runs)-1], wmodel)
 		}
-		if len(inserts) > 0 { // flush inserts
-			opts.SetOrdered(false)
-			if result, err = coll.BulkWrite(ctx, inserts, opts); err != nil {
-				if mdb.IsDuplicateKeyError(err) {
-					results.InsertedCount += int64(len(inserts))
-				} else {
-					logger.Warnf("BulkWrites exception: %v", err)
-				}
-			} else {
-				results.InsertedCount += result.InsertedCount
-			}
+		return runs
+	}
+	index := map[string]int{}
+	for _, wmodel := range wmodels {
+		n, ok := index[wmodel.Namespace]
+		if !ok {
+			n = len(runs)
+			index[wmodel.Namespace] = n
+			runs = append(runs, []OplogWriteModel{})
+		}
+		runs[n] = append(runs[n], wmodel)
+	}
+	return runs
+}
+
+// bulkWritePending applies CRUD write models by the apply mode and returns number of conflicts. Runs
+// are applied concurrently in parallel mode, which is safe because runs are of different namespaces and
+// therefore never write to the same document.
+func bulkWritePending(client *mongo.Client, wmodels []OplogWriteModel, mode string, results *BulkWriteOplogsResult) int {
+	wmodels, conflicts := lookupWriteModels(client, wmodels, results)
+	runs := GetWriteModelRuns(wmodels, mode)
+	if mode != ApplyParallel || len(runs) < 2 {
+		for _, run := range runs {
+			conflicts += bulkWriteNamespace(client, run, results)
 		}
-		if len(others) > 0 { // flush the others
+		return conflicts
+	}
+	var mutex sync.Mutex
+	wg := gox.NewWaitGroup(GetMigratorInstance().Workers)
+	for _, run := range runs {
+		wg.Add(1)
+		go func(run []OplogWriteModel) {
+			defer wg.Done()
+			var result BulkWriteOplogsResult
+			n := bulkWriteNamespace(client, run, &result)
+			mutex.Lock()
+			defer mutex.Unlock()
+			conflicts += n
+			results.add(result)
+		}(run)
+	}
+	wg.Wait()
+	return conflicts
+}
+
+// bulkWriteNamespace applies CRUD write models of a namespace, resolves conflicts, and returns number of
+// unresolved conflicts.  Inserts of existing documents and updates matching no documents are conflicts,
+// deletes matching no documents are not because targets are already consistent.
+func bulkWriteNamespace(client *mongo.Client, wmodels []OplogWriteModel, results *BulkWriteOplogsResult) int {
+	var err error
+	opts := options.BulkWrite()
+	ctx := context.Background()
+	var result *mongo.BulkWriteResult
+	conflicts := []Conflict{}
+	conflicted := []OplogWriteModel{}
+	inserts := []OplogWriteModel{}
+	others := []OplogWriteModel{}
+	dbName, collName := mdb.SplitNamespace(wmodels[0].Namespace)
+	coll := client.Database(dbName).Collection(collName)
+	addConflict := func(wmodel OplogWriteModel, reason string) {
+		conflicts = append(conflicts, NewConflict(wmodel, reason))
+		conflicted = append(conflicted, wmodel)
+	}
+	flushOthers := func() {
+		for len(others) > 0 {
 			opts.SetOrdered(true)
-			result, err = coll.BulkWrite(ctx, others, opts)
+			result, err = coll.BulkWrite(ctx, toMongoWriteModels(others), opts)
+			applied := others
 			if result != nil {
 				results.DeletedCount += result.DeletedCount
 				results.ModifiedCount += result.ModifiedCount
 				results.UpsertedCount += result.UpsertedCount
 			}
-			updated := result.DeletedCount + result.ModifiedCount + result.UpsertedCount
-			if int(updated) != len(others) {
-				extra := 0
-				for _, other := range others[updated:] {
-					if result, err = coll.BulkWrite(ctx, []mongo.WriteModel{other}); err != nil {
-						logger.Warnf("BulkWrites exception: %v", err)
-					} else {
-						results.DeletedCount += result.DeletedCount
-						results.UpsertedCount += result.UpsertedCount
-						results.ModifiedCount += result.ModifiedCount
-						extra += int(result.DeletedCount + result.UpsertedCount + result.ModifiedCount)
-					}
+			if err != nil && !isWriteError(err) {
+				results.err = err
+				return
+			} else if err != nil { // ordered writes stop at the first error
+				next := len(others)
+				for index, we := range getWriteErrors(err, 1) {
+					next = index + 1
+					addConflict(others[index], getConflictReason(we))
+					applied = others[:index]
 				}
-				missed += (len(others) - int(updated) - int(extra))
+				others = others[next:]
+			} else {
+				others = nil
+			}
+			if result != nil {
+				addUnmatchedConflicts(coll, applied, result, addConflict)
 			}
-			others = []mongo.WriteModel{}
 		}
 	}
-	results.TotalCount = results.InsertedCount + results.ModifiedCount + results.DeletedCount + results.UpsertedCount
-	if int(results.TotalCount) < len(oplogs) {
-		logger.Debugf("oplogs:%v, missed:%v, inserted:%v, modified:%v, deleted:%v, upserted:%v",
-			len(oplogs), missed,
-			results.InsertedCount, results.ModifiedCount, results.DeletedCount, results.UpsertedCount)
+	flushInserts := func() {
+		opts.SetOrdered(false)
+		if result, err = coll.BulkWrite(ctx, toMongoWriteModels(inserts), opts); result != nil {
+			results.InsertedCount += result.InsertedCount
+		}
+		if err != nil && !isWriteError(err) {
+			results.err = err
+			inserts = nil
+			return
+		}
+		for index, we := range getWriteErrors(err, len(inserts)) {
+			addConflict(inserts[index], getConflictReason(we))
+		}
+		inserts = nil
 	}
-	return &results, nil
+	for _, wmodel := range wmodels {
+		if results.err != nil {
+			return 0
+		}
+		if wmodel.Operation == "i" {
+			if len(others) > 0 {
+				flushOthers()
+			}
+			inserts = append(inserts, wmodel)
+		} else {
+			if len(inserts) > 0 {
+				flushInserts()
+			}
+			others = append(others, wmodel)
+		}
+	}
+	if len(inserts) > 0 && results.err == nil {
+		flushInserts()
+	}
+	if len(others) > 0 && results.err == nil {
+		flushOthers()
+	}
+	return ResolveConflicts(client, conflicts, conflicted, results)
+}
+
+// addUnmatchedConflicts finds updates matching no documents when matched counts fall short
+func addUnmatchedConflicts(coll *mongo.Collection, wmodels []OplogWriteModel, result *mongo.BulkWriteResult,
+	addConflict func(OplogWriteModel, string)) {
+	updates := []OplogWriteModel{}
+	for _, wmodel := range wmodels {
+		if wmodel.Operation == "u" {
+			updates = append(updates, wmodel)
+		}
+	}
+	if len(updates) == 0 || int(result.MatchedCount+result.UpsertedCount) >= len(updates) {
+		return
+	}
+	ids := bson.A{}
+	for _, wmodel := range updates {
+		ids = append(ids, wmodel.ID)
+	}
+	ctx := context.Background()
+	opts := options.Find().SetProjection(bson.D{{"_id", 1}})
+	cursor, err := coll.Find(ctx, bson.D{{"_id", bson.D{{"$in", ids}}}}, opts)
+	if err != nil {
+		gox.GetLogger("BulkWriteOplogs").Warnf("Find failed: %v", err)
+		return
+	}
+	defer cursor.Close(ctx)
+	existing := map[string]bool{}
+	for cursor.Next(ctx) {
+		var doc bson.D
+		if err = cursor.Decode(&doc); err == nil {
+			existing[GetIDKey(doc.Map()["_id"])] = true
+		}
+	}
+	for _, wmodel := range updates {
+		if !existing[GetIDKey(wmodel.ID)] {
+			addConflict(wmodel, ReasonNotMatched)
+		}
+	}
+}
+
+// toMongoWriteModels returns WriteModel of write models
+func toMongoWriteModels(wmodels []OplogWriteModel) []mongo.WriteModel {
+	models := []mongo.WriteModel{}
+	for _, wmodel := range wmodels {
+		models = append(models, wmodel.WriteModel)
+	}
+	return models
+}
+
+// getOplogID returns _id of the document an oplog writes to
+func getOplogID(oplog Oplog) interface{} {
+	if oplog.Operation == "u" {
+		return oplog.Query.Map()["_id"]
+	}
+	return oplog.Object.Map()["_id"]
+}
+
+// getUpdateWriteModels returns an update WriteModel after transforms, none if nothing is left to update
+func getUpdateWriteModels(ns string, oplog Oplog, update bson.D, include *Include) []OplogWriteModel {
+	if include != nil && len(include.Transforms) > 0 {
+		var err error
+		if update, err = TransformUpdate(update, include.Transforms); err != nil {
+			gox.GetLogger("GetWriteModels").Errorf("%v TransformUpdate failed: %v", oplog.Namespace, err)
+			return nil
+		} else if len(update) == 0 {
+			return []OplogWriteModel{}
+		}
+	}
+	op := mongo.NewUpdateOneModel()
+	op.SetFilter(oplog.Query)
+	op.SetUpdate(update)
+	return []OplogWriteModel{{Namespace: ns, Operation: oplog.Operation, WriteModel: op}}
+}
+
+// getFilteredWriteModels evaluates include filter against the post-image of a document, i.e. inserted or
+// replaced, returns an upsert if it matches or a delete if it doesn't, i.e. moved out of the filter.  An
+// update without a post-image, or a filter not supported in memory, returns a lookup of the source document.
+func getFilteredWriteModels(ns string, oplog Oplog, include *Include) []OplogWriteModel {
+	filter := oplog.Query
+	if oplog.Operation == "i" || len(filter) == 0 {
+		filter = bson.D{{"_id", getOplogID(oplog)}}
+	}
+	if oplog.Operation == "i" || isReplacement(oplog.Object) {
+		matched, err := MatchFilter(oplog.Object, include.Filter)
+		if err == nil && !matched {
+			if oplog.Operation == "i" { // never copied
+				return nil
+			}
+			op := mongo.NewDeleteOneModel()
+			op.SetFilter(filter)
+			return []OplogWriteModel{{Namespace: ns, Operation: "d", WriteModel: op}}
+		} else if err == nil {
+			doc, err := processSourceDocument(oplog.Object, include)
+			if err != nil {
+				gox.GetLogger("GetWriteModels").Errorf("%v processSourceDocument failed: %v", oplog.Namespace, err)
+				return nil
+			}
+			op := mongo.NewReplaceOneModel()
+			op.SetFilter(filter)
+			op.SetReplacement(doc)
+			op.SetUpsert(true)
+			return []OplogWriteModel{{Namespace: ns, Operation: "u", WriteModel: op}}
+		}
+		gox.GetLogger("GetWriteModels").Debugf("%v filter evaluated by source: %v", oplog.Namespace, err)
+	}
+	return []OplogWriteModel{{IsLookup: true, Namespace: ns, Operation: "u"}}
+}
+
+// isReplacement returns true if the object of an update oplog is a replacing document
+func isReplacement(object bson.D) bool {
+	for _, v := range object {
+		if v.Key == "diff" || strings.HasPrefix(v.Key, "$") {
+			return false
+		}
+	}
+	return len(object) > 0
+}
+
+// lookupWriteModels replaces lookups with upserts of source documents matching include filters, or deletes
+// if not matched, by one query of a namespace.  Lookups failed are resolved as conflicts, it returns write
+// models and number of unresolved conflicts.
+func lookupWriteModels(client *mongo.Client, wmodels []OplogWriteModel, results *BulkWriteOplogsResult) (
+	[]OplogWriteModel, int) {
+	ids := map[string][]interface{}{}
+	for _, wmodel := range wmodels {
+		if wmodel.IsLookup {
+			ids[wmodel.Source] = append(ids[wmodel.Source], wmodel.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return wmodels, 0
+	}
+	inst := GetMigratorInstance()
+	docs := map[string]map[string]bson.D{}
+	errs := map[string]error{}
+	for ns, list := range ids {
+		if docs[ns], errs[ns] = getSourceDocuments(ns, list, inst.GetInclude(ns)); errs[ns] != nil {
+			gox.GetLogger("BulkWriteOplogs").Warnf("%v getSourceDocuments failed: %v", ns, errs[ns])
+		}
+	}
+	resolved := []OplogWriteModel{}
+	conflicts := []Conflict{}
+	conflicted := []OplogWriteModel{}
+	for _, wmodel := range wmodels {
+		if !wmodel.IsLookup {
+			resolved = append(resolved, wmodel)
+			continue
+		} else if err := errs[wmodel.Source]; err != nil {
+			conflicts = append(conflicts, NewConflict(wmodel, err.Error()))
+			conflicted = append(conflicted, wmodel)
+			continue
+		}
+		wmodel.IsLookup = false
+		filter := bson.D{{"_id", wmodel.ID}}
+		if doc, ok := docs[wmodel.Source][GetIDKey(wmodel.ID)]; ok {
+			op := mongo.NewReplaceOneModel()
+			op.SetFilter(filter)
+			op.SetReplacement(doc)
+			op.SetUpsert(true)
+			wmodel.WriteModel = op
+		} else { // not matched or deleted
+			op := mongo.NewDeleteOneModel()
+			op.SetFilter(filter)
+			wmodel.Operation = "d"
+			wmodel.WriteModel = op
+		}
+		resolved = append(resolved, wmodel)
+	}
+	return resolved, ResolveConflicts(client, conflicts, conflicted, results)
 }
 
 // GetWriteModels returns WriteModel from an oplog
 func GetWriteModels(oplog Oplog) []OplogWriteModel {
+	wmodels := getWriteModels(oplog)
+	for i := range wmodels {
+		if wmodels[i].ID == nil && wmodels[i].Operation != "c" {
+			wmodels[i].ID = getOplogID(oplog)
+		}
+		if wmodels[i].Source == "" {
+			wmodels[i].Source = oplog.Namespace
+		}
+		if wmodels[i].Timestamp.IsZero() {
+			wmodels[i].Timestamp = oplog.Timestamp
+		}
+	}
+	return wmodels
+}
+
+func getWriteModels(oplog Oplog) []OplogWriteModel {
 	inst := GetMigratorInstance()
 	ns := inst.GetToNamespace(oplog.Namespace)
+	include := inst.GetInclude(oplog.Namespace)
+	isMask := include != nil && len(include.Masks) > 0
+	isTransform := include != nil && len(include.Transforms) > 0
+	if include != nil && include.Limit > 0 && oplog.Operation != "c" && oplog.Operation != "n" {
+		if !inst.IsSampledID(oplog.Namespace, getOplogID(oplog)) { // not in the sampled subset
+			return nil
+		}
+	}
+	if include != nil && len(include.Filter) > 0 && (oplog.Operation == "i" || oplog.Operation == "u") {
+		return getFilteredWriteModels(ns, oplog, include)
+	}
 	switch oplog.Operation {
 	case "c":
+		if getCommandNamespace(oplog) != "" {
+			return getCommandWriteModels(oplog)
+		}
 		var err error
 		wmodels := []OplogWriteModel{}
 		for _, v := range oplog.Object {
-			if v.Key != "applyOps" { // not supported yet
+			if v.Key != "applyOps" {
 				continue
 			}
 			oplogs, ok := v.Value.(primitive.A)
@@ -229,6 +574,9 @@ func GetWriteModels(oplog Oplog) []OplogWriteModel {
 				if err = bson.Unmarshal(data, &oplog); err != nil {
 					break
 				}
+				if SkipOplog(oplog) {
+					continue
+				}
 				wmodels = append(wmodels, GetWriteModels(oplog)...)
 			}
 			gox.GetLogger().Debugf("c found applyOps %d", len(wmodels))
@@ -237,43 +585,71 @@ func GetWriteModels(oplog Oplog) []OplogWriteModel {
 	case "d":
 		op := mongo.NewDeleteOneModel()
 		op.SetFilter(oplog.Object)
-		return []OplogWriteModel{OplogWriteModel{ns, oplog.Operation, op}}
+		return []OplogWriteModel{{Namespace: ns, Operation: oplog.Operation, WriteModel: op}}
 	case "i":
+		if isMask {
+			MaskFields(&oplog.Object, include.Masks, include.Method)
+		}
+		if isTransform {
+			var err error
+			if oplog.Object, err = TransformDocument(oplog.Object, include.Transforms); err != nil {
+				gox.GetLogger("GetWriteModels").Errorf("%v TransformDocument failed: %v", oplog.Namespace, err)
+				return nil
+			}
+		}
 		op := mongo.NewInsertOneModel()
 		op.SetDocument(oplog.Object)
-		return []OplogWriteModel{OplogWriteModel{ns, oplog.Operation, op}}
+		return []OplogWriteModel{{Namespace: ns, Operation: oplog.Operation, WriteModel: op}}
 	case "n":
 		return nil
 	case "u":
 		o := oplog.Object
+		isUpdate := false
+		updates := []bson.D{}
+		update := bson.D{}
 		for _, v := range oplog.Object {
 			if v.Key == "diff" {
-				for _, doc := range v.Value.(bson.D) {
-					if doc.Key == "u" || doc.Key == "i" {
-						op := mongo.NewUpdateOneModel()
-						op.SetFilter(oplog.Query)
-						op.SetUpdate(bson.D{{"$set", doc.Value}})
-						return []OplogWriteModel{{ns, oplog.Operation, op}}
-					} else if doc.Key == "d" {
-						op := mongo.NewUpdateOneModel()
-						op.SetFilter(oplog.Query)
-						op.SetUpdate(bson.D{{"$unset", doc.Value}})
-						return []OplogWriteModel{{ns, oplog.Operation, op}}
-					}
+				isUpdate = true
+				if diff, ok := v.Value.(bson.D); ok {
+					updates = GetDiffUpdates(diff)
 				}
-				return []OplogWriteModel{}
 			} else if v.Key != "$v" && strings.HasPrefix(v.Key, "$") {
-				o = bson.D{{v.Key, v.Value}}
-				op := mongo.NewUpdateOneModel()
-				op.SetFilter(oplog.Query)
-				op.SetUpdate(o)
-				return []OplogWriteModel{{ns, oplog.Operation, op}}
+				isUpdate = true
+				update = append(update, v)
+			}
+		}
+		if len(update) > 0 {
+			updates = append(updates, update)
+		}
+		if isUpdate && isTransform && IsLookupTransforms(include.Transforms) { // computed from other fields
+			return []OplogWriteModel{{IsLookup: true, Namespace: ns, Operation: oplog.Operation}}
+		}
+		if isUpdate {
+			wmodels := []OplogWriteModel{}
+			for _, update := range updates {
+				for _, v := range update {
+					if fields, ok := v.Value.(bson.D); ok && isMask && v.Key == "$set" {
+						MaskUpdateFields(&fields, include.Masks, include.Method)
+					}
+				}
+				wmodels = append(wmodels, getUpdateWriteModels(ns, oplog, update, include)...)
+			}
+			return wmodels
+		}
+		if isMask {
+			MaskFields(&o, include.Masks, include.Method)
+		}
+		if isTransform {
+			var err error
+			if o, err = TransformDocument(o, include.Transforms); err != nil {
+				gox.GetLogger("GetWriteModels").Errorf("%v TransformDocument failed: %v", oplog.Namespace, err)
+				return nil
 			}
 		}
 		op := mongo.NewReplaceOneModel()
 		op.SetFilter(oplog.Query)
 		op.SetReplacement(o)
-		return []OplogWriteModel{{ns, oplog.Operation, op}}
+		return []OplogWriteModel{{Namespace: ns, Operation: oplog.Operation, WriteModel: op}}
 	default:
 		log.Println("unrecognized op", oplog.Operation)
 	}
@@ -4,6 +4,7 @@ package hummingbird
 
 import (
 	"context"
+	"strings"
 	"testing"
 
 	"github.com/simagix/keyhole/mdb"
@@ -120,3 +121,134 @@ func TestBulkWriteOplogsV5(t *testing.T) {
 	_, err = BulkWriteOplogs(oplogs)
 	assertEqual(t, nil, err)
 }
+
+func TestGetWriteModelsMasks(t *testing.T) {
+	migratorInstance = &Migrator{included: map[string]*Include{}}
+	migratorInstance.included[TestNS] = &Include{Namespace: TestNS, Masks: []string{"ssn"}, Method: MaskDefault}
+	docs := []string{`{ "op" : "i", "ns" : "testdb.neutrino", "o" : { "_id": 101, "ssn" : "555-66-7878" } }`,
+		`{ "op" : "u", "ns" : "testdb.neutrino", "o" : { "$v" : 1, "$set" : { "ssn" : "555-66-7878" } }, "o2" : { "_id" : 101 } }`,
+		`{ "op" : "u", "ns" : "testdb.neutrino", "o" : { "$v" : 2, "diff" : { "u" : { "ssn" : "555-66-7878" } } }, "o2" : { "_id" : 101 } }`,
+		`{ "op" : "u", "ns" : "testdb.neutrino", "o" : { "_id": 101, "ssn" : "555-66-7878" }, "o2" : { "_id" : 101 } }`}
+	for _, doc := range docs {
+		var oplog Oplog
+		err := bson.UnmarshalExtJSON([]byte(doc), false, &oplog)
+		assertEqual(t, nil, err)
+		wmodels := GetWriteModels(oplog)
+		assertEqual(t, 1, len(wmodels))
+		data, err := bson.MarshalExtJSON(wmodels[0].WriteModel, false, false)
+		assertEqual(t, nil, err)
+		if strings.Contains(string(data), "555-66-7878") {
+			t.Fatalf("ssn not masked: %v", string(data))
+		}
+	}
+}
+
+func TestGetWriteModelRuns(t *testing.T) {
+	wmodels := []OplogWriteModel{}
+	for _, ns := range []string{"db.a", "db.a", "db.b", "db.a", "db.c", "db.b"} {
+		wmodels = append(wmodels, OplogWriteModel{Namespace: ns, Operation: "i"})
+	}
+	runs := GetWriteModelRuns(wmodels, ApplyOrdered)
+	assertEqual(t, 5, len(runs))
+	assertEqual(t, 2, len(runs[0]))
+	assertEqual(t, "db.b", runs[4][0].Namespace)
+	for _, mode := range []string{ApplyNamespace, ApplyParallel} {
+		runs = GetWriteModelRuns(wmodels, mode)
+		assertEqual(t, 3, len(runs))
+		assertEqual(t, 3, len(runs[0]))
+		assertEqual(t, "db.b", runs[1][0].Namespace)
+		assertEqual(t, "db.c", runs[2][0].Namespace)
+	}
+}
+
+func TestBulkWriteOplogsFilter(t *testing.T) {
+	inst, err := NewMigratorInstance("testdata/data-only.json")
+	assertEqual(t, nil, err)
+	ctx := context.Background()
+	dbName, collName := mdb.SplitNamespace("keyhole.vehicles")
+	source, err := GetMongoClient(TestSourceURI)
+	assertEqual(t, nil, err)
+	src := source.Database(dbName).Collection(collName)
+	src.Drop(ctx)
+	target, err := GetMongoClient(inst.Target)
+	assertEqual(t, nil, err)
+	tgt := target.Database(dbName).Collection(collName)
+	tgt.Drop(ctx)
+
+	_, err = src.InsertOne(ctx, bson.D{{"_id", 1}, {"color", "Red"}})
+	assertEqual(t, nil, err)
+	oplogs := []Oplog{{Namespace: "keyhole.vehicles", Operation: "i", Object: bson.D{{"_id", 1}, {"color", "Red"}}}}
+	_, err = BulkWriteOplogs(oplogs)
+	assertEqual(t, nil, err)
+	count, _ := tgt.CountDocuments(ctx, bson.D{})
+	assertEqual(t, int64(0), count)
+
+	_, err = src.UpdateOne(ctx, bson.D{{"_id", 1}}, bson.D{{"$set", bson.D{{"color", "Black"}}}})
+	assertEqual(t, nil, err)
+	oplogs = []Oplog{{Namespace: "keyhole.vehicles", Operation: "u", Query: bson.D{{"_id", 1}},
+		Object: bson.D{{"$v", 2}, {"diff", bson.D{{"u", bson.D{{"color", "Black"}}}}}}}}
+	_, err = BulkWriteOplogs(oplogs)
+	assertEqual(t, nil, err)
+	count, _ = tgt.CountDocuments(ctx, bson.D{})
+	assertEqual(t, int64(1), count)
+
+	_, err = src.UpdateOne(ctx, bson.D{{"_id", 1}}, bson.D{{"$set", bson.D{{"color", "Red"}}}})
+	assertEqual(t, nil, err)
+	oplogs[0].Object = bson.D{{"$v", 2}, {"diff", bson.D{{"u", bson.D{{"color", "Red"}}}}}}
+	_, err = BulkWriteOplogs(oplogs)
+	assertEqual(t, nil, err)
+	count, _ = tgt.CountDocuments(ctx, bson.D{})
+	assertEqual(t, int64(0), count)
+}
+
+func TestGetFilteredWriteModels(t *testing.T) {
+	migratorInstance = &Migrator{included: map[string]*Include{}}
+	var filter bson.D
+	err := bson.UnmarshalExtJSON([]byte(`{ "color": { "$in": [ "Black", "White" ] } }`), false, &filter)
+	assertEqual(t, nil, err)
+	migratorInstance.included["keyhole.vehicles"] = &Include{Namespace: "keyhole.vehicles", Filter: filter}
+
+	oplog := Oplog{Namespace: "keyhole.vehicles", Operation: "i", Object: bson.D{{"_id", 1}, {"color", "Red"}}}
+	assertEqual(t, 0, len(GetWriteModels(oplog)))
+	oplog.Object = bson.D{{"_id", 1}, {"color", "Black"}}
+	wmodels := GetWriteModels(oplog)
+	assertEqual(t, 1, len(wmodels))
+	assertEqual(t, "u", wmodels[0].Operation)
+	assertEqual(t, false, wmodels[0].IsLookup)
+
+	oplog = Oplog{Namespace: "keyhole.vehicles", Operation: "u", Query: bson.D{{"_id", 1}},
+		Object: bson.D{{"_id", 1}, {"color", "Red"}}} // replaced
+	wmodels = GetWriteModels(oplog)
+	assertEqual(t, 1, len(wmodels))
+	assertEqual(t, "d", wmodels[0].Operation)
+
+	oplog.Object = bson.D{{"$v", 2}, {"diff", bson.D{{"u", bson.D{{"color", "Black"}}}}}}
+	wmodels = GetWriteModels(oplog)
+	assertEqual(t, 1, len(wmodels))
+	assertEqual(t, true, wmodels[0].IsLookup)
+	assertEqual(t, 1, wmodels[0].ID)
+	assertEqual(t, "keyhole.vehicles", wmodels[0].Source)
+}
+
+func TestGetWriteModelsLookupTransforms(t *testing.T) {
+	var include Include
+	str := `{ "namespace": "testdb.neutrino",
+		"transforms": [ { "$set": { "name": { "$concat": ["$first", " ", "$last"] } } } ] }`
+	err := bson.UnmarshalExtJSON([]byte(str), false, &include)
+	assertEqual(t, nil, err)
+	migratorInstance = &Migrator{included: map[string]*Include{}}
+	migratorInstance.included[TestNS] = &include
+
+	oplog := Oplog{Namespace: TestNS, Operation: "i", Object: bson.D{{"_id", 1}, {"first", "Ken"}, {"last", "Chen"}}}
+	wmodels := GetWriteModels(oplog)
+	assertEqual(t, 1, len(wmodels))
+	assertEqual(t, false, wmodels[0].IsLookup)
+
+	oplog = Oplog{Namespace: TestNS, Operation: "u", Query: bson.D{{"_id", 1}},
+		Object: bson.D{{"$v", 2}, {"diff", bson.D{{"u", bson.D{{"last", "Lee"}}}}}}}
+	wmodels = GetWriteModels(oplog)
+	assertEqual(t, 1, len(wmodels))
+	assertEqual(t, true, wmodels[0].IsLookup)
+	assertEqual(t, 1, wmodels[0].ID)
+	assertEqual(t, TestNS, wmodels[0].Source)
+}
@@ -0,0 +1,239 @@
+// Copyright Kuei-chun Chen, 2022-present. All rights reserved.
+
+package hummingbird
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/simagix/gox"
+	"github.com/simagix/keyhole/mdb"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// CopyRatePerWorker is an estimated copy rate of a worker in bytes per second
+	CopyRatePerWorker = 8 * mb
+	// OplogWindowCheckInterval is the interval of checking positions against oplog windows
+	OplogWindowCheckInterval = time.Minute
+	// OplogWindowMargin is the ratio of an oplog window required over the estimated copy duration
+	OplogWindowMargin = 2
+)
+
+const (
+	// WindowLost means the position fell off the oplog tail
+	WindowLost = "lost"
+	// WindowOK means the position is safely within the oplog window
+	WindowOK = "ok"
+	// WindowWarning means the position is close to the oplog tail
+	WindowWarning = "warning"
+)
+
+// OplogWindow stores the oplog size and the first and last oplog timestamps of a replica set
+type OplogWindow struct {
+	First   primitive.Timestamp
+	Last    primitive.Timestamp
+	MaxSize int64
+	SetName string
+	Size    int64
+}
+
+// OplogWindowStatus stores the headroom of a stream position in its oplog window
+type OplogWindowStatus struct {
+	Headroom time.Duration
+	Status   string
+	Window   time.Duration
+}
+
+// GetOplogWindow returns oplog window of a replica set
+func GetOplogWindow(client *mongo.Client, setName string) (*OplogWindow, error) {
+	ctx := context.Background()
+	var stats struct {
+		MaxSize int64 `bson:"maxSize"`
+		Size    int64 `bson:"size"`
+	}
+	if err := client.Database("local").RunCommand(ctx, bson.D{{"collStats", "oplog.rs"}}).Decode(&stats); err != nil {
+		return nil, fmt.Errorf("collStats failed: %v", err)
+	}
+	window := OplogWindow{MaxSize: stats.MaxSize, SetName: setName, Size: stats.Size}
+	coll := client.Database("local").Collection("oplog.rs")
+	for _, order := range []int{1, -1} {
+		var oplog Oplog
+		opts := options.FindOne().SetSort(bson.D{{"$natural", order}}).SetProjection(bson.D{{"ts", 1}})
+		if err := coll.FindOne(ctx, bson.D{}, opts).Decode(&oplog); err != nil {
+			return nil, fmt.Errorf("find oplog failed: %v", err)
+		}
+		if order == 1 {
+			window.First = oplog.Timestamp
+		} else {
+			window.Last = oplog.Timestamp
+		}
+	}
+	return &window, nil
+}
+
+// Duration returns time span between the first and the last oplogs
+func (w *OplogWindow) Duration() time.Duration {
+	return time.Duration(int64(w.Last.T)-int64(w.First.T)) * time.Second
+}
+
+// GetProjectedDuration returns the duration of the oplog window once the oplog is full, projected by
+// the ratio of the max size to the current size if not yet full
+func (w *OplogWindow) GetProjectedDuration() time.Duration {
+	if w.Size <= 0 || w.MaxSize <= w.Size {
+		return w.Duration()
+	}
+	return time.Duration(float64(w.Duration()) * float64(w.MaxSize) / float64(w.Size)).Truncate(time.Second)
+}
+
+// GetStatus returns headroom of a position before it falls off the oplog tail
+func (w *OplogWindow) GetStatus(ts primitive.Timestamp) OplogWindowStatus {
+	status := OplogWindowStatus{Window: w.Duration()}
+	status.Headroom = time.Duration(int64(ts.T)-int64(w.First.T)) * time.Second
+	if primitive.CompareTimestamp(ts, w.First) < 0 {
+		status.Status = WindowLost
+	} else if status.Headroom < status.Window/10 {
+		status.Status = WindowWarning
+	} else {
+		status.Status = WindowOK
+	}
+	return status
+}
+
+// EstimateCopyDuration returns an estimated duration of copying data of qualified collections
+func EstimateCopyDuration(databases []mdb.Database, workers int) time.Duration {
+	inst := GetMigratorInstance()
+	if workers < 1 {
+		workers = 1
+	}
+	var size int64
+	for _, database := range databases {
+		for _, collection := range database.Collections {
+			ns := database.Name + "." + collection.Name
+			if inst != nil && inst.SkipNamespace(ns) {
+				continue
+			}
+			size += collection.Stats.Size
+		}
+	}
+	return time.Duration(size/int64(CopyRatePerWorker*workers)) * time.Second
+}
+
+// CheckOplogWindows compares oplog windows, projected if oplogs are not full, of all replica sets against the
+// estimated copy duration, it returns an error if a window is shorter than the estimate unless "yes" is set,
+// and warns if it is within the margin
+func CheckOplogWindows() error {
+	inst := GetMigratorInstance()
+	logger := gox.GetLogger("CheckOplogWindows")
+	if inst.Stream == StreamChangeStream {
+		logger.Info("oplog window check is skipped with change streams")
+		return nil
+	}
+	client, err := GetMongoClient(inst.Source)
+	if err != nil {
+		return fmt.Errorf("GetMongoClient failed: %v", err)
+	}
+	if len(inst.SourceStats().Databases) == 0 {
+		dbNames, err := GetQualifiedDBs(client, MetaDBName)
+		if err != nil {
+			return fmt.Errorf("GetQualifiedDBs failed: %v", err)
+		}
+		stats := mdb.NewDatabaseStats(inst.SourceStats().Version)
+		stats.SetFastMode(true)
+		if inst.SourceStats().Databases, err = stats.GetAllDatabasesStats(client, dbNames); err != nil {
+			return fmt.Errorf("GetAllDatabasesStats failed: %v", err)
+		}
+	}
+	estimate := EstimateCopyDuration(inst.SourceStats().Databases, inst.Workers)
+	for setName, replica := range inst.Replicas() {
+		if client, err = GetMongoClient(replica); err != nil {
+			return fmt.Errorf("GetMongoClient failed: %v", err)
+		}
+		window, err := GetOplogWindow(client, setName)
+		if err != nil {
+			return fmt.Errorf("%v GetOplogWindow failed: %v", setName, err)
+		}
+		duration := window.GetProjectedDuration()
+		logger.Infof("%v oplog window %v, projected %v (%v/%v MB), estimated copy duration %v", setName,
+			window.Duration(), duration, window.Size/mb, window.MaxSize/mb, estimate)
+		if duration < estimate && !inst.Yes {
+			return fmt.Errorf(`%v oplog window %v is shorter than estimated copy duration %v, increase the oplog size or set "yes" to continue`,
+				setName, duration, estimate)
+		} else if duration < OplogWindowMargin*estimate {
+			logger.Warnf("%v oplog window %v is less than %vx of estimated copy duration %v", setName,
+				duration, OplogWindowMargin, estimate)
+		}
+	}
+	return nil
+}
+
+// CheckResumePositions returns an error if a saved stream position fell off its oplog tail
+func CheckResumePositions() error {
+	inst := GetMigratorInstance()
+	if inst.Stream == StreamChangeStream {
+		return nil
+	}
+	ws := inst.Workspace()
+	for setName, replica := range inst.Replicas() {
+		ts := ws.GetReadTimestamp(setName)
+		if ts == nil {
+			continue
+		}
+		client, err := GetMongoClient(replica)
+		if err != nil {
+			return fmt.Errorf("GetMongoClient failed: %v", err)
+		}
+		window, err := GetOplogWindow(client, setName)
+		if err != nil {
+			return fmt.Errorf("%v GetOplogWindow failed: %v", setName, err)
+		}
+		if window.GetStatus(*ts).Status == WindowLost {
+			return fmt.Errorf("%v position %v fell off the oplog tail %v, restart the migration", setName,
+				time.Unix(int64(ts.T), 0).Format(time.RFC3339), time.Unix(int64(window.First.T), 0).Format(time.RFC3339))
+		}
+	}
+	return nil
+}
+
+// MonitorOplogWindow periodically checks the saved position of a streamer against its oplog window until
+// the context is canceled
+func (p *OplogStreamer) MonitorOplogWindow(ctx context.Context) {
+	logger := gox.GetLogger("MonitorOplogWindow")
+	ws := GetMigratorInstance().Workspace()
+	for sleepWithContext(ctx, OplogWindowCheckInterval) {
+		ts := ws.GetReadTimestamp(p.SetName)
+		if ts == nil {
+			continue
+		}
+		client, err := GetMongoClient(p.URI)
+		if err != nil {
+			logger.Warnf("GetMongoClient failed: %v", err)
+			continue
+		}
+		window, err := GetOplogWindow(client, p.SetName)
+		if err != nil {
+			logger.Warnf("%v GetOplogWindow failed: %v", p.SetName, err)
+			continue
+		}
+		status := window.GetStatus(*ts)
+		if status.Status == WindowLost {
+			logger.Errorf("%v position fell off the oplog tail, oplog window %v", p.SetName, status.Window)
+		} else if status.Status == WindowWarning {
+			logger.Warnf("%v position is %v from the oplog tail, oplog window %v", p.SetName, status.Headroom, status.Window)
+		}
+		p.mutex.Lock()
+		p.window = &status
+		p.mutex.Unlock()
+	}
+}
+
+// GetOplogWindowStatus returns the last oplog window status, nil if not checked
+func (p *OplogStreamer) GetOplogWindowStatus() *OplogWindowStatus {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.window
+}
@@ -0,0 +1,47 @@
+// Copyright Kuei-chun Chen, 2022-present. All rights reserved.
+
+package hummingbird
+
+import (
+	"testing"
+	"time"
+
+	"github.com/simagix/keyhole/mdb"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestOplogWindowGetStatus(t *testing.T) {
+	window := OplogWindow{First: primitive.Timestamp{T: 1000}, Last: primitive.Timestamp{T: 4600}}
+	assertEqual(t, time.Hour, window.Duration())
+
+	status := window.GetStatus(primitive.Timestamp{T: 2800})
+	assertEqual(t, WindowOK, status.Status)
+	assertEqual(t, 30*time.Minute, status.Headroom)
+
+	status = window.GetStatus(primitive.Timestamp{T: 1060})
+	assertEqual(t, WindowWarning, status.Status)
+
+	status = window.GetStatus(primitive.Timestamp{T: 999})
+	assertEqual(t, WindowLost, status.Status)
+}
+
+func TestOplogWindowGetProjectedDuration(t *testing.T) {
+	window := OplogWindow{First: primitive.Timestamp{T: 1000}, Last: primitive.Timestamp{T: 4600},
+		MaxSize: 1024, Size: 256}
+	assertEqual(t, 4*time.Hour, window.GetProjectedDuration())
+
+	window.Size = 1024
+	assertEqual(t, time.Hour, window.GetProjectedDuration())
+	window.Size = 0
+	assertEqual(t, time.Hour, window.GetProjectedDuration())
+}
+
+func TestEstimateCopyDuration(t *testing.T) {
+	migratorInstance = &Migrator{included: map[string]*Include{}}
+	migratorInstance.included["testdb.neutrino"] = &Include{Namespace: "testdb.neutrino"}
+	database := mdb.Database{Name: "testdb", Collections: []mdb.Collection{{Name: "neutrino"}, {Name: "skipped"}}}
+	database.Collections[0].Stats.Size = 160 * mb
+	database.Collections[1].Stats.Size = 1600 * mb
+	assertEqual(t, 10*time.Second, EstimateCopyDuration([]mdb.Database{database}, 2))
+	assertEqual(t, 20*time.Second, EstimateCopyDuration([]mdb.Database{database}, 0))
+}
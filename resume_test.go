@@ -2,13 +2,16 @@
 
 package hummingbird
 
-import "testing"
+import (
+	"context"
+	"testing"
+)
 
 func TestResume(t *testing.T) {
 	filename := "testdata/quickstart.json"
-	err := Resume("none-exists")
+	err := Resume(context.Background(), "none-exists")
 	assertNotEqual(t, nil, err)
 
-	err = Resume(filename, true)
+	err = Resume(context.Background(), filename, true)
 	assertEqual(t, nil, err)
 }
@@ -0,0 +1,119 @@
+// Copyright Kuei-chun Chen, 2022-present. All rights reserved.
+
+package hummingbird
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/simagix/gox"
+)
+
+const (
+	// ShutdownTimeout is the max time waiting for workers and oplog streamers to save states, it is within
+	// terminationGracePeriodSeconds of the k8s manifests
+	ShutdownTimeout = 50 * time.Second
+	// SpoolTimeout is the max time waiting for the spool quota while shutting down, spooled files are not
+	// applied then
+	SpoolTimeout = 10 * time.Second
+)
+
+// NotifyShutdown returns a context canceled on SIGINT or SIGTERM, a second signal terminates immediately
+func NotifyShutdown(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-sigs:
+			gox.GetLogger("NotifyShutdown").Remarkf("received %v, shutting down", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(sigs)
+	}()
+	return ctx, cancel
+}
+
+// SetCancel sets the function canceling the migration once a worker or an oplog streamer fails
+func (inst *Migrator) SetCancel(cancel context.CancelFunc) {
+	inst.mutex.Lock()
+	defer inst.mutex.Unlock()
+	inst.cancel = cancel
+}
+
+// Go runs a worker or an oplog streamer in a goroutine, WaitForShutdown waits for it to save states.  An
+// error cancels the migration, so that others save states, and is returned by WaitForShutdown.
+func (inst *Migrator) Go(fn func() error) {
+	inst.running.Add(1)
+	go func() {
+		defer inst.running.Done()
+		if err := fn(); err != nil {
+			inst.fail(err)
+		}
+	}()
+}
+
+// fail records the first error and cancels the migration
+func (inst *Migrator) fail(err error) {
+	gox.GetLogger("Migrator").Errorf("stop migration: %v", err)
+	inst.mutex.Lock()
+	defer inst.mutex.Unlock()
+	if inst.failure == nil {
+		inst.failure = err
+	}
+	if inst.cancel != nil {
+		inst.cancel()
+	}
+}
+
+// Failure returns the first error of workers and oplog streamers, nil if none
+func (inst *Migrator) Failure() error {
+	inst.mutex.Lock()
+	defer inst.mutex.Unlock()
+	return inst.failure
+}
+
+// WaitForShutdown waits for workers and oplog streamers to exit if the context is canceled, it returns
+// the first error of them if any
+func (inst *Migrator) WaitForShutdown(ctx context.Context) error {
+	if ctx.Err() == nil {
+		return inst.Failure()
+	}
+	logger := gox.GetLogger("WaitForShutdown")
+	done := make(chan struct{})
+	go func() {
+		inst.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		for _, streamer := range inst.Streamers() {
+			if err := streamer.Close(); err != nil {
+				logger.Errorf("%v Close failed: %v", streamer.SetName, err)
+			}
+		}
+		if err := inst.Failure(); err != nil {
+			logger.Remark("states saved after a failure")
+			return err
+		}
+		logger.Remark("shut down gracefully")
+		return nil
+	case <-time.After(ShutdownTimeout):
+		return fmt.Errorf("shutdown timed out after %v", ShutdownTimeout)
+	}
+}
+
+// sleepWithContext sleeps for a duration and returns false if the context is canceled
+func sleepWithContext(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright Kuei-chun Chen, 2022-present. All rights reserved.
+
+package hummingbird
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWaitForShutdown(t *testing.T) {
+	inst := &Migrator{}
+	ctx, cancel := context.WithCancel(context.Background())
+	err := inst.WaitForShutdown(ctx)
+	assertEqual(t, nil, err)
+
+	saved := false
+	inst.Go(func() error {
+		<-ctx.Done()
+		saved = true
+		return nil
+	})
+	cancel()
+	err = inst.WaitForShutdown(ctx)
+	assertEqual(t, nil, err)
+	assertEqual(t, true, saved)
+}
+
+func TestGoFailure(t *testing.T) {
+	inst := &Migrator{}
+	ctx, cancel := context.WithCancel(context.Background())
+	inst.SetCancel(cancel)
+	saved := false
+	inst.Go(func() error {
+		<-ctx.Done()
+		saved = true
+		return nil
+	})
+	inst.Go(func() error {
+		return errors.New("conflicts found")
+	})
+	<-ctx.Done()
+	err := inst.WaitForShutdown(ctx)
+	assertEqual(t, "conflicts found", err.Error())
+	assertEqual(t, true, saved)
+}
+
+func TestSleepWithContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	assertEqual(t, true, sleepWithContext(ctx, time.Millisecond))
+	cancel()
+	assertEqual(t, false, sleepWithContext(ctx, time.Minute))
+}
@@ -0,0 +1,295 @@
+// Copyright Kuei-chun Chen, 2022-present. All rights reserved.
+
+package hummingbird
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/simagix/gox"
+	"github.com/simagix/keyhole/mdb"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// SplitAuto gets _id boundaries from the server and scans all _id if none is available
+	SplitAuto = "auto"
+	// SplitScan scans all _id of collections
+	SplitScan = "scan"
+	// SplitSampleRatio is the number of sampled _id of a range by $sample and $bucketAuto
+	SplitSampleRatio = 20
+	// SplitSampleSize is the number of sampled documents to estimate the average document size
+	SplitSampleSize = 100
+)
+
+const (
+	// BoundsBucketAuto gets boundaries by $sample and $bucketAuto
+	BoundsBucketAuto = "$bucketAuto"
+	// BoundsChunks gets boundaries from config.chunks of a sharded source
+	BoundsChunks = "config.chunks"
+	// BoundsSplitVector gets boundaries by the splitVector command
+	BoundsSplitVector = "splitVector"
+)
+
+// CollectionStats stores the count and the average document size of a collection
+type CollectionStats struct {
+	AvgObjSize int64 `bson:"avgObjSize,truncate"`
+	Count      int64 `bson:"count,truncate"`
+}
+
+// getCollectionStats returns stats of a collection, the average document size is of sampled documents if
+// not available from collStats
+func getCollectionStats(ctx context.Context, client *mongo.Client, ns string) (CollectionStats, error) {
+	var stats CollectionStats
+	dbName, collName := mdb.SplitNamespace(ns)
+	db := client.Database(dbName)
+	if err := db.RunCommand(ctx, bson.D{{"collStats", collName}}).Decode(&stats); err != nil {
+		return stats, fmt.Errorf("collStats failed: %v", err)
+	}
+	if stats.AvgObjSize > 0 || stats.Count == 0 {
+		return stats, nil
+	}
+	pipeline := mongo.Pipeline{{{"$sample", bson.D{{"size", SplitSampleSize}}}},
+		{{"$group", bson.D{{"_id", nil}, {"avg", bson.D{{"$avg", bson.D{{"$bsonSize", "$$ROOT"}}}}}}}}}
+	cursor, err := db.Collection(collName).Aggregate(ctx, pipeline)
+	if err != nil {
+		return stats, fmt.Errorf("Aggregate failed: %v", err)
+	}
+	defer cursor.Close(ctx)
+	if cursor.Next(ctx) {
+		var doc struct {
+			Avg float64 `bson:"avg"`
+		}
+		if err = cursor.Decode(&doc); err != nil {
+			return stats, fmt.Errorf("Decode failed: %v", err)
+		}
+		stats.AvgObjSize = int64(doc.Avg)
+	}
+	return stats, cursor.Err()
+}
+
+// GetBlock returns the number of documents of a task, of about block_size MB if given and the average
+// document size is known, otherwise block
+func (inst *Migrator) GetBlock(avgObjSize int64) int {
+	if inst.BlockSize <= 0 || avgObjSize <= 0 {
+		return inst.Block
+	}
+	block := int64(inst.BlockSize) * mb / avgObjSize
+	if block < 1 {
+		return 1
+	}
+	return int(block)
+}
+
+// splitTaskByBounds splits a collection into _id ranges of boundaries from the server, it returns false
+// if the collection is to be scanned, i.e. small, filtered, of mixed _id types, or no boundaries available
+func splitTaskByBounds(ctx context.Context, client *mongo.Client, task *Task, stats CollectionStats,
+	block int) (bool, error) {
+	inst := GetMigratorInstance()
+	logger := gox.GetLogger("splitTaskByBounds")
+	if inst.Split == SplitScan || len(task.Include.Filter) > 0 || stats.AvgObjSize <= 0 {
+		return false, nil
+	}
+	if stats.Count <= int64(block) {
+		return false, nil
+	}
+	dbName, collName := mdb.SplitNamespace(task.Namespace)
+	coll := client.Database(dbName).Collection(collName)
+	first, err := getEndID(ctx, coll, 1)
+	if err != nil {
+		return false, err
+	}
+	last, err := getEndID(ctx, coll, -1)
+	if err != nil {
+		return false, err
+	}
+	if getTypeBracket(first) != getTypeBracket(last) { // ranges of mixed types are split by scanning
+		logger.Infof("%v has _id of mixed types, scan all _id", task.Namespace)
+		return false, nil
+	}
+	method := BoundsSplitVector
+	points, err := getSplitVector(ctx, client, task.Namespace, stats.AvgObjSize*int64(block))
+	if err != nil && inst.SourceStats() != nil && inst.SourceStats().Cluster == mdb.Sharded {
+		logger.Debugf("%v splitVector failed: %v", task.Namespace, err)
+		method = BoundsChunks
+		points, err = getChunkBounds(ctx, task.Namespace, task.SetName)
+	}
+	if err != nil {
+		logger.Debugf("%v %v failed: %v", task.Namespace, method, err)
+		method = BoundsBucketAuto
+		buckets := (stats.Count + int64(block) - 1) / int64(block)
+		if points, err = getBucketAutoBounds(ctx, coll, buckets); err != nil {
+			logger.Warnf("%v %v failed, scan all _id: %v", task.Namespace, method, err)
+			return false, nil
+		}
+	}
+	bracket := getTypeBracket(first)
+	ids := []interface{}{getIDValue(first)}
+	for _, point := range points { // ascending, MinKey and MaxKey of chunks are dropped
+		if getTypeBracket(point) == bracket {
+			ids = append(ids, getIDValue(point))
+		}
+	}
+	ids = append(ids, getIDValue(last))
+
+	task.BeginTime = time.Now()
+	task.Status = TaskSplitting
+	ws := inst.Workspace()
+	ws.UpdateTask(task)
+	parentID := task.ID
+	n := len(ids) - 1
+	estimate := int((stats.Count + int64(n) - 1) / int64(n))
+	subTasks := []*Task{}
+	for i := 0; i < n; i++ {
+		subTasks = append(subTasks, &Task{ID: primitive.NewObjectID(), Bytes: int64(estimate) * stats.AvgObjSize,
+			IDs: []interface{}{ids[i], ids[i+1]}, Exclusive: i < n-1, Namespace: task.Namespace, ParentID: &parentID, SetName: task.SetName,
+			Status: TaskAdded, Include: task.Include, SourceCounts: estimate, UpdatedBy: "splitter"})
+	}
+	if err = ws.InsertTasks(subTasks); err != nil {
+		if rerr := ws.ResetParentTask(*task); rerr != nil {
+			return false, fmt.Errorf("ResetParentTask failed: %v", rerr)
+		}
+		return false, fmt.Errorf("InsertTasks failed: %v", err)
+	}
+	task.EndTime = time.Now()
+	task.Status = TaskCompleted
+	task.SourceCounts = int(stats.Count)
+	ws.UpdateTask(task)
+	logger.Infof("%v split into %v ranges by %v, took %v", task.Namespace, n, method,
+		task.EndTime.Sub(task.BeginTime))
+	return true, nil
+}
+
+// getEndID returns the smallest, or the largest if order is -1, _id of a collection
+func getEndID(ctx context.Context, coll *mongo.Collection, order int) (bson.RawValue, error) {
+	opts := options.FindOne()
+	opts.SetProjection(bson.D{{"_id", 1}})
+	opts.SetSort(bson.D{{"_id", order}})
+	doc, err := coll.FindOne(ctx, bson.D{}, opts).DecodeBytes()
+	if err != nil {
+		return bson.RawValue{}, fmt.Errorf("FindOne failed: %v", err)
+	}
+	return doc.Lookup("_id"), nil
+}
+
+// getSplitVector returns _id boundaries of ranges of a max size by the splitVector command
+func getSplitVector(ctx context.Context, client *mongo.Client, ns string, maxSize int64) ([]bson.RawValue, error) {
+	dbName, _ := mdb.SplitNamespace(ns)
+	cmd := bson.D{{"splitVector", ns}, {"keyPattern", bson.D{{"_id", 1}}}, {"maxChunkSizeBytes", maxSize}}
+	doc, err := client.Database(dbName).RunCommand(ctx, cmd).DecodeBytes()
+	if err != nil {
+		return nil, err
+	}
+	return getBoundIDs(doc.Lookup("splitKeys"))
+}
+
+// getChunkBounds returns the min _id of chunks of a shard of a replica set, the collection must be
+// sharded on {_id: 1}
+func getChunkBounds(ctx context.Context, ns string, setName string) ([]bson.RawValue, error) {
+	inst := GetMigratorInstance()
+	client, err := GetMongoClient(inst.Source)
+	if err != nil {
+		return nil, fmt.Errorf("GetMongoClient failed: %v", err)
+	}
+	config := client.Database("config")
+	var coll struct {
+		Key  bson.D      `bson:"key"`
+		UUID interface{} `bson:"uuid"`
+	}
+	if err = config.Collection("collections").FindOne(ctx, bson.D{{"_id", ns}}).Decode(&coll); err != nil {
+		return nil, fmt.Errorf("%v is not sharded: %v", ns, err)
+	}
+	if len(coll.Key) != 1 || coll.Key[0].Key != "_id" || fmt.Sprintf("%v", coll.Key[0].Value) != "1" {
+		return nil, fmt.Errorf("%v is sharded on %v", ns, coll.Key)
+	}
+	shard, err := getShardID(ctx, config, setName)
+	if err != nil {
+		return nil, err
+	}
+	filter := bson.D{{"ns", ns}, {"shard", shard}}
+	if coll.UUID != nil { // chunks of 5.0 and later are keyed by uuid
+		filter = bson.D{{"$or", bson.A{bson.D{{"ns", ns}}, bson.D{{"uuid", coll.UUID}}}}, {"shard", shard}}
+	}
+	opts := options.Find()
+	opts.SetProjection(bson.D{{"min", 1}})
+	opts.SetSort(bson.D{{"min", 1}})
+	cursor, err := config.Collection("chunks").Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("Find failed: %v", err)
+	}
+	defer cursor.Close(ctx)
+	points := []bson.RawValue{}
+	for cursor.Next(ctx) {
+		value := cursor.Current.Lookup("min", "_id")
+		value.Value = append([]byte{}, value.Value...)
+		points = append(points, value)
+	}
+	if err = cursor.Err(); err != nil {
+		return nil, err
+	} else if len(points) == 0 {
+		return nil, fmt.Errorf("no chunks of %v found on %v", ns, shard)
+	}
+	return points, nil
+}
+
+// getBucketAutoBounds returns _id boundaries of buckets of sampled _id
+func getBucketAutoBounds(ctx context.Context, coll *mongo.Collection, buckets int64) ([]bson.RawValue, error) {
+	pipeline := mongo.Pipeline{{{"$sample", bson.D{{"size", buckets * SplitSampleRatio}}}},
+		{{"$bucketAuto", bson.D{{"groupBy", "$_id"}, {"buckets", buckets}}}}}
+	cursor, err := coll.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	points := []bson.RawValue{}
+	for cursor.Next(ctx) {
+		value := cursor.Current.Lookup("_id", "min")
+		value.Value = append([]byte{}, value.Value...)
+		points = append(points, value)
+	}
+	if err = cursor.Err(); err != nil {
+		return nil, err
+	} else if len(points) == 0 {
+		return nil, fmt.Errorf("no buckets")
+	}
+	return points[1:], nil // the first bucket begins at the smallest sampled _id
+}
+
+// getBoundIDs returns _id values of an array of documents of split keys
+func getBoundIDs(value bson.RawValue) ([]bson.RawValue, error) {
+	arr, ok := value.ArrayOK()
+	if !ok {
+		return nil, fmt.Errorf("no split keys found")
+	}
+	values, err := arr.Values()
+	if err != nil {
+		return nil, err
+	}
+	points := []bson.RawValue{}
+	for _, v := range values {
+		doc, ok := v.DocumentOK()
+		if !ok {
+			return nil, fmt.Errorf("invalid split key %v", v)
+		}
+		points = append(points, doc.Lookup("_id"))
+	}
+	return points, nil
+}
+
+// getShardID returns the _id of a shard of a replica set from config.shards, of which host is
+// setName/host:port[,host:port]
+func getShardID(ctx context.Context, config *mongo.Database, setName string) (string, error) {
+	var shard struct {
+		ID string `bson:"_id"`
+	}
+	filter := bson.D{{"host", primitive.Regex{Pattern: "^" + regexp.QuoteMeta(setName+"/")}}}
+	if err := config.Collection("shards").FindOne(ctx, filter).Decode(&shard); err != nil {
+		return "", fmt.Errorf("shard of %v not found: %v", setName, err)
+	}
+	return shard.ID, nil
+}
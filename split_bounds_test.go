@@ -0,0 +1,126 @@
+// Copyright Kuei-chun Chen, 2022-present. All rights reserved.
+
+package hummingbird
+
+import (
+	"context"
+	"testing"
+
+	"github.com/simagix/keyhole/mdb"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestGetBoundIDs(t *testing.T) {
+	data, err := bson.Marshal(bson.D{{"splitKeys", bson.A{bson.D{{"_id", 100}}, bson.D{{"_id", 200}}}}})
+	assertEqual(t, nil, err)
+	points, err := getBoundIDs(bson.Raw(data).Lookup("splitKeys"))
+	assertEqual(t, nil, err)
+	assertEqual(t, 2, len(points))
+	assertEqual(t, int32(200), getIDValue(points[1]))
+
+	_, err = getBoundIDs(bson.Raw(data).Lookup("missing"))
+	assertNotEqual(t, nil, err)
+}
+
+func TestGetIDQueryExclusive(t *testing.T) {
+	task := &Task{IDs: []interface{}{1, 100}, Exclusive: true}
+	query, err := task.GetIDQuery()
+	assertEqual(t, nil, err)
+	assertEqual(t, "$lt", query[1].Value.(bson.D)[0].Key)
+}
+
+func TestValidateMigratorConfigSplit(t *testing.T) {
+	inst := &Migrator{Command: CommandAll, Source: TestSourceURI, Target: TestTargetURI}
+	err := ValidateMigratorConfig(inst)
+	assertEqual(t, nil, err)
+	assertEqual(t, SplitAuto, inst.Split)
+
+	inst.Split = "unknown"
+	err = ValidateMigratorConfig(inst)
+	assertNotEqual(t, nil, err)
+}
+
+func TestGetBlock(t *testing.T) {
+	inst := &Migrator{Command: CommandAll, Source: TestSourceURI, Target: TestTargetURI}
+	err := ValidateMigratorConfig(inst)
+	assertEqual(t, nil, err)
+	assertEqual(t, 0, inst.BlockSize)
+	assertEqual(t, MaxBlockSize, inst.GetBlock(1024))
+
+	inst = &Migrator{BlockSize: 64, Command: CommandAll, Source: TestSourceURI, Target: TestTargetURI}
+	err = ValidateMigratorConfig(inst)
+	assertEqual(t, nil, err)
+	assertEqual(t, 64*1024, inst.GetBlock(1024))
+	assertEqual(t, 32, inst.GetBlock(2*mb))
+	assertEqual(t, 1, inst.GetBlock(128*mb))
+	assertEqual(t, MaxBlockSize, inst.GetBlock(0))
+
+	inst = &Migrator{Block: 100, Command: CommandAll, Source: TestSourceURI, Target: TestTargetURI}
+	err = ValidateMigratorConfig(inst)
+	assertEqual(t, nil, err)
+	assertEqual(t, 0, inst.BlockSize)
+	assertEqual(t, 100, inst.GetBlock(2*mb))
+
+	inst.BlockSize = -1
+	err = ValidateMigratorConfig(inst)
+	assertNotEqual(t, nil, err)
+}
+
+func TestSplitTaskByBounds(t *testing.T) {
+	ctx := context.Background()
+	dbName, collName := mdb.SplitNamespace(TestNS)
+	inst, err := NewMigratorInstance("testdata/config.json")
+	assertEqual(t, nil, err)
+	ws := inst.Workspace()
+	err = ws.Reset()
+	assertEqual(t, nil, err)
+	inst.Block = 100
+	inst.BlockSize = 0
+
+	source, err := GetMongoClient(TestSourceURI)
+	assertEqual(t, nil, err)
+	src := source.Database(dbName).Collection(collName)
+	src.Drop(ctx)
+	docs := []interface{}{}
+	for i := 0; i < 1000; i++ {
+		docs = append(docs, bson.D{{"_id", i}, {"name", "neutrino"}})
+	}
+	_, err = src.InsertMany(ctx, docs)
+	assertEqual(t, nil, err)
+
+	stats, err := getCollectionStats(ctx, source, TestNS)
+	assertEqual(t, nil, err)
+	assertEqual(t, int64(len(docs)), stats.Count)
+	task := &Task{ID: primitive.NewObjectID(), Namespace: TestNS}
+	ok, err := splitTaskByBounds(ctx, source, task, stats, inst.GetBlock(stats.AvgObjSize))
+	assertEqual(t, nil, err)
+	assertEqual(t, true, ok)
+	assertEqual(t, TaskCompleted, task.Status)
+
+	meta, err := GetMongoClient(inst.Target)
+	assertEqual(t, nil, err)
+	cursor, err := meta.Database(MetaDBName).Collection(MetaTasks).Find(ctx, bson.D{{"parent_id", task.ID}})
+	assertEqual(t, nil, err)
+	var subTasks []*Task
+	err = cursor.All(ctx, &subTasks)
+	assertEqual(t, nil, err)
+	assertNotEqual(t, 0, len(subTasks))
+
+	target, err := GetMongoClient(TestTargetURI)
+	assertEqual(t, nil, err)
+	tgt := target.Database(dbName).Collection(collName)
+	tgt.Drop(ctx)
+	for _, subTask := range subTasks {
+		err = subTask.CopyData(ctx, src, tgt)
+		assertEqual(t, nil, err)
+	}
+	count, err := tgt.CountDocuments(ctx, bson.D{})
+	assertEqual(t, nil, err)
+	assertEqual(t, int64(len(docs)), count)
+
+	inst.Split = SplitScan
+	ok, err = splitTaskByBounds(ctx, source, task, stats, inst.GetBlock(stats.AvgObjSize))
+	assertEqual(t, nil, err)
+	assertEqual(t, false, ok)
+}
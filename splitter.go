@@ -5,10 +5,11 @@ package hummingbird
 import (
 	"context"
 	"fmt"
-	"log"
+	"math"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo/options"
 
@@ -23,13 +24,17 @@ const (
 	NumberSplitters = 4
 )
 
-// Splitter splits collection into small tasks
-func Splitter(tasks []*Task) error {
+// Splitter splits collection into small tasks, tasks not yet split are left as is once the context is
+// canceled
+func Splitter(ctx context.Context, tasks []*Task) error {
 	now := time.Now()
 	logger := gox.GetLogger("Splitter")
 	inst := GetMigratorInstance()
 	wg := gox.NewWaitGroup(NumberSplitters)
 	for _, task := range tasks {
+		if ctx.Err() != nil {
+			break
+		}
 		if task.Status == TaskCompleted {
 			continue
 		}
@@ -40,7 +45,9 @@ func Splitter(tasks []*Task) error {
 		}
 		go func(client *mongo.Client, task *Task) {
 			defer wg.Done()
-			splitTask(client, task)
+			if err := splitTask(ctx, client, task); err != nil {
+				logger.Errorf("%v splitTask failed: %v", task.Namespace, err)
+			}
 		}(client, task)
 	}
 	wg.Wait()
@@ -48,9 +55,23 @@ func Splitter(tasks []*Task) error {
 	return nil
 }
 
-func splitTask(client *mongo.Client, task *Task) error {
+// splitTask splits a collection by _id ranges of boundaries from the server, or by scanning all _id, an
+// interrupted split is reset to be split again
+func splitTask(ctx context.Context, client *mongo.Client, task *Task) error {
+	stats, err := getCollectionStats(ctx, client, task.Namespace)
+	if err != nil { // sized by block
+		gox.GetLogger("splitTask").Warnf("%v getCollectionStats failed: %v", task.Namespace, err)
+	}
+	block := GetMigratorInstance().GetBlock(stats.AvgObjSize)
+	if task.Include.Limit > 0 {
+		return splitSampledTask(ctx, client, task, stats.AvgObjSize, block)
+	}
+	if ok, err := splitTaskByBounds(ctx, client, task, stats, block); err != nil {
+		return fmt.Errorf("splitTaskByBounds failed: %v", err)
+	} else if ok {
+		return nil
+	}
 	inst := GetMigratorInstance()
-	ctx := context.Background()
 	dbName, collName := mdb.SplitNamespace(task.Namespace)
 	opts := options.Find()
 	opts.SetProjection(bson.D{{"_id", 1}})
@@ -61,48 +82,228 @@ func splitTask(client *mongo.Client, task *Task) error {
 	}
 	cursor, err := client.Database(dbName).Collection(collName).Find(ctx, query, opts)
 	if err != nil {
-		log.Fatalf("splitTask Find failed: %v", err)
+		return fmt.Errorf("Find failed: %v", err)
 	}
-	defer cursor.Close(ctx)
+	defer cursor.Close(context.Background())
 	task.BeginTime = time.Now()
 	task.Status = TaskSplitting
 	ws := inst.Workspace()
 	ws.UpdateTask(task)
 	total := 0
 	count := 0
-	var first bson.M
 	parentID := task.ID
-	var last bson.M
+	var first, last bson.RawValue
+	addSubTask := func() {
+		ids := []interface{}{getIDValue(first), getIDValue(last)}
+		subTask := &Task{ID: primitive.NewObjectID(), Bytes: int64(count) * stats.AvgObjSize, IDs: ids,
+			Namespace: task.Namespace, ParentID: &parentID, SetName: task.SetName, Status: TaskAdded,
+			Include: task.Include, SourceCounts: count, UpdatedBy: "splitter"}
+		ws.InsertTasks([]*Task{subTask})
+		count = 0
+	}
 	for cursor.Next(ctx) {
+		value := cursor.Current.Lookup("_id")
+		value.Value = append([]byte{}, value.Value...) // the cursor reuses its buffer
+		// a range stays within a type bracket
+		if count > 0 && getTypeBracket(value) != getTypeBracket(first) {
+			addSubTask()
+		}
 		total++
 		count++
 		if count == 1 {
-			cursor.Decode(&first)
-		} else if count == inst.Block {
-			cursor.Decode(&last)
-			subTask := &Task{ID: primitive.NewObjectID(), IDs: []interface{}{first["_id"], last["_id"]},
-				Namespace: task.Namespace, ParentID: &parentID, SetName: task.SetName, Status: TaskAdded,
-				Include: task.Include, SourceCounts: count, UpdatedBy: "splitter"}
+			first = value
+		}
+		last = value
+		if count == block {
+			addSubTask()
+		}
+	}
+	if err = cursor.Err(); err != nil {
+		if rerr := ws.ResetParentTask(*task); rerr != nil {
+			return fmt.Errorf("ResetParentTask failed: %v", rerr)
+		}
+		return fmt.Errorf("split interrupted: %v", err)
+	}
+	if count > 0 {
+		addSubTask()
+	}
+	task.EndTime = time.Now()
+	task.Status = TaskCompleted
+	task.SourceCounts = total
+	ws.UpdateTask(task)
+	return nil
+}
+
+// getTypeBracket returns the sort order of the BSON type of a value, ranges of $gte and $lte only match
+// values of the same order, i.e. all numeric types are of one. NaN is of its own because it sorts before
+// other numbers but isn't matched by ranges of numbers.
+func getTypeBracket(value bson.RawValue) int {
+	switch value.Type {
+	case bsontype.MinKey:
+		return -1
+	case bsontype.Undefined, bsontype.Null:
+		return 5
+	case bsontype.Double:
+		if math.IsNaN(value.Double()) {
+			return 9
+		}
+		return 10
+	case bsontype.Int32, bsontype.Int64:
+		return 10
+	case bsontype.Decimal128:
+		if value.Decimal128().IsNaN() {
+			return 9
+		}
+		return 10
+	case bsontype.Symbol, bsontype.String:
+		return 15
+	case bsontype.EmbeddedDocument:
+		return 20
+	case bsontype.Array:
+		return 25
+	case bsontype.Binary:
+		return 30
+	case bsontype.ObjectID:
+		return 35
+	case bsontype.Boolean:
+		return 40
+	case bsontype.DateTime:
+		return 45
+	case bsontype.Timestamp:
+		return 47
+	case bsontype.Regex:
+		return 50
+	case bsontype.DBPointer:
+		return 55
+	case bsontype.JavaScript:
+		return 60
+	case bsontype.CodeWithScope:
+		return 65
+	case bsontype.MaxKey:
+		return 127
+	}
+	return 0
+}
+
+// getIDValue returns the value of an _id, embedded documents keep the order of fields
+func getIDValue(value bson.RawValue) interface{} {
+	var id interface{}
+	if err := value.Unmarshal(&id); err != nil {
+		return nil
+	}
+	return id
+}
+
+// splitSampledTask splits a limited collection into tasks of sampled _id
+func splitSampledTask(ctx context.Context, client *mongo.Client, task *Task, avgObjSize int64, block int) error {
+	inst := GetMigratorInstance()
+	dbName, collName := mdb.SplitNamespace(task.Namespace)
+	coll := client.Database(dbName).Collection(collName)
+	query := bson.D{}
+	if len(task.Include.Filter) > 0 {
+		query = task.Include.Filter
+	}
+	limit, err := getReplicaLimit(client, task)
+	if err != nil {
+		return fmt.Errorf("getReplicaLimit failed: %v", err)
+	}
+	task.BeginTime = time.Now()
+	task.Status = TaskSplitting
+	ws := inst.Workspace()
+	ws.UpdateTask(task)
+	var cursor *mongo.Cursor
+	stride := int64(1)
+	if task.Include.Sample == SampleRandom {
+		pipeline := mongo.Pipeline{{{"$match", query}}, {{"$sample", bson.D{{"size", limit}}}},
+			{{"$project", bson.D{{"_id", 1}}}}, {{"$sort", bson.D{{"_id", 1}}}}}
+		cursor, err = coll.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	} else {
+		if task.Include.Sample == SampleStride {
+			var count int64
+			if count, err = coll.CountDocuments(ctx, query); err != nil {
+				return fmt.Errorf("CountDocuments failed: %v", err)
+			}
+			if limit > 0 && count > limit {
+				stride = count / limit
+			}
+		}
+		opts := options.Find()
+		opts.SetProjection(bson.D{{"_id", 1}})
+		opts.SetSort(bson.D{{"_id", 1}})
+		cursor, err = coll.Find(ctx, query, opts)
+	}
+	if err != nil {
+		return fmt.Errorf("splitSampledTask %v failed: %v", task.Namespace, err)
+	}
+	defer cursor.Close(context.Background())
+	parentID := task.ID
+	total := int64(0)
+	scanned := int64(0)
+	ids := []interface{}{}
+	for total < limit && cursor.Next(ctx) {
+		scanned++
+		if (scanned-1)%stride != 0 {
+			continue
+		}
+		var doc bson.D
+		if err = cursor.Decode(&doc); err != nil {
+			return fmt.Errorf("Decode failed: %v", err)
+		}
+		ids = append(ids, doc.Map()["_id"])
+		total++
+		if len(ids) == block {
+			subTask := &Task{ID: primitive.NewObjectID(), Bytes: int64(len(ids)) * avgObjSize, IDs: ids, Namespace: task.Namespace, ParentID: &parentID,
+				Sampled: true, SetName: task.SetName, Status: TaskAdded, Include: task.Include,
+				SourceCounts: len(ids), UpdatedBy: "splitter"}
 			ws.InsertTasks([]*Task{subTask})
-			count = 0
-			first = nil
-			last = nil
-		} else if cursor.RemainingBatchLength() == 0 {
-			cursor.Decode(&last)
+			ids = []interface{}{}
 		}
 	}
-	if first != nil {
-		if count == 1 {
-			last = first
+	if err = cursor.Err(); err != nil {
+		if rerr := ws.ResetParentTask(*task); rerr != nil {
+			return fmt.Errorf("ResetParentTask failed: %v", rerr)
 		}
-		subTask := &Task{ID: primitive.NewObjectID(), IDs: []interface{}{first["_id"], last["_id"]},
-			Namespace: task.Namespace, ParentID: &parentID, SetName: task.SetName, Status: TaskAdded,
-			Include: task.Include, SourceCounts: count, UpdatedBy: "splitter"}
+		return fmt.Errorf("split interrupted: %v", err)
+	}
+	if len(ids) > 0 {
+		subTask := &Task{ID: primitive.NewObjectID(), Bytes: int64(len(ids)) * avgObjSize, IDs: ids, Namespace: task.Namespace, ParentID: &parentID,
+			Sampled: true, SetName: task.SetName, Status: TaskAdded, Include: task.Include,
+			SourceCounts: len(ids), UpdatedBy: "splitter"}
 		ws.InsertTasks([]*Task{subTask})
 	}
 	task.EndTime = time.Now()
 	task.Status = TaskCompleted
-	task.SourceCounts = total
+	task.SourceCounts = int(total)
 	ws.UpdateTask(task)
 	return nil
 }
+
+// getReplicaLimit returns the share of the limit of a shard in proportion to its documents
+func getReplicaLimit(client *mongo.Client, task *Task) (int64, error) {
+	inst := GetMigratorInstance()
+	if len(inst.Replicas()) < 2 {
+		return task.Include.Limit, nil
+	}
+	ctx := context.Background()
+	dbName, collName := mdb.SplitNamespace(task.Namespace)
+	query := bson.D{}
+	if len(task.Include.Filter) > 0 {
+		query = task.Include.Filter
+	}
+	count, err := client.Database(dbName).Collection(collName).CountDocuments(ctx, query)
+	if err != nil {
+		return 0, fmt.Errorf("CountDocuments failed: %v", err)
+	}
+	source, err := GetMongoClient(inst.Source)
+	if err != nil {
+		return 0, fmt.Errorf("GetMongoClient failed: %v", err)
+	}
+	total, err := source.Database(dbName).Collection(collName).CountDocuments(ctx, query)
+	if err != nil {
+		return 0, fmt.Errorf("CountDocuments failed: %v", err)
+	}
+	if total == 0 {
+		return 0, nil
+	}
+	return (task.Include.Limit*count + total - 1) / total, nil
+}
@@ -0,0 +1,88 @@
+// Copyright Kuei-chun Chen, 2022-present. All rights reserved.
+
+package hummingbird
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/simagix/keyhole/mdb"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestGetTypeBracket(t *testing.T) {
+	getBracket := func(v interface{}) int {
+		data, err := bson.Marshal(bson.D{{"_id", v}})
+		assertEqual(t, nil, err)
+		return getTypeBracket(bson.Raw(data).Lookup("_id"))
+	}
+	assertEqual(t, getBracket(int32(1)), getBracket(int64(2)))
+	assertEqual(t, getBracket(1), getBracket(2.5))
+	assertEqual(t, getBracket("a"), getBracket(primitive.Symbol("b")))
+	assertNotEqual(t, getBracket(1), getBracket(math.NaN()))
+	assertNotEqual(t, getBracket(1), getBracket("1"))
+	assertNotEqual(t, getBracket("a"), getBracket(bson.D{{"a", 1}}))
+	assertNotEqual(t, getBracket(primitive.NewObjectID()), getBracket(true))
+	assertNotEqual(t, getBracket(primitive.NewDateTimeFromTime(time.Now())), getBracket(primitive.Timestamp{T: 1}))
+}
+
+func TestGetIDValue(t *testing.T) {
+	data, err := bson.Marshal(bson.D{{"_id", bson.D{{"b", 1}, {"a", 2}}}})
+	assertEqual(t, nil, err)
+	id := getIDValue(bson.Raw(data).Lookup("_id"))
+	doc, ok := id.(bson.D)
+	assertEqual(t, true, ok)
+	assertEqual(t, "b", doc[0].Key)
+}
+
+func TestSplitTaskMixedTypes(t *testing.T) {
+	ctx := context.Background()
+	dbName, collName := mdb.SplitNamespace(TestNS)
+	inst, err := NewMigratorInstance("testdata/config.json")
+	assertEqual(t, nil, err)
+	ws := inst.Workspace()
+	err = ws.Reset()
+	assertEqual(t, nil, err)
+	inst.Block = 3
+	inst.BlockSize = 0
+
+	source, err := GetMongoClient(TestSourceURI)
+	assertEqual(t, nil, err)
+	src := source.Database(dbName).Collection(collName)
+	src.Drop(ctx)
+	docs := []interface{}{bson.D{{"_id", 1}}, bson.D{{"_id", 2.5}}, bson.D{{"_id", int64(3)}},
+		bson.D{{"_id", 4}}, bson.D{{"_id", "a"}}, bson.D{{"_id", "b"}}, bson.D{{"_id", bson.D{{"x", 1}}}},
+		bson.D{{"_id", primitive.NewObjectID()}}, bson.D{{"_id", primitive.NewObjectID()}}, bson.D{{"_id", true}},
+		bson.D{{"_id", primitive.NewDateTimeFromTime(time.Now())}}}
+	_, err = src.InsertMany(ctx, docs)
+	assertEqual(t, nil, err)
+
+	task := &Task{ID: primitive.NewObjectID(), Namespace: TestNS}
+	err = splitTask(ctx, source, task)
+	assertEqual(t, nil, err)
+	assertEqual(t, len(docs), task.SourceCounts)
+
+	meta, err := GetMongoClient(inst.Target)
+	assertEqual(t, nil, err)
+	cursor, err := meta.Database(MetaDBName).Collection(MetaTasks).Find(ctx, bson.D{{"parent_id", task.ID}})
+	assertEqual(t, nil, err)
+	var subTasks []*Task
+	err = cursor.All(ctx, &subTasks)
+	assertEqual(t, nil, err)
+	assertEqual(t, 7, len(subTasks)) // [1, 2.5, 3] [4] [a, b] [{x: 1}] [oid, oid] [true] [date]
+
+	target, err := GetMongoClient(TestTargetURI)
+	assertEqual(t, nil, err)
+	tgt := target.Database(dbName).Collection(collName)
+	tgt.Drop(ctx)
+	for _, subTask := range subTasks {
+		err = subTask.CopyData(ctx, src, tgt)
+		assertEqual(t, nil, err)
+	}
+	count, err := tgt.CountDocuments(ctx, bson.D{})
+	assertEqual(t, nil, err)
+	assertEqual(t, int64(len(docs)), count)
+}
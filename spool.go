@@ -0,0 +1,226 @@
+// Copyright Kuei-chun Chen, 2022-present. All rights reserved.
+
+package hummingbird
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/simagix/gox"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// CompressorGzip compresses spooled files with gzip
+	CompressorGzip = "gzip"
+	// CompressorSnappy compresses spooled files with snappy
+	CompressorSnappy = "snappy"
+	// CompressorZstd compresses spooled files with zstd
+	CompressorZstd = "zstd"
+	// SnappyBSONFileExt is .bson.snappy
+	SnappyBSONFileExt = ".bson.snappy"
+	// SpoolArchive is the directory of archived spooled files under spool
+	SpoolArchive = "archive"
+	// SpoolIndexFile lists spooled files with their first and last timestamps
+	SpoolIndexFile = "index.json"
+	// ZstdBSONFileExt is .bson.zst
+	ZstdBSONFileExt = ".bson.zst"
+)
+
+const (
+	// SpoolApplied means a spooled file was applied and deleted
+	SpoolApplied = "applied"
+	// SpoolArchived means a spooled file was applied and archived
+	SpoolArchived = "archived"
+	// SpoolPending means a spooled file is waiting to be applied
+	SpoolPending = "pending"
+)
+
+// SpoolFile stores an index entry of a spooled file
+type SpoolFile struct {
+	Filename string              `json:"file"`
+	First    primitive.Timestamp `json:"first"`
+	Last     primitive.Timestamp `json:"last"`
+	SetName  string              `json:"setName"`
+	Size     int64               `json:"size"`
+	Status   string              `json:"status"`
+}
+
+// SpoolManager writes compressed oplog files within a quota and deletes or archives them once applied
+type SpoolManager struct {
+	Archive    bool
+	Compressor string
+	Dir        string
+	Quota      int64
+
+	files []SpoolFile
+	mutex sync.Mutex
+}
+
+// NewSpoolManager returns a SpoolManager with the index of a spool directory
+func NewSpoolManager(dir string, compressor string, quota int64, archive bool) *SpoolManager {
+	if compressor == "" {
+		compressor = CompressorGzip
+	}
+	m := &SpoolManager{Archive: archive, Compressor: compressor, Dir: dir, Quota: quota}
+	if data, err := os.ReadFile(filepath.Join(dir, SpoolIndexFile)); err == nil {
+		json.Unmarshal(data, &m.files)
+	}
+	return m
+}
+
+// Write compresses and writes oplogs to a spooled file, it pauses while the quota is reached and returns
+// the context error if canceled while paused
+func (m *SpoolManager) Write(ctx context.Context, setName string, raws []byte, first primitive.Timestamp,
+	last primitive.Timestamp) (string, error) {
+	data, err := CompressBytes(raws, m.Compressor)
+	if err != nil {
+		return "", fmt.Errorf("CompressBytes failed: %v", err)
+	}
+	if err = m.waitForQuota(ctx, setName, int64(len(data))); err != nil {
+		return "", err
+	}
+	filename := filepath.Join(m.Dir, fmt.Sprintf(`%v.%v%v`, setName, GetDateTime(), GetSpoolFileExt(m.Compressor)))
+	if err = os.WriteFile(filename, data, 0644); err != nil {
+		return "", err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.files = append(m.files, SpoolFile{Filename: filename, First: first, Last: last, SetName: setName,
+		Size: int64(len(data)), Status: SpoolPending})
+	return filename, m.saveIndex()
+}
+
+// waitForQuota waits until pending files and a new file fit the quota or the context is canceled, a file
+// is always allowed if none is pending
+func (m *SpoolManager) waitForQuota(ctx context.Context, setName string, size int64) error {
+	if m.Quota <= 0 {
+		return nil
+	}
+	var alerted time.Time
+	for {
+		pending := m.GetPendingSize()
+		if pending == 0 || pending+size <= m.Quota {
+			return nil
+		}
+		if time.Since(alerted) > time.Minute {
+			alerted = time.Now()
+			status := fmt.Sprintf("%v spool quota reached, %v of %v MB pending, pause caching oplogs",
+				setName, pending/mb, m.Quota/mb)
+			gox.GetLogger("SpoolManager").Warn(status)
+			if inst := GetMigratorInstance(); inst != nil {
+				ws := inst.Workspace()
+				ws.Log(status)
+			}
+		}
+		if !sleepWithContext(ctx, time.Second) {
+			return ctx.Err()
+		}
+	}
+}
+
+// Release deletes or archives a spooled file after it's applied
+func (m *SpoolManager) Release(filename string) error {
+	status := SpoolApplied
+	if m.Archive {
+		dir := filepath.Join(m.Dir, SpoolArchive)
+		os.MkdirAll(dir, 0755)
+		if err := os.Rename(filename, filepath.Join(dir, filepath.Base(filename))); err != nil {
+			return fmt.Errorf("archive %v failed: %v", filename, err)
+		}
+		status = SpoolArchived
+	} else if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove %v failed: %v", filename, err)
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for i, file := range m.files {
+		if file.Filename == filename {
+			m.files[i].Status = status
+		}
+	}
+	return m.saveIndex()
+}
+
+// GetPendingSize returns total bytes of spooled files not yet applied
+func (m *SpoolManager) GetPendingSize() int64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var size int64
+	for _, file := range m.files {
+		if file.Status == SpoolPending {
+			size += file.Size
+		}
+	}
+	return size
+}
+
+// GetFiles returns index entries of spooled files
+func (m *SpoolManager) GetFiles() []SpoolFile {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append([]SpoolFile{}, m.files...)
+}
+
+// saveIndex writes the index file, caller holds the mutex
+func (m *SpoolManager) saveIndex() error {
+	data, err := json.MarshalIndent(m.files, "", "  ")
+	if err != nil {
+		return err
+	}
+	filename := filepath.Join(m.Dir, SpoolIndexFile)
+	if err = os.WriteFile(filename+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(filename+".tmp", filename)
+}
+
+// GetSpoolFileExt returns file extension of a compressor
+func GetSpoolFileExt(compressor string) string {
+	if compressor == CompressorSnappy {
+		return SnappyBSONFileExt
+	} else if compressor == CompressorZstd {
+		return ZstdBSONFileExt
+	}
+	return GZippedBSONFileExt
+}
+
+// IsSpoolFile returns true if a file name is of a spooled oplog file
+func IsSpoolFile(name string) bool {
+	return strings.HasSuffix(name, GZippedBSONFileExt) || strings.HasSuffix(name, SnappyBSONFileExt) ||
+		strings.HasSuffix(name, ZstdBSONFileExt)
+}
+
+// CompressBytes compresses bytes with a compressor
+func CompressBytes(b []byte, compressor string) ([]byte, error) {
+	var zbuf bytes.Buffer
+	var writer io.WriteCloser
+	var err error
+	if compressor == CompressorSnappy {
+		writer = snappy.NewBufferedWriter(&zbuf)
+	} else if compressor == CompressorZstd {
+		if writer, err = zstd.NewWriter(&zbuf); err != nil {
+			return nil, err
+		}
+	} else {
+		writer = gzip.NewWriter(&zbuf)
+	}
+	if _, err = writer.Write(b); err != nil {
+		return nil, err
+	}
+	if err = writer.Close(); err != nil { // flushing the bytes to the buffer
+		return nil, err
+	}
+	return zbuf.Bytes(), nil
+}
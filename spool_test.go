@@ -0,0 +1,82 @@
+// Copyright Kuei-chun Chen, 2022-present. All rights reserved.
+
+package hummingbird
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestSpoolManagerWrite(t *testing.T) {
+	var raws []byte
+	for i := 0; i < 100; i++ {
+		data, err := bson.Marshal(Oplog{Namespace: TestNS, Operation: "i", Object: bson.D{{"_id", i}},
+			Timestamp: primitive.Timestamp{T: uint32(i + 1)}})
+		assertEqual(t, nil, err)
+		raws = append(raws, data...)
+	}
+	for _, compressor := range []string{CompressorGzip, CompressorSnappy, CompressorZstd} {
+		dir := t.TempDir()
+		spooler := NewSpoolManager(dir, compressor, 0, false)
+		filename, err := spooler.Write(context.Background(), "replset", raws, primitive.Timestamp{T: 1}, primitive.Timestamp{T: 100})
+		assertEqual(t, nil, err)
+		assertEqual(t, true, IsSpoolFile(filename))
+		reader, err := NewBSONReader(filename)
+		assertEqual(t, nil, err)
+		count := 0
+		for data := reader.Next(); data != nil; data = reader.Next() {
+			count++
+		}
+		assertEqual(t, 100, count)
+
+		spooler = NewSpoolManager(dir, compressor, 0, false) // reload index
+		files := spooler.GetFiles()
+		assertEqual(t, 1, len(files))
+		assertEqual(t, uint32(100), files[0].Last.T)
+		assertEqual(t, SpoolPending, files[0].Status)
+		assertNotEqual(t, int64(0), spooler.GetPendingSize())
+	}
+}
+
+func TestSpoolManagerRelease(t *testing.T) {
+	raws, err := bson.Marshal(Oplog{Namespace: TestNS, Operation: "d", Object: bson.D{{"_id", 1}}})
+	assertEqual(t, nil, err)
+	for _, archive := range []bool{false, true} {
+		dir := t.TempDir()
+		spooler := NewSpoolManager(dir, CompressorGzip, 0, archive)
+		filename, err := spooler.Write(context.Background(), "replset", raws, primitive.Timestamp{T: 1}, primitive.Timestamp{T: 1})
+		assertEqual(t, nil, err)
+		err = spooler.Release(filename)
+		assertEqual(t, nil, err)
+		assertEqual(t, int64(0), spooler.GetPendingSize())
+		_, err = os.Stat(filename)
+		assertEqual(t, true, os.IsNotExist(err))
+		_, err = os.Stat(filepath.Join(dir, SpoolArchive, filepath.Base(filename)))
+		assertEqual(t, archive, err == nil)
+		if archive {
+			assertEqual(t, SpoolArchived, spooler.GetFiles()[0].Status)
+		} else {
+			assertEqual(t, SpoolApplied, spooler.GetFiles()[0].Status)
+		}
+	}
+}
+
+func TestSpoolManagerWriteCanceled(t *testing.T) {
+	migratorInstance = nil
+	raws, err := bson.Marshal(Oplog{Namespace: TestNS, Operation: "d", Object: bson.D{{"_id", 1}}})
+	assertEqual(t, nil, err)
+	spooler := NewSpoolManager(t.TempDir(), CompressorGzip, 1, false)
+	_, err = spooler.Write(context.Background(), "replset", raws, primitive.Timestamp{T: 1}, primitive.Timestamp{T: 1})
+	assertEqual(t, nil, err) // allowed if none is pending
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = spooler.Write(ctx, "replset", raws, primitive.Timestamp{T: 2}, primitive.Timestamp{T: 2})
+	assertEqual(t, context.DeadlineExceeded, err)
+	assertEqual(t, 1, len(spooler.GetFiles()))
+}
@@ -3,14 +3,15 @@
 package hummingbird
 
 import (
+	"context"
 	"fmt"
 	"log"
 
 	"github.com/simagix/gox"
 )
 
-// Start starts a migration
-func Start(filename string, extra ...bool) error {
+// Start starts a migration, it saves states and returns once the context is canceled
+func Start(ctx context.Context, filename string, extra ...bool) error {
 	var err error
 	var isConfig, isData, isOplog bool
 	logger := gox.GetLogger()
@@ -42,15 +43,28 @@ func Start(filename string, extra ...bool) error {
 		if inst.Command == CommandData {
 			isOplog = true
 		}
+	} else if inst.Command == CommandOplog { // stream oplogs to an already seeded target
+		if err = SeedOplogCheckpoints(); err != nil {
+			return fmt.Errorf("SeedOplogCheckpoints failed: %v", err)
+		}
+		if err = CheckResumePositions(); err != nil { // if oplogs rolled over, exits
+			return fmt.Errorf("CheckResumePositions failed: %v", err)
+		}
+		isOplog = true
 	} else {
 		return fmt.Errorf("unsupported command %v", inst.Command)
 	}
+	ctx, cancel := context.WithCancel(ctx) // canceled by a signal or a failure of workers or streamers
+	defer cancel()
+	inst.SetCancel(cancel)
+	streamCtx, stopStreaming := context.WithCancel(ctx) // stopped by a signal, a failure, or a cutover
+	defer stopStreaming()
 	wg := gox.NewWaitGroup(4)
 	if len(extra) == 0 {
 		wg.Add(1)
 		go func(port int) {
 			defer wg.Done()
-			if err := StartWebServer(port); err != nil {
+			if err := StartWebServer(ctx, port); err != nil {
 				log.Fatalf("StartWebServer failed: %v", err)
 			}
 		}(inst.Port)
@@ -60,6 +74,11 @@ func Start(filename string, extra ...bool) error {
 			return fmt.Errorf("CheckIfBalancersDisabled failed: %v", err)
 		}
 	}
+	if isData && isOplog {
+		if err = CheckOplogWindows(); err != nil { // if oplogs would roll over before data copied, exits
+			return fmt.Errorf("CheckOplogWindows failed: %v", err)
+		}
+	}
 	if inst.IsDrop {
 		if err = inst.DropCollections(); err != nil {
 			return fmt.Errorf("DropCollections failed: %v", err)
@@ -71,17 +90,20 @@ func Start(filename string, extra ...bool) error {
 		}
 	}
 	if isOplog {
-		if err = OplogStreamers(); err != nil {
+		if err = OplogStreamers(streamCtx); err != nil {
 			return fmt.Errorf("OplogStreamers failed: %v", err)
 		}
+		go WatchCutover(streamCtx, stopStreaming)
 	}
 	if isData {
-		if err = DataCopier(); err != nil {
+		if err = DataCopier(ctx); err != nil {
 			return fmt.Errorf("DataCopier failed: %v", err)
 		}
 	}
-	inst.NotifyWorkerExit()
-	inst.LiveStreamingOplogs()
+	if ctx.Err() == nil { // not shutting down
+		inst.NotifyWorkerExit()
+		inst.LiveStreamingOplogs(streamCtx)
+	}
 	wg.Wait()
-	return nil
+	return inst.WaitForShutdown(ctx)
 }
@@ -7,7 +7,6 @@ import (
 	"fmt"
 	"time"
 
-	"github.com/simagix/keyhole/mdb"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -29,29 +28,31 @@ const (
 // Task holds migration task information
 type Task struct {
 	BeginTime    time.Time           `bson:"begin_time"`
+	Bytes        int64               `bson:"bytes,omitempty"`
 	EndTime      time.Time           `bson:"end_time"`
+	Exclusive    bool                `bson:"exclusive,omitempty"`
 	ID           primitive.ObjectID  `bson:"_id"`
 	IDs          []interface{}       `bson:"ids"`
 	Include      Include             `bson:"include"`
 	Inserted     int                 `bson:"inserted"`
 	Namespace    string              `bson:"ns"`
 	ParentID     *primitive.ObjectID `bson:"parent_id"`
+	Sampled      bool                `bson:"sampled,omitempty"`
 	SetName      string              `bson:"replica_set"`
 	SourceCounts int                 `bson:"source_counts"`
 	Status       string              `bson:"status"`
 	UpdatedBy    string              `bson:"updated_by"`
 }
 
-// CopyData copies data
-func (p *Task) CopyData(source *mongo.Collection, target *mongo.Collection) error {
-	ctx := context.Background()
+// CopyData copies data, it stops and returns an error if the context is canceled
+func (p *Task) CopyData(ctx context.Context, source *mongo.Collection, target *mongo.Collection) error {
 	if p.SourceCounts == 0 {
 		return nil
 	}
-	if len(p.IDs) < 2 {
-		return fmt.Errorf("no _id range found")
+	query, err := p.GetIDQuery()
+	if err != nil {
+		return err
 	}
-	query := bson.D{{"_id", bson.D{{"$gte", p.IDs[0]}}}, {"_id", bson.D{{"$lte", p.IDs[1]}}}}
 	if len(p.Include.Filter) > 0 {
 		query = append(p.Include.Filter, query...)
 	}
@@ -65,41 +66,153 @@ func (p *Task) CopyData(source *mongo.Collection, target *mongo.Collection) erro
 	var docs []interface{}
 	for cursor.Next(ctx) {
 		if len(docs) >= 1000 || size > MaxBatchDataSize {
-			p.batchedCopy(target, docs)
+			if err = p.batchedCopy(target, docs); err != nil {
+				return fmt.Errorf("CopyData batched copy failed: %v", err)
+			}
 			size = 0
 			docs = []interface{}{}
 		}
 		doc := make(bson.Raw, len(cursor.Current))
 		copy(doc, cursor.Current)
+		if len(p.Include.Masks) > 0 || len(p.Include.Transforms) > 0 {
+			if doc, err = p.processDocument(doc); err != nil {
+				return fmt.Errorf("CopyData process document failed: %v", err)
+			}
+		}
 		docs = append(docs, doc)
-		size += len(cursor.Current)
+		size += len(doc)
+	}
+	if err = cursor.Err(); err != nil {
+		return fmt.Errorf("CopyData cursor failed: %v", err)
 	}
 	if len(docs) > 0 {
-		p.batchedCopy(target, docs)
+		if err = p.batchedCopy(target, docs); err != nil {
+			return fmt.Errorf("CopyData batched copy failed: %v", err)
+		}
 	}
 	return nil
 }
 
-func (p *Task) batchedCopy(target *mongo.Collection, docs []interface{}) {
+// GetIDQuery returns the query of the _id range or sampled _id of a task
+func (p *Task) GetIDQuery() (bson.D, error) {
+	if p.Sampled { // IDs is a list of sampled _id
+		if len(p.IDs) == 0 {
+			return nil, fmt.Errorf("no sampled _id found")
+		}
+		return bson.D{{"_id", bson.D{{"$in", p.IDs}}}}, nil
+	} else if len(p.IDs) < 2 {
+		return nil, fmt.Errorf("no _id range found")
+	} else if p.Exclusive { // boundaries from the server, the next range begins at IDs[1]
+		return bson.D{{"_id", bson.D{{"$gte", p.IDs[0]}}}, {"_id", bson.D{{"$lt", p.IDs[1]}}}}, nil
+	}
+	return bson.D{{"_id", bson.D{{"$gte", p.IDs[0]}}}, {"_id", bson.D{{"$lte", p.IDs[1]}}}}, nil
+}
+
+// processDocument masks fields and then applies transforms defined in include
+func (p *Task) processDocument(raw bson.Raw) (bson.Raw, error) {
+	var err error
+	var doc bson.D
+	if err = bson.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	if len(p.Include.Masks) > 0 {
+		MaskFields(&doc, p.Include.Masks, p.Include.Method)
+	}
+	if len(p.Include.Transforms) > 0 {
+		if doc, err = TransformDocument(doc, p.Include.Transforms); err != nil {
+			return nil, err
+		}
+	}
+	return bson.Marshal(doc)
+}
+
+// batchedCopy inserts documents, write errors, i.e. duplicate keys, are resolved by the conflict policy.
+// Existing documents same as the source, i.e. of a range copied again, are counted as inserted, and errors
+// other than write errors are returned so that the task is copied again.
+func (p *Task) batchedCopy(target *mongo.Collection, docs []interface{}) error {
 	ctx := context.Background()
 	opts := options.InsertMany()
 	opts.SetOrdered(false)
-	result, err := target.InsertMany(ctx, docs, opts)
-	if err != nil && (mdb.IsDuplicateKeyError(err) || mdb.GetErrorCode(err) == 16755) {
-		var ids []interface{}
-		for _, raw := range docs {
-			var doc bson.M
-			bson.Unmarshal(raw.(bson.Raw), &doc)
-			ids = append(ids, doc["_id"])
+	_, err := target.InsertMany(ctx, docs, opts)
+	if err == nil {
+		p.Inserted += len(docs)
+		return nil
+	} else if bwe, ok := err.(mongo.BulkWriteException); !ok || len(bwe.WriteErrors) == 0 {
+		return err
+	}
+	errs := getWriteErrors(err, len(docs))
+	if err = removeSameDuplicates(target, docs, errs); err != nil {
+		return err
+	}
+	p.Inserted += len(docs) - len(errs)
+	if len(errs) == 0 {
+		return nil
+	}
+	ns := target.Database().Name() + "." + target.Name()
+	conflicts := []Conflict{}
+	wmodels := []OplogWriteModel{}
+	for index, we := range errs {
+		var doc bson.D
+		if raw, ok := docs[index].(bson.Raw); ok {
+			bson.Unmarshal(raw, &doc)
 		}
-		query := bson.D{{"_id", bson.D{{"$in", ids}}}}
-		cnt, _ := target.CountDocuments(ctx, query)
-		if int(cnt) == len(docs) {
-			p.Inserted += int(cnt)
-		} else {
-			fmt.Println("TODO")
+		op := mongo.NewInsertOneModel()
+		op.SetDocument(doc)
+		wmodel := OplogWriteModel{ID: doc.Map()["_id"], Namespace: ns, Operation: "i", Source: p.Namespace, WriteModel: op}
+		conflicts = append(conflicts, NewConflict(wmodel, getConflictReason(we)))
+		wmodels = append(wmodels, wmodel)
+	}
+	var results BulkWriteOplogsResult
+	unresolved := ResolveConflicts(target.Database().Client(), conflicts, wmodels, &results)
+	p.Inserted += int(results.UpsertedCount)
+	if inst := GetMigratorInstance(); unresolved > 0 && inst != nil && inst.Conflict == ConflictFail {
+		return fmt.Errorf("%v conflict(s) found, see %v.%v", unresolved, MetaDBName, MetaConflicts)
+	}
+	return nil
+}
+
+// removeSameDuplicates removes duplicate key errors of which target documents are same as the source
+func removeSameDuplicates(target *mongo.Collection, docs []interface{}, errs map[int]mongo.WriteError) error {
+	ids := []interface{}{}
+	keys := map[int]string{}
+	sources := map[string]bson.D{}
+	for index, we := range errs {
+		var doc bson.D
+		if raw, ok := docs[index].(bson.Raw); we.Code != 11000 || !ok || bson.Unmarshal(raw, &doc) != nil {
+			continue
 		}
-	} else if result != nil {
-		p.Inserted += len(result.InsertedIDs)
+		id := doc.Map()["_id"]
+		ids = append(ids, id)
+		keys[index] = GetIDKey(id)
+		sources[keys[index]] = doc
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	ctx := context.Background()
+	cursor, err := target.Find(ctx, bson.D{{"_id", bson.D{{"$in", ids}}}})
+	if err != nil {
+		return fmt.Errorf("Find failed: %v", err)
 	}
+	defer cursor.Close(ctx)
+	same := map[string]bool{}
+	for cursor.Next(ctx) {
+		var doc bson.D
+		if err = cursor.Decode(&doc); err != nil {
+			return fmt.Errorf("Decode failed: %v", err)
+		}
+		key := GetIDKey(doc.Map()["_id"])
+		if matched, _ := isSameDocument(sources[key], doc); matched {
+			same[key] = true
+		}
+	}
+	if err = cursor.Err(); err != nil {
+		return fmt.Errorf("cursor failed: %v", err)
+	}
+	for index, key := range keys {
+		if same[key] {
+			delete(errs, index)
+		}
+	}
+	return nil
 }
@@ -36,16 +36,43 @@ func TestCopyData(t *testing.T) {
 	assertEqual(t, nil, err)
 
 	task := &Task{IDs: []interface{}{100, 109}, SourceCounts: 10}
-	err = task.CopyData(src, tgt)
+	err = task.CopyData(ctx, src, tgt)
 	assertEqual(t, nil, err)
 	count, err := tgt.CountDocuments(ctx, bson.D{})
 	assertEqual(t, nil, err)
 	assertEqual(t, 10, int(count))
 
 	tgt.Drop(ctx)
-	err = task.CopyData(src, tgt)
+	err = task.CopyData(ctx, src, tgt)
 	assertEqual(t, nil, err)
 	count, err = tgt.CountDocuments(ctx, bson.D{})
 	assertEqual(t, nil, err)
 	assertEqual(t, 10, int(count))
 }
+
+func TestCopyDataSampled(t *testing.T) {
+	ctx := context.Background()
+	dbName, collName := mdb.SplitNamespace(TestNS)
+	source, err := GetMongoClient(TestSourceURI)
+	assertEqual(t, nil, err)
+	src := source.Database(dbName).Collection(collName)
+	src.Drop(ctx)
+	docs := []interface{}{}
+	for i := 100; i < 110; i++ {
+		docs = append(docs, bson.D{{"_id", i}})
+	}
+	_, err = src.InsertMany(ctx, docs)
+	assertEqual(t, nil, err)
+
+	target, err := GetMongoClient(TestTargetURI)
+	assertEqual(t, nil, err)
+	tgt := target.Database(dbName).Collection(collName)
+	tgt.Drop(ctx)
+
+	task := &Task{IDs: []interface{}{100, 103, 106, 109}, Sampled: true, SourceCounts: 4}
+	err = task.CopyData(ctx, src, tgt)
+	assertEqual(t, nil, err)
+	count, err := tgt.CountDocuments(ctx, bson.D{})
+	assertEqual(t, nil, err)
+	assertEqual(t, 4, int(count))
+}
@@ -0,0 +1,539 @@
+// Copyright Kuei-chun Chen, 2022-present. All rights reserved.
+
+package hummingbird
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// StageAddFields is an alias of $set
+	StageAddFields = "$addFields"
+	// StageProject includes or excludes fields
+	StageProject = "$project"
+	// StageRename renames fields, i.e. {"$rename": {"old": "new"}}
+	StageRename = "$rename"
+	// StageSet adds or replaces fields with expressions
+	StageSet = "$set"
+	// StageUnset removes fields
+	StageUnset = "$unset"
+)
+
+// ValidateTransforms returns error if a stage is not supported
+func ValidateTransforms(stages []bson.D) error {
+	for _, stage := range stages {
+		if len(stage) != 1 {
+			return fmt.Errorf(`a transform stage must have exactly one field, found %v`, Stringify(stage))
+		}
+		name, spec := stage[0].Key, stage[0].Value
+		switch name {
+		case StageAddFields, StageSet:
+			fields, ok := spec.(bson.D)
+			if !ok {
+				return fmt.Errorf(`%v requires a document`, name)
+			}
+			for _, field := range fields {
+				if field.Key == "_id" {
+					return fmt.Errorf(`%v cannot modify _id`, name)
+				} else if err := validateExpression(field.Value); err != nil {
+					return fmt.Errorf(`%v %v: %v`, name, field.Key, err)
+				}
+			}
+		case StageProject:
+			fields, ok := spec.(bson.D)
+			if !ok {
+				return fmt.Errorf(`%v requires a document`, name)
+			}
+			var inclusion *bool
+			for _, field := range fields {
+				include, ok := getProjection(field.Value)
+				if !ok {
+					return fmt.Errorf(`%v %v: only inclusion and exclusion are supported`, name, field.Key)
+				} else if field.Key == "_id" {
+					if !include {
+						return fmt.Errorf(`%v cannot exclude _id`, name)
+					}
+					continue
+				} else if inclusion != nil && *inclusion != include {
+					return fmt.Errorf(`%v cannot mix inclusion and exclusion`, name)
+				}
+				inclusion = &include
+			}
+		case StageRename:
+			fields, ok := spec.(bson.D)
+			if !ok {
+				return fmt.Errorf(`%v requires a document`, name)
+			}
+			for _, field := range fields {
+				if field.Key == "_id" || field.Value == "_id" {
+					return fmt.Errorf(`%v cannot modify _id`, name)
+				} else if _, ok = field.Value.(string); !ok || field.Value == "" {
+					return fmt.Errorf(`%v %v requires a new field name`, name, field.Key)
+				}
+			}
+		case StageUnset:
+			fields, err := getUnsetFields(spec)
+			if err != nil {
+				return err
+			}
+			for _, field := range fields {
+				if field == "_id" {
+					return fmt.Errorf(`%v cannot modify _id`, name)
+				}
+			}
+		default:
+			return fmt.Errorf(`transform stage %v is not supported`, name)
+		}
+	}
+	return nil
+}
+
+// IsLookupTransforms returns true if a $set or $addFields stage references fields other than itself, which
+// cannot be evaluated from an update and source documents are looked up instead
+func IsLookupTransforms(stages []bson.D) bool {
+	for _, stage := range stages {
+		if stage[0].Key != StageAddFields && stage[0].Key != StageSet {
+			continue
+		}
+		fields, _ := stage[0].Value.(bson.D)
+		for _, field := range fields {
+			for _, ref := range getFieldReferences(field.Value) {
+				if ref != field.Key {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// TransformDocument applies transform stages to a document
+func TransformDocument(doc bson.D, stages []bson.D) (bson.D, error) {
+	var err error
+	for _, stage := range stages {
+		name, spec := stage[0].Key, stage[0].Value
+		switch name {
+		case StageAddFields, StageSet:
+			for _, field := range spec.(bson.D) {
+				var value interface{}
+				if value, err = evaluateExpression(field.Value, doc); err != nil {
+					return doc, fmt.Errorf(`%v %v: %v`, name, field.Key, err)
+				}
+				if value != nil {
+					doc = setFieldValue(doc, field.Key, value)
+				}
+			}
+		case StageProject:
+			doc = projectFields(doc, spec.(bson.D))
+		case StageRename:
+			for _, field := range spec.(bson.D) {
+				if value, ok := getFieldValue(doc, field.Key); ok {
+					doc = unsetFieldValue(doc, field.Key)
+					doc = setFieldValue(doc, field.Value.(string), value)
+				}
+			}
+		case StageUnset:
+			fields, _ := getUnsetFields(spec)
+			for _, field := range fields {
+				doc = unsetFieldValue(doc, field)
+			}
+		}
+	}
+	return doc, nil
+}
+
+// TransformUpdate applies transform stages to an update document, i.e. {"$set": {}, "$unset": {}}, and
+// returns nil if nothing is left to update.  Paths of $push, truncating arrays, are transformed as $unset.
+func TransformUpdate(update bson.D, stages []bson.D) (bson.D, error) {
+	var err error
+	var result bson.D
+	for _, op := range update {
+		fields, ok := op.Value.(bson.D)
+		if !ok || (op.Key != "$push" && op.Key != "$set" && op.Key != "$unset") {
+			result = append(result, op)
+			continue
+		}
+		for _, stage := range stages {
+			if fields, err = transformUpdateFields(fields, op.Key, stage); err != nil {
+				return nil, err
+			}
+		}
+		if len(fields) > 0 {
+			result = append(result, bson.E{Key: op.Key, Value: fields})
+		}
+	}
+	return result, nil
+}
+
+// transformUpdateFields applies a stage to fields of $set or $unset, of which keys can be dotted paths
+func transformUpdateFields(fields bson.D, operator string, stage bson.D) (bson.D, error) {
+	name, spec := stage[0].Key, stage[0].Value
+	switch name {
+	case StageAddFields, StageSet:
+		if operator != "$set" {
+			return fields, nil
+		}
+		for _, field := range spec.(bson.D) {
+			if len(getFieldReferences(field.Value)) == 0 { // constants are set when inserted
+				continue
+			}
+			for i, v := range fields {
+				if v.Key != field.Key {
+					continue
+				}
+				value, err := evaluateExpression(field.Value, bson.D{{field.Key, v.Value}})
+				if err != nil {
+					return fields, fmt.Errorf(`%v %v: %v`, name, field.Key, err)
+				}
+				fields[i].Value = value
+			}
+		}
+	case StageProject:
+		projection := spec.(bson.D)
+		result := bson.D{}
+		for _, v := range fields {
+			nested := wrapFieldPath(strings.Split(v.Key, "."), v.Value)
+			nested = projectFields(nested, projection)
+			if len(nested) > 0 {
+				result = append(result, bson.E{Key: v.Key, Value: unwrapFieldPath(strings.Split(v.Key, "."), nested)})
+			}
+		}
+		return result, nil
+	case StageRename:
+		for _, rename := range spec.(bson.D) {
+			from, to := rename.Key, rename.Value.(string)
+			for i, v := range fields {
+				if v.Key == from || strings.HasPrefix(v.Key, from+".") {
+					fields[i].Key = to + v.Key[len(from):]
+				} else if strings.HasPrefix(from, v.Key+".") {
+					doc, ok := v.Value.(bson.D)
+					if !ok {
+						continue
+					}
+					subpath := from[len(v.Key)+1:]
+					if value, ok := getFieldValue(doc, subpath); ok {
+						fields[i].Value = unsetFieldValue(doc, subpath)
+						fields = append(fields, bson.E{Key: to, Value: value})
+					}
+				}
+			}
+		}
+	case StageUnset:
+		unsets, _ := getUnsetFields(spec)
+		for _, unset := range unsets {
+			result := bson.D{}
+			for _, v := range fields {
+				if v.Key == unset || strings.HasPrefix(v.Key, unset+".") {
+					continue
+				} else if doc, ok := v.Value.(bson.D); ok && strings.HasPrefix(unset, v.Key+".") {
+					v.Value = unsetFieldValue(doc, unset[len(v.Key)+1:])
+				}
+				result = append(result, v)
+			}
+			fields = result
+		}
+	}
+	return fields, nil
+}
+
+func getUnsetFields(spec interface{}) ([]string, error) {
+	if field, ok := spec.(string); ok {
+		return []string{field}, nil
+	} else if values, ok := spec.(bson.A); ok {
+		fields := []string{}
+		for _, value := range values {
+			field, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf(`%v requires field names`, StageUnset)
+			}
+			fields = append(fields, field)
+		}
+		return fields, nil
+	}
+	return nil, fmt.Errorf(`%v requires a field name or an array of field names`, StageUnset)
+}
+
+// projectFields keeps included fields or removes excluded fields, _id is kept unless excluded
+func projectFields(doc bson.D, projection bson.D) bson.D {
+	isInclusion := false
+	excludeID := false
+	for _, field := range projection {
+		include, _ := getProjection(field.Value)
+		if field.Key == "_id" {
+			excludeID = !include
+		} else {
+			isInclusion = include
+		}
+	}
+	if !isInclusion {
+		for _, field := range projection {
+			doc = unsetFieldValue(doc, field.Key)
+		}
+		return doc
+	}
+	result := bson.D{}
+	if value, ok := getFieldValue(doc, "_id"); ok && !excludeID {
+		result = append(result, bson.E{Key: "_id", Value: value})
+	}
+	for _, field := range projection {
+		if field.Key == "_id" {
+			continue
+		}
+		if value, ok := getFieldValue(doc, field.Key); ok {
+			result = setFieldValue(result, field.Key, value)
+		}
+	}
+	return result
+}
+
+// getProjection returns true for inclusion and false for exclusion
+func getProjection(value interface{}) (bool, bool) {
+	switch v := value.(type) {
+	case bool:
+		return v, true
+	case int, int32, int64, float64:
+		return ToFloat64(v) != 0, true
+	}
+	return false, false
+}
+
+// getFieldValue returns value of a dotted path
+func getFieldValue(doc bson.D, path string) (interface{}, bool) {
+	elems := strings.SplitN(path, ".", 2)
+	for _, v := range doc {
+		if v.Key != elems[0] {
+			continue
+		} else if len(elems) == 1 {
+			return v.Value, true
+		} else if subdoc, ok := v.Value.(bson.D); ok {
+			return getFieldValue(subdoc, elems[1])
+		}
+		return nil, false
+	}
+	return nil, false
+}
+
+// setFieldValue sets value of a dotted path and creates sub documents if needed
+func setFieldValue(doc bson.D, path string, value interface{}) bson.D {
+	elems := strings.SplitN(path, ".", 2)
+	for i, v := range doc {
+		if v.Key != elems[0] {
+			continue
+		} else if len(elems) == 1 {
+			doc[i].Value = value
+		} else {
+			subdoc, _ := v.Value.(bson.D)
+			doc[i].Value = setFieldValue(subdoc, elems[1], value)
+		}
+		return doc
+	}
+	if len(elems) == 1 {
+		return append(doc, bson.E{Key: path, Value: value})
+	}
+	return append(doc, bson.E{Key: elems[0], Value: setFieldValue(bson.D{}, elems[1], value)})
+}
+
+// unsetFieldValue removes a dotted path
+func unsetFieldValue(doc bson.D, path string) bson.D {
+	elems := strings.SplitN(path, ".", 2)
+	for i, v := range doc {
+		if v.Key != elems[0] {
+			continue
+		} else if len(elems) == 1 {
+			return append(doc[:i:i], doc[i+1:]...)
+		} else if subdoc, ok := v.Value.(bson.D); ok {
+			doc[i].Value = unsetFieldValue(subdoc, elems[1])
+		}
+		return doc
+	}
+	return doc
+}
+
+// getFieldReferences returns all field paths referenced by an expression, i.e. "$a.b"
+func getFieldReferences(expr interface{}) []string {
+	refs := []string{}
+	switch v := expr.(type) {
+	case string:
+		if strings.HasPrefix(v, "$") && !strings.HasPrefix(v, "$$") {
+			refs = append(refs, v[1:])
+		}
+	case bson.D:
+		for _, elem := range v {
+			if elem.Key == "$literal" {
+				continue
+			}
+			refs = append(refs, getFieldReferences(elem.Value)...)
+		}
+	case bson.A:
+		for _, elem := range v {
+			refs = append(refs, getFieldReferences(elem)...)
+		}
+	}
+	return refs
+}
+
+var expressionOperators = map[string]bool{"$concat": true, "$literal": true, "$toBool": true, "$toDate": true,
+	"$toDouble": true, "$toInt": true, "$toLong": true, "$toString": true}
+
+func validateExpression(expr interface{}) error {
+	switch v := expr.(type) {
+	case string:
+		if strings.HasPrefix(v, "$$") {
+			return fmt.Errorf(`variable %v is not supported`, v)
+		}
+	case bson.D:
+		for _, elem := range v {
+			if strings.HasPrefix(elem.Key, "$") && !expressionOperators[elem.Key] {
+				return fmt.Errorf(`operator %v is not supported`, elem.Key)
+			} else if elem.Key == "$literal" {
+				continue
+			}
+			if err := validateExpression(elem.Value); err != nil {
+				return err
+			}
+		}
+	case bson.A:
+		for _, elem := range v {
+			if err := validateExpression(elem); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// evaluateExpression evaluates an expression against a document, nil is returned if a field is missing
+func evaluateExpression(expr interface{}, doc bson.D) (interface{}, error) {
+	switch v := expr.(type) {
+	case string:
+		if strings.HasPrefix(v, "$") {
+			value, _ := getFieldValue(doc, v[1:])
+			return value, nil
+		}
+		return v, nil
+	case bson.D:
+		if len(v) == 1 && strings.HasPrefix(v[0].Key, "$") {
+			return evaluateOperator(v[0].Key, v[0].Value, doc)
+		}
+		result := bson.D{}
+		for _, elem := range v {
+			value, err := evaluateExpression(elem.Value, doc)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, bson.E{Key: elem.Key, Value: value})
+		}
+		return result, nil
+	case bson.A:
+		result := bson.A{}
+		for _, elem := range v {
+			value, err := evaluateExpression(elem, doc)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, value)
+		}
+		return result, nil
+	}
+	return expr, nil
+}
+
+func evaluateOperator(operator string, args interface{}, doc bson.D) (interface{}, error) {
+	if operator == "$literal" {
+		return args, nil
+	}
+	value, err := evaluateExpression(args, doc)
+	if err != nil || value == nil {
+		return nil, err
+	}
+	switch operator {
+	case "$concat":
+		values, ok := value.(bson.A)
+		if !ok {
+			return nil, fmt.Errorf(`%v requires an array`, operator)
+		}
+		str := ""
+		for _, v := range values {
+			if v == nil {
+				return nil, nil
+			} else if s, ok := v.(string); ok {
+				str += s
+			} else {
+				return nil, fmt.Errorf(`%v only supports strings, found %T`, operator, v)
+			}
+		}
+		return str, nil
+	case "$toBool":
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case string:
+			return true, nil
+		}
+		return ToFloat64(value) != 0, nil
+	case "$toDate":
+		return toDateTime(value)
+	case "$toDouble":
+		return toNumber(value, operator, func(f float64) interface{} { return f })
+	case "$toInt":
+		return toNumber(value, operator, func(f float64) interface{} { return int32(f) })
+	case "$toLong":
+		return toNumber(value, operator, func(f float64) interface{} { return int64(f) })
+	case "$toString":
+		switch v := value.(type) {
+		case string:
+			return v, nil
+		case primitive.ObjectID:
+			return v.Hex(), nil
+		case primitive.DateTime:
+			return v.Time().UTC().Format("2006-01-02T15:04:05.000Z"), nil
+		}
+		return fmt.Sprintf("%v", value), nil
+	}
+	return nil, fmt.Errorf(`operator %v is not supported`, operator)
+}
+
+func toNumber(value interface{}, operator string, convert func(float64) interface{}) (interface{}, error) {
+	if b, ok := value.(bool); ok {
+		if b {
+			return convert(1), nil
+		}
+		return convert(0), nil
+	} else if dt, ok := value.(primitive.DateTime); ok {
+		return convert(float64(dt)), nil
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(fmt.Sprintf("%v", value)), 64)
+	if err != nil {
+		return nil, fmt.Errorf(`%v failed to convert %v`, operator, value)
+	}
+	return convert(f), nil
+}
+
+var dateLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02"}
+
+func toDateTime(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case primitive.DateTime:
+		return v, nil
+	case primitive.Timestamp:
+		return primitive.NewDateTimeFromTime(time.Unix(int64(v.T), 0)), nil
+	case primitive.ObjectID:
+		return primitive.NewDateTimeFromTime(v.Timestamp()), nil
+	case string:
+		for _, layout := range dateLayouts {
+			if t, err := time.Parse(layout, v); err == nil {
+				return primitive.NewDateTimeFromTime(t), nil
+			}
+		}
+		return nil, fmt.Errorf(`$toDate failed to parse "%v"`, v)
+	case int, int32, int64, float64:
+		return primitive.DateTime(ToInt64(v)), nil
+	}
+	return nil, fmt.Errorf(`$toDate cannot convert %T`, value)
+}
@@ -0,0 +1,93 @@
+// Copyright Kuei-chun Chen, 2022-present. All rights reserved.
+
+package hummingbird
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	transforms = `{ "transforms": [
+		{ "$unset": ["internal", "audit.by"] },
+		{ "$rename": { "name": "fullName" } },
+		{ "$set": { "version": 2, "created": { "$toDate": "$created" }, "qty": { "$toInt": "$qty" } } }
+	] }`
+)
+
+func getTestTransforms(t *testing.T) []bson.D {
+	var include Include
+	err := bson.UnmarshalExtJSON([]byte(transforms), false, &include)
+	assertEqual(t, nil, err)
+	return include.Transforms
+}
+
+func TestValidateTransforms(t *testing.T) {
+	err := ValidateTransforms(getTestTransforms(t))
+	assertEqual(t, nil, err)
+
+	invalids := []string{`{ "transforms": [ { "$lookup": { "from": "coll" } } ] }`,
+		`{ "transforms": [ { "$set": { "total": { "$add": ["$a", "$b"] } } } ] }`,
+		`{ "transforms": [ { "$project": { "a": 1, "b": 0 } } ] }`,
+		`{ "transforms": [ { "$unset": "_id" } ] }`}
+	for _, invalid := range invalids {
+		var include Include
+		err = bson.UnmarshalExtJSON([]byte(invalid), false, &include)
+		assertEqual(t, nil, err)
+		assertNotEqual(t, nil, ValidateTransforms(include.Transforms))
+	}
+}
+
+func TestIsLookupTransforms(t *testing.T) {
+	assertEqual(t, false, IsLookupTransforms(getTestTransforms(t)))
+
+	var include Include
+	str := `{ "transforms": [ { "$set": { "name": { "$concat": ["$first", " ", "$last"] } } } ] }`
+	err := bson.UnmarshalExtJSON([]byte(str), false, &include)
+	assertEqual(t, nil, err)
+	assertEqual(t, nil, ValidateTransforms(include.Transforms))
+	assertEqual(t, true, IsLookupTransforms(include.Transforms))
+}
+
+func TestTransformDocument(t *testing.T) {
+	var doc bson.D
+	err := bson.UnmarshalExtJSON([]byte(`{ "_id": 1, "name": "Ken", "internal": true, "audit": { "by": "admin", "at": 1 },
+		"created": "2022-02-26T10:20:00Z", "qty": "12" }`), false, &doc)
+	assertEqual(t, nil, err)
+	doc, err = TransformDocument(doc, getTestTransforms(t))
+	assertEqual(t, nil, err)
+	m := doc.Map()
+	assertEqual(t, nil, m["internal"])
+	assertEqual(t, nil, m["name"])
+	assertEqual(t, "Ken", m["fullName"])
+	assertEqual(t, 1, len(m["audit"].(bson.D)))
+	assertEqual(t, int32(2), m["version"])
+	assertEqual(t, int32(12), m["qty"])
+	_, ok := m["created"].(primitive.DateTime)
+	assertEqual(t, true, ok)
+}
+
+func TestTransformDocumentProject(t *testing.T) {
+	doc := bson.D{{"_id", 1}, {"a", 1}, {"b", bson.D{{"c", 1}, {"d", 2}}}, {"e", 3}}
+	doc = projectFields(doc, bson.D{{"a", 1}, {"b.c", 1}})
+	assertEqual(t, `{"_id":1,"a":1,"b":{"c":1}}`, Stringify(doc))
+	doc = projectFields(doc, bson.D{{"b", 0}})
+	assertEqual(t, `{"_id":1,"a":1}`, Stringify(doc))
+}
+
+func TestTransformUpdate(t *testing.T) {
+	var update bson.D
+	err := bson.UnmarshalExtJSON([]byte(`{ "$set": { "name": "Ken", "audit": { "by": "admin", "at": 1 }, "qty": "12" },
+		"$unset": { "internal": true } }`), false, &update)
+	assertEqual(t, nil, err)
+	update, err = TransformUpdate(update, getTestTransforms(t))
+	assertEqual(t, nil, err)
+	assertEqual(t, `{"$set":{"fullName":"Ken","audit":{"at":1},"qty":12}}`, Stringify(update))
+
+	update = bson.D{{"$unset", bson.D{{"internal", true}}}}
+	update, err = TransformUpdate(update, getTestTransforms(t))
+	assertEqual(t, nil, err)
+	assertEqual(t, 0, len(update))
+}
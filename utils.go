@@ -61,6 +61,15 @@ func RedactedURI(uri string) string {
 	return str
 }
 
+// GetIDKey returns a comparable key of an _id value
+func GetIDKey(id interface{}) string {
+	t, data, err := bson.MarshalValue(id)
+	if err != nil {
+		return fmt.Sprintf("%v", id)
+	}
+	return fmt.Sprintf("%v:%x", t, data)
+}
+
 // GetDateTime returns formatted date/time
 func GetDateTime() string {
 	t := time.Now()
@@ -34,3 +34,10 @@ func TestToInt32(t *testing.T) {
 func TestToInt64(t *testing.T) {
 	assertEqual(t, int64(123), ToInt64("123"))
 }
+
+func TestGetIDKey(t *testing.T) {
+	assertEqual(t, GetIDKey(int32(123)), GetIDKey(int32(123)))
+	assertNotEqual(t, GetIDKey(int32(123)), GetIDKey("123"))
+	assertEqual(t, GetIDKey(bson.D{{"a", 1}, {"b", 2}}), GetIDKey(bson.D{{"a", 1}, {"b", 2}}))
+	assertNotEqual(t, GetIDKey(bson.D{{"a", 1}, {"b", 2}}), GetIDKey(bson.D{{"b", 2}, {"a", 1}}))
+}
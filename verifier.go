@@ -0,0 +1,262 @@
+// Copyright Kuei-chun Chen, 2022-present. All rights reserved.
+
+package hummingbird
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/simagix/gox"
+	"github.com/simagix/keyhole/mdb"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// MismatchFound means documents of source and target differ
+	MismatchFound = "mismatched"
+	// MismatchRepaired means a mismatched range was re-copied and verified
+	MismatchRepaired = "repaired"
+)
+
+// RangeDigest stores the count and an order-independent hash of documents
+type RangeDigest struct {
+	Count int64  `bson:"count"`
+	Hash  string `bson:"hash,omitempty"`
+
+	sum uint64
+}
+
+// Mismatch stores a task range, or a namespace, of which documents of source and target differ
+type Mismatch struct {
+	ID          primitive.ObjectID `bson:"_id"`
+	IDs         []interface{}      `bson:"ids,omitempty"`
+	Namespace   string             `bson:"ns"`
+	Sampled     bool               `bson:"sampled,omitempty"`
+	Source      RangeDigest        `bson:"source"`
+	Status      string             `bson:"status"`
+	Target      RangeDigest        `bson:"target"`
+	UpdatedTime time.Time          `bson:"updated_time"`
+}
+
+// add counts a document and adds its hash to the sum, the sum is the same regardless of the order
+func (d *RangeDigest) add(doc []byte) {
+	h := fnv.New64a()
+	h.Write(doc)
+	d.Count++
+	d.sum += h.Sum64()
+	d.Hash = fmt.Sprintf("%016x", d.sum)
+}
+
+// Verify compares counts and hashes of documents of all copied task ranges of source and target,
+// re-copies mismatched ranges and verifies them again, and then compares counts of all namespaces
+func Verify(ctx context.Context, filename string) error {
+	logger := gox.GetLogger("Verify")
+	inst, err := NewMigratorInstance(filename)
+	if err != nil {
+		return fmt.Errorf("NewMigratorInstance failed: %v", err)
+	}
+	ws := inst.Workspace()
+	tasks, err := ws.FindCompletedSubTasks()
+	if err != nil {
+		return fmt.Errorf("FindCompletedSubTasks failed: %v", err)
+	} else if len(tasks) == 0 {
+		return fmt.Errorf("no copied tasks found in %v.%v", MetaDBName, MetaTasks)
+	}
+	if err = ws.DropMismatches(); err != nil {
+		return fmt.Errorf("DropMismatches failed: %v", err)
+	}
+	status := fmt.Sprintf("verify %v range(s)", len(tasks))
+	logger.Remark(status)
+	ws.Log(status)
+	mismatched, err := VerifyTasks(ctx, tasks)
+	if err != nil {
+		return fmt.Errorf("VerifyTasks failed: %v", err)
+	}
+	logger.Infof("%v of %v range(s) mismatched", len(mismatched), len(tasks))
+	remaining := 0
+	for _, task := range mismatched {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		logger.Infof("re-copy %v range %v", task.Namespace, task.IDs)
+		if err = RecopyTask(ctx, task); err != nil {
+			return fmt.Errorf("RecopyTask failed: %v", err)
+		}
+		mismatch, err := VerifyTask(ctx, task)
+		if err != nil {
+			return fmt.Errorf("VerifyTask failed: %v", err)
+		}
+		if mismatch == nil { // the recorded mismatch is kept as repaired
+			mismatch = &Mismatch{ID: task.ID, IDs: task.IDs, Namespace: task.Namespace, Sampled: task.Sampled,
+				Status: MismatchRepaired}
+		} else {
+			remaining++
+		}
+		if err = ws.SaveMismatch(mismatch); err != nil {
+			return fmt.Errorf("SaveMismatch failed: %v", err)
+		}
+	}
+	counts, err := CountNamespaces()
+	if err != nil {
+		return fmt.Errorf("CountNamespaces failed: %v", err)
+	}
+	for _, count := range counts {
+		if count.Source == count.Target {
+			continue
+		}
+		remaining++
+		mismatch := &Mismatch{ID: primitive.NewObjectID(), Namespace: count.Namespace, Status: MismatchFound,
+			Source: RangeDigest{Count: count.Source}, Target: RangeDigest{Count: count.Target}}
+		if err = ws.SaveMismatch(mismatch); err != nil {
+			return fmt.Errorf("SaveMismatch failed: %v", err)
+		}
+	}
+	if remaining > 0 {
+		return fmt.Errorf("%v mismatch(es) remain, see %v.%v", remaining, MetaDBName, MetaMismatches)
+	}
+	status = fmt.Sprintf("verified %v range(s) and %v namespace(s), source == target", len(tasks), len(counts))
+	logger.Remark(status)
+	ws.Log(status)
+	return nil
+}
+
+// VerifyTasks verifies task ranges by workers, records mismatches, and returns mismatched tasks
+func VerifyTasks(ctx context.Context, tasks []*Task) ([]*Task, error) {
+	inst := GetMigratorInstance()
+	ws := inst.Workspace()
+	wg := gox.NewWaitGroup(inst.Workers)
+	var mutex sync.Mutex
+	var mismatched []*Task
+	var firstErr error
+	for _, task := range tasks {
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(task *Task) {
+			defer wg.Done()
+			mismatch, err := VerifyTask(ctx, task)
+			if err == nil && mismatch != nil {
+				err = ws.SaveMismatch(mismatch)
+			}
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err != nil && firstErr == nil {
+				firstErr = fmt.Errorf("%v %v", task.Namespace, err)
+			} else if mismatch != nil {
+				mismatched = append(mismatched, task)
+			}
+		}(task)
+	}
+	wg.Wait()
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
+	return mismatched, firstErr
+}
+
+// VerifyTask compares digests of documents of a task range of source and target, it returns nil if
+// they match
+func VerifyTask(ctx context.Context, task *Task) (*Mismatch, error) {
+	inst := GetMigratorInstance()
+	query, err := task.GetIDQuery()
+	if err != nil {
+		return nil, err
+	}
+	src, err := GetMongoClient(inst.Replicas()[task.SetName])
+	if err != nil {
+		return nil, fmt.Errorf("GetMongoClient failed: %v", err)
+	}
+	tgt, err := GetMongoClient(inst.Target)
+	if err != nil {
+		return nil, fmt.Errorf("GetMongoClient failed: %v", err)
+	}
+	dbName, collName := mdb.SplitNamespace(task.Namespace)
+	source := src.Database(dbName).Collection(collName)
+	dbName, collName = mdb.SplitNamespace(inst.GetToNamespace(task.Namespace))
+	target := tgt.Database(dbName).Collection(collName)
+	sourceQuery := append(append(bson.D{}, task.Include.Filter...), query...)
+
+	var sourceDigest RangeDigest
+	var sourceErr error
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() { // source and target in parallel
+		defer wg.Done()
+		sourceDigest, sourceErr = task.getDigest(ctx, source, sourceQuery, true)
+	}()
+	targetDigest, err := task.getDigest(ctx, target, query, false)
+	wg.Wait()
+	if sourceErr != nil {
+		return nil, fmt.Errorf("source getDigest failed: %v", sourceErr)
+	} else if err != nil {
+		return nil, fmt.Errorf("target getDigest failed: %v", err)
+	}
+	if len(task.Include.Masks) > 0 && task.Include.Method == MaskHEX { // masked randomly, counts only
+		sourceDigest.Hash = ""
+		targetDigest.Hash = ""
+	}
+	if sourceDigest == targetDigest {
+		return nil, nil
+	}
+	return &Mismatch{ID: task.ID, IDs: task.IDs, Namespace: task.Namespace, Sampled: task.Sampled,
+		Source: sourceDigest, Status: MismatchFound, Target: targetDigest}, nil
+}
+
+// getDigest returns the digest of documents of a query, source documents are masked and transformed
+// as copied
+func (p *Task) getDigest(ctx context.Context, coll *mongo.Collection, query bson.D, isSource bool) (RangeDigest, error) {
+	var digest RangeDigest
+	cursor, err := coll.Find(ctx, query)
+	if err != nil {
+		return digest, fmt.Errorf("Find failed: %v", err)
+	}
+	defer cursor.Close(context.Background())
+	isProcess := isSource && (len(p.Include.Masks) > 0 || len(p.Include.Transforms) > 0)
+	for cursor.Next(ctx) {
+		doc := []byte(cursor.Current)
+		if isProcess {
+			if doc, err = p.processDocument(cursor.Current); err != nil {
+				return digest, fmt.Errorf("process document failed: %v", err)
+			}
+		}
+		digest.add(doc)
+	}
+	digest.sum = 0 // compared by count and hash
+	return digest, cursor.Err()
+}
+
+// RecopyTask deletes documents of a task range from target and copies them again from source
+func RecopyTask(ctx context.Context, task *Task) error {
+	inst := GetMigratorInstance()
+	query, err := task.GetIDQuery()
+	if err != nil {
+		return err
+	}
+	src, err := GetMongoClient(inst.Replicas()[task.SetName])
+	if err != nil {
+		return fmt.Errorf("GetMongoClient failed: %v", err)
+	}
+	tgt, err := GetMongoClient(inst.Target)
+	if err != nil {
+		return fmt.Errorf("GetMongoClient failed: %v", err)
+	}
+	dbName, collName := mdb.SplitNamespace(task.Namespace)
+	source := src.Database(dbName).Collection(collName)
+	dbName, collName = mdb.SplitNamespace(inst.GetToNamespace(task.Namespace))
+	target := tgt.Database(dbName).Collection(collName)
+	if _, err = target.DeleteMany(ctx, query); err != nil {
+		return fmt.Errorf("DeleteMany failed: %v", err)
+	}
+	task.Inserted = 0
+	task.SourceCounts = -1 // copies even if the range was empty when split
+	if err = task.CopyData(ctx, source, target); err != nil {
+		return fmt.Errorf("CopyData failed: %v", err)
+	}
+	return nil
+}
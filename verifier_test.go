@@ -0,0 +1,50 @@
+// Copyright Kuei-chun Chen, 2022-present. All rights reserved.
+
+package hummingbird
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestRangeDigest(t *testing.T) {
+	var docs []bson.Raw
+	for i := 0; i < 3; i++ {
+		doc, err := bson.Marshal(bson.D{{"_id", i}, {"name", "neutrino"}})
+		assertEqual(t, nil, err)
+		docs = append(docs, doc)
+	}
+	var digest, reversed RangeDigest
+	for i := range docs {
+		digest.add(docs[i])
+		reversed.add(docs[len(docs)-1-i])
+	}
+	assertEqual(t, int64(3), digest.Count)
+	assertEqual(t, digest, reversed)
+
+	var partial RangeDigest
+	partial.add(docs[0])
+	partial.add(docs[1])
+	assertNotEqual(t, digest.Hash, partial.Hash)
+}
+
+func TestGetIDQuery(t *testing.T) {
+	task := &Task{IDs: []interface{}{1, 100}}
+	query, err := task.GetIDQuery()
+	assertEqual(t, nil, err)
+	assertEqual(t, 2, len(query))
+
+	task = &Task{IDs: []interface{}{1, 2, 3}, Sampled: true}
+	query, err = task.GetIDQuery()
+	assertEqual(t, nil, err)
+	assertEqual(t, 1, len(query))
+
+	task = &Task{Sampled: true}
+	_, err = task.GetIDQuery()
+	assertNotEqual(t, nil, err)
+
+	task = &Task{IDs: []interface{}{1}}
+	_, err = task.GetIDQuery()
+	assertNotEqual(t, nil, err)
+}
@@ -3,6 +3,7 @@
 package hummingbird
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -25,15 +26,39 @@ const (
 type Chart struct {
 	Title       string
 	Completions [][2]interface{}
+	Consistency []ConsistencyStatus
+	Streams     []StreamStatus
+}
+
+// ConsistencyStatus shows sampled consistency checks of a namespace
+type ConsistencyStatus struct {
+	Namespace string
+	Checked   int64
+	Diverged  int64
+	Rate      string
+}
+
+// StreamStatus shows oplog streaming progress of a replica set
+type StreamStatus struct {
+	SetName  string
+	Headroom string
+	Lag      string
+	Queues   string
+	Warning  string
+	Window   string
 }
 
 // StartWebServer start an http server at port 3629
-func StartWebServer(port int) error {
+func StartWebServer(ctx context.Context, port int) error {
 	http.HandleFunc("/favicon.ico", faviconHandler)
 	http.HandleFunc("/", gox.Cors(handler))
-	addr := fmt.Sprintf(":%d", port)
+	server := &http.Server{Addr: fmt.Sprintf(":%d", port)}
+	go func() {
+		<-ctx.Done()
+		server.Shutdown(context.Background())
+	}()
 	gox.GetLogger("StartWebServer").Infof("starting web server, http://localhost:%v", port)
-	if err := http.ListenAndServe(addr, nil); err != nil {
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		return fmt.Errorf("ListenAndServe failed: %v", err)
 	}
 	return nil
@@ -62,11 +87,8 @@ func handler(w http.ResponseWriter, r *http.Request) {
 		if err != nil {
 			json.NewEncoder(w).Encode(bson.M{"ok": 0, "message": err.Error()})
 		}
-		total := counts.Added + counts.Completed + counts.Failed + counts.Processing + counts.Splitting
-		percent := float64(counts.Completed) / float64(total)
-		elapsed := time.Since(inst.genesis)
-		millis := elapsed.Hours() + elapsed.Minutes() + elapsed.Seconds() + float64(elapsed.Milliseconds())
-		remaining := time.Duration(time.Duration(millis*(1-percent)/percent) * time.Millisecond)
+		percent := counts.GetProgress()
+		remaining := EstimateRemaining(percent, time.Since(inst.genesis))
 		eta := ""
 		if counts.Splitting > 0 {
 			eta = fmt.Sprintf("Splitting %v collection(s)", counts.Splitting)
@@ -82,6 +104,24 @@ func handler(w http.ResponseWriter, r *http.Request) {
 		completions = append(completions, [2]interface{}{"Processing", counts.Processing})
 		completions = append(completions, [2]interface{}{"Splitting", counts.Splitting})
 		chart := Chart{Title: eta, Completions: completions}
+		for _, streamer := range inst.Streamers() {
+			stream := StreamStatus{SetName: streamer.SetName, Lag: streamer.GetLag().String(),
+				Queues: fmt.Sprintf("%v", streamer.GetQueueDepths())}
+			if status := streamer.GetOplogWindowStatus(); status != nil {
+				stream.Headroom = status.Headroom.String()
+				stream.Window = status.Window.String()
+				if status.Status == WindowLost {
+					stream.Warning = "fell off the oplog tail"
+				} else if status.Status == WindowWarning {
+					stream.Warning = "close to the oplog tail"
+				}
+			}
+			chart.Streams = append(chart.Streams, stream)
+		}
+		for _, stats := range inst.Checker().GetAllStats() {
+			chart.Consistency = append(chart.Consistency, ConsistencyStatus{Namespace: stats.Namespace,
+				Checked: stats.Checked, Diverged: stats.Diverged, Rate: fmt.Sprintf("%.1f%%", stats.Rate*100)})
+		}
 	    w.Header().Set("Content-Type", "text/html")
 		templ.Execute(w, chart)
 	}
@@ -269,5 +309,29 @@ const HTMLTemplate = `<!DOCTYPE html>
 </body>
 	<div class='logo'><img src='data:image/png;base64, {{ getLogo }}'/></div>
 	<div id="progress" class='chart_div'></div>
+{{if .Streams}}
+	<div class='logo'>
+	<table>
+		<caption>Oplog Streams</caption>
+		<tr><th>Replica Set</th><th>Lag</th><th>Applier Queues</th><th>Oplog Window</th><th>Headroom</th></tr>
+	{{range .Streams}}
+		<tr><td>{{.SetName}}</td><td>{{.Lag}}</td><td>{{.Queues}}</td><td>{{.Window}}</td>
+			<td>{{.Headroom}}{{if .Warning}} <span style='color:red'>{{.Warning}}</span>{{end}}</td></tr>
+	{{end}}
+	</table>
+	</div>
+{{end}}
+{{if .Consistency}}
+	<div class='logo'>
+	<table>
+		<caption>Consistency Checks</caption>
+		<tr><th>Namespace</th><th>Checked</th><th>Diverged</th><th>Divergence Rate (last 100)</th></tr>
+	{{range .Consistency}}
+		<tr><td>{{.Namespace}}</td><td>{{.Checked}}</td><td>{{.Diverged}}</td>
+			<td>{{if ne .Rate "0.0%"}}<span style='color:red'>{{.Rate}}</span>{{else}}{{.Rate}}{{end}}</td></tr>
+	{{end}}
+	</table>
+	</div>
+{{end}}
 </html>
 `
@@ -3,13 +3,14 @@
 package hummingbird
 
 import (
+	"context"
 	"net/http"
 	"testing"
 	"time"
 )
 
 func TestStartWebServer(t *testing.T) {
-	go StartWebServer(Port)
+	go StartWebServer(context.Background(), Port)
 
 	time.Sleep(1 * time.Second)
 	client := http.Client{Timeout: time.Duration(1) * time.Second}
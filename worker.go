@@ -3,6 +3,7 @@
 package hummingbird
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -12,8 +13,9 @@ import (
 	"golang.org/x/text/message"
 )
 
-// Worker copies data
-func Worker(id string) error {
+// Worker copies data until all are copied or the context is canceled, a task interrupted is returned
+// to added
+func Worker(ctx context.Context, id string) error {
 	inst := GetMigratorInstance()
 	var setNames []string
 	for setName := range inst.Replicas() {
@@ -30,7 +32,7 @@ func Worker(id string) error {
 	processed := 0
 	printer := message.NewPrinter(language.English)
 	btime := time.Now()
-	for !inst.IsExit() {
+	for !inst.IsExit() && ctx.Err() == nil {
 		rev *= -1
 		index++
 		index := index % len(setNames)
@@ -45,14 +47,11 @@ func Worker(id string) error {
 				task.Status = TaskAdded
 				ws.UpdateTask(task)
 			}
-			time.Sleep(10 * time.Second)
+			sleepWithContext(ctx, 10*time.Second)
 			continue
 		}
 		dbName, collName := mdb.SplitNamespace(task.Namespace)
-		dbNameTo, collNameTo := dbName, collName
-		if task.Include.To != "" {
-			dbNameTo, collNameTo = mdb.SplitNamespace(task.Include.To)
-		}
+		dbNameTo, collNameTo := mdb.SplitNamespace(inst.GetToNamespace(task.Namespace))
 		src, err := GetMongoClient(inst.Replicas()[task.SetName])
 		if err != nil {
 			task.Status = TaskAdded
@@ -67,7 +66,7 @@ func Worker(id string) error {
 			time.Sleep(1 * time.Second)
 			continue
 		}
-		if err = task.CopyData(src.Database(dbName).Collection(collName),
+		if err = task.CopyData(ctx, src.Database(dbName).Collection(collName),
 			tgt.Database(dbNameTo).Collection(collNameTo)); err != nil {
 			task.Status = TaskAdded
 		} else {
@@ -81,7 +80,12 @@ func Worker(id string) error {
 			status := printer.Sprintf("[%v] has processed %d tasks", workerID, processed)
 			logger.Info(status)
 		}
-		time.Sleep(100 * time.Millisecond)
+		sleepWithContext(ctx, 100*time.Millisecond)
+	}
+	if ctx.Err() != nil { // claimed but not updated tasks, if any, are returned to added
+		if _, err := ws.ResetWorkerTasks(workerID); err != nil {
+			logger.Errorf(`[%v] ResetWorkerTasks failed: %v`, workerID, err)
+		}
 	}
 	logger.Infof(`[%v] exits`, workerID)
 	return nil
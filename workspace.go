@@ -8,7 +8,6 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
-	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -21,10 +20,18 @@ import (
 )
 
 const (
+	// MetaConflicts defines default meta conflicts collection name
+	MetaConflicts = "conflicts"
+	// MetaConsistency defines default meta consistency collection name
+	MetaConsistency = "consistency"
+	// MetaCutover defines default meta cutover collection name
+	MetaCutover = "cutover"
 	// MetaDBName defines default meta database name
 	MetaDBName = "_neutrino"
 	// MetaLogs defines default meta oplogs collection name
 	MetaLogs = "logs"
+	// MetaMismatches defines default meta mismatches collection name
+	MetaMismatches = "mismatches"
 	// MetaOplogs defines default meta oplogs collection name
 	MetaOplogs = "oplogs"
 	// MetaTasks defines default meta tasks collection name
@@ -61,7 +68,7 @@ func (ws *Workspace) CleanUpWorkspace() error {
 		if err != nil {
 			return err
 		}
-		if strings.HasSuffix(d.Name(), GZippedBSONFileExt) {
+		if IsSpoolFile(d.Name()) || d.Name() == SpoolIndexFile {
 			filenames = append(filenames, s)
 		}
 		return nil
@@ -132,6 +139,23 @@ func (ws *Workspace) Log(status string) error {
 	return err
 }
 
+// AddConflicts adds conflicts to conflicts collection
+func (ws *Workspace) AddConflicts(conflicts []Conflict) error {
+	if len(conflicts) == 0 || ws.dbURI == "" { // replayed oplogs without a workspace
+		return nil
+	}
+	client, err := GetMongoClient(ws.dbURI)
+	if err != nil {
+		return fmt.Errorf("GetMongoClient failed: %v", err)
+	}
+	var docs []interface{}
+	for _, conflict := range conflicts {
+		docs = append(docs, conflict)
+	}
+	_, err = client.Database(MetaDBName).Collection(MetaConflicts).InsertMany(context.Background(), docs)
+	return err
+}
+
 // InsertTasks inserts tasks to database
 func (ws *Workspace) InsertTasks(tasks []*Task) error {
 	client, err := GetMongoClient(ws.dbURI)
@@ -224,7 +248,8 @@ func (ws *Workspace) CountAllStatus() (TaskStatusCounts, error) {
 		}, {
 			"$group": {
 				"_id": "$status", 
-				"count": { "$sum": 1 }
+				"count": { "$sum": 1 },
+				"bytes": { "$sum": "$bytes" }
 			}
 		}
 	]`
@@ -240,10 +265,12 @@ func (ws *Workspace) CountAllStatus() (TaskStatusCounts, error) {
 		if err = cursor.Decode(&doc); err != nil {
 			continue
 		}
+		counts.TotalBytes += ToInt64(doc["bytes"])
 		if doc["_id"] == TaskAdded {
 			counts.Added = ToInt32(doc["count"])
 		} else if doc["_id"] == TaskCompleted {
 			counts.Completed = ToInt32(doc["count"])
+			counts.CompletedBytes = ToInt64(doc["bytes"])
 		} else if doc["_id"] == TaskFailed {
 			counts.Failed = ToInt32(doc["count"])
 		} else if doc["_id"] == TaskProcessing {
@@ -308,6 +335,103 @@ func (ws *Workspace) GetOplogTimestamp(setName string) *primitive.Timestamp {
 	return nil
 }
 
+// SaveCheckpoint updates the position after the last applied oplog, the first oplog of the oldest
+// transaction pending commit, and resume token, if any, of a shard/replica during live streaming
+func (ws *Workspace) SaveCheckpoint(setName string, ts primitive.Timestamp, txnTS *primitive.Timestamp,
+	token bson.Raw) error {
+	client, err := GetMongoClient(ws.dbURI)
+	if err != nil {
+		return fmt.Errorf("GetMongoClient failed: %v", err)
+	}
+	filter := bson.M{"_id": setName}
+	fields := bson.M{"live": true, "ts": ts, "updated": time.Now()}
+	if len(token) > 0 {
+		fields["token"] = token
+	}
+	update := bson.M{"$set": fields}
+	if txnTS != nil {
+		fields["txn_ts"] = *txnTS
+	} else {
+		update["$unset"] = bson.M{"txn_ts": ""}
+	}
+	opts := options.Update()
+	opts.SetUpsert(true)
+	coll := client.Database(MetaDBName).Collection(MetaOplogs)
+	_, err = coll.UpdateOne(context.Background(), filter, update, opts)
+	return err
+}
+
+// GetTxnTimestamp returns the first oplog of the oldest transaction pending commit of a shard/replica
+// at the checkpoint, nil if none
+func (ws *Workspace) GetTxnTimestamp(setName string) *primitive.Timestamp {
+	client, err := GetMongoClient(ws.dbURI)
+	if err != nil {
+		return nil
+	}
+	var doc struct {
+		TxnTS *primitive.Timestamp `bson:"txn_ts"`
+	}
+	coll := client.Database(MetaDBName).Collection(MetaOplogs)
+	if err = coll.FindOne(context.Background(), bson.M{"_id": setName}).Decode(&doc); err != nil {
+		return nil
+	}
+	return doc.TxnTS
+}
+
+// GetReadTimestamp returns the position oplogs of a shard/replica are read from when resuming, the first
+// oplog of the oldest transaction pending commit if any
+func (ws *Workspace) GetReadTimestamp(setName string) *primitive.Timestamp {
+	ts := ws.GetOplogTimestamp(setName)
+	if txnTS := ws.GetTxnTimestamp(setName); ts != nil && txnTS != nil && primitive.CompareTimestamp(*txnTS, *ts) < 0 {
+		return txnTS
+	}
+	return ts
+}
+
+// IsLiveStreaming returns true if a shard/replica has checkpoints of live streaming
+func (ws *Workspace) IsLiveStreaming(setName string) bool {
+	client, err := GetMongoClient(ws.dbURI)
+	if err != nil {
+		return false
+	}
+	filter := bson.M{"_id": setName, "live": true}
+	coll := client.Database(MetaDBName).Collection(MetaOplogs)
+	count, err := coll.CountDocuments(context.Background(), filter)
+	return err == nil && count > 0
+}
+
+// SaveResumeToken updates change stream resume token of a stream
+func (ws *Workspace) SaveResumeToken(setName string, token bson.Raw) error {
+	client, err := GetMongoClient(ws.dbURI)
+	if err != nil {
+		return fmt.Errorf("GetMongoClient failed: %v", err)
+	}
+	filter := bson.M{"_id": setName}
+	update := bson.M{"$set": bson.M{"token": token}}
+	opts := options.Update()
+	opts.SetUpsert(true)
+	coll := client.Database(MetaDBName).Collection(MetaOplogs)
+	_, err = coll.UpdateOne(context.Background(), filter, update, opts)
+	return err
+}
+
+// GetResumeToken returns change stream resume token of a stream
+func (ws *Workspace) GetResumeToken(setName string) bson.Raw {
+	client, err := GetMongoClient(ws.dbURI)
+	if err != nil {
+		return nil
+	}
+	filter := bson.M{"_id": setName}
+	coll := client.Database(MetaDBName).Collection(MetaOplogs)
+	var doc struct {
+		Token bson.Raw `bson:"token"`
+	}
+	if err = coll.FindOne(context.Background(), filter).Decode(&doc); err != nil {
+		return nil
+	}
+	return doc.Token
+}
+
 // FindAllParentTasks returns task by replica a set name
 func (ws *Workspace) FindAllParentTasks() ([]*Task, error) {
 	client, err := GetMongoClient(ws.dbURI)
@@ -330,6 +454,54 @@ func (ws *Workspace) FindAllParentTasks() ([]*Task, error) {
 	return tasks, nil
 }
 
+// FindCompletedSubTasks returns all completed tasks of _id ranges or sampled _id
+func (ws *Workspace) FindCompletedSubTasks() ([]*Task, error) {
+	client, err := GetMongoClient(ws.dbURI)
+	if err != nil {
+		return nil, fmt.Errorf("GetMongoClient failed: %v", err)
+	}
+	ctx := context.Background()
+	var tasks = []*Task{}
+	filter := bson.D{{"parent_id", bson.M{"$ne": nil}}, {"status", TaskCompleted}}
+	coll := client.Database(MetaDBName).Collection(MetaTasks)
+	cursor, err := coll.Find(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("find %v failed: %v", filter, err)
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var task Task
+		if err = bson.Unmarshal(cursor.Current, &task); err != nil {
+			return nil, fmt.Errorf("Unmarshal failed: %v", err)
+		}
+		tasks = append(tasks, &task)
+	}
+	return tasks, nil
+}
+
+// SaveMismatch inserts or replaces a mismatch
+func (ws *Workspace) SaveMismatch(mismatch *Mismatch) error {
+	client, err := GetMongoClient(ws.dbURI)
+	if err != nil {
+		return fmt.Errorf("GetMongoClient failed: %v", err)
+	}
+	mismatch.UpdatedTime = time.Now()
+	opts := options.Replace()
+	opts.SetUpsert(true)
+	coll := client.Database(MetaDBName).Collection(MetaMismatches)
+	_, err = coll.ReplaceOne(context.Background(), bson.M{"_id": mismatch.ID}, mismatch, opts)
+	return err
+}
+
+// DropMismatches drops mismatches of previous verifications
+func (ws *Workspace) DropMismatches() error {
+	client, err := GetMongoClient(ws.dbURI)
+	if err != nil {
+		return fmt.Errorf("GetMongoClient failed: %v", err)
+	}
+	return client.Database(MetaDBName).Collection(MetaMismatches).Drop(context.Background())
+}
+
 // ResetParentTask resets and deletes all child tasks
 func (ws *Workspace) ResetParentTask(task Task) error {
 	client, err := GetMongoClient(ws.dbURI)
@@ -349,6 +521,82 @@ func (ws *Workspace) ResetParentTask(task Task) error {
 	return nil
 }
 
+// RequestCutover saves a cutover request, it fails if a cutover is in progress
+func (ws *Workspace) RequestCutover() error {
+	client, err := GetMongoClient(ws.dbURI)
+	if err != nil {
+		return fmt.Errorf("GetMongoClient failed: %v", err)
+	}
+	if report, err := ws.GetCutoverReport(); err == nil && report.Status != CutoverCompleted &&
+		report.Status != CutoverFailed {
+		return fmt.Errorf("cutover is %v", report.Status)
+	}
+	report := CutoverReport{ID: MetaCutover, RequestedTime: time.Now(), Status: CutoverRequested}
+	opts := options.Replace()
+	opts.SetUpsert(true)
+	coll := client.Database(MetaDBName).Collection(MetaCutover)
+	_, err = coll.ReplaceOne(context.Background(), bson.M{"_id": MetaCutover}, report, opts)
+	return err
+}
+
+// GetCutoverReport returns the cutover report
+func (ws *Workspace) GetCutoverReport() (*CutoverReport, error) {
+	client, err := GetMongoClient(ws.dbURI)
+	if err != nil {
+		return nil, fmt.Errorf("GetMongoClient failed: %v", err)
+	}
+	var report CutoverReport
+	coll := client.Database(MetaDBName).Collection(MetaCutover)
+	if err = coll.FindOne(context.Background(), bson.M{"_id": MetaCutover}).Decode(&report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// SaveCutoverReport updates the cutover report
+func (ws *Workspace) SaveCutoverReport(report *CutoverReport) error {
+	client, err := GetMongoClient(ws.dbURI)
+	if err != nil {
+		return fmt.Errorf("GetMongoClient failed: %v", err)
+	}
+	report.ID = MetaCutover
+	opts := options.Replace()
+	opts.SetUpsert(true)
+	coll := client.Database(MetaDBName).Collection(MetaCutover)
+	_, err = coll.ReplaceOne(context.Background(), bson.M{"_id": MetaCutover}, report, opts)
+	return err
+}
+
+// SaveConsistencyStats inserts or replaces consistency stats of a namespace
+func (ws *Workspace) SaveConsistencyStats(stats ConsistencyStats) error {
+	client, err := GetMongoClient(ws.dbURI)
+	if err != nil {
+		return fmt.Errorf("GetMongoClient failed: %v", err)
+	}
+	opts := options.Replace()
+	opts.SetUpsert(true)
+	coll := client.Database(MetaDBName).Collection(MetaConsistency)
+	_, err = coll.ReplaceOne(context.Background(), bson.M{"_id": stats.Namespace}, stats, opts)
+	return err
+}
+
+// ResetWorkerTasks returns tasks processing by a worker to added
+func (ws *Workspace) ResetWorkerTasks(workerID string) (int, error) {
+	client, err := GetMongoClient(ws.dbURI)
+	if err != nil {
+		return 0, fmt.Errorf("GetMongoClient failed: %v", err)
+	}
+	ctx := context.Background()
+	coll := client.Database(MetaDBName).Collection(MetaTasks)
+	filter := bson.M{"status": TaskProcessing, "updated_by": workerID}
+	updates := bson.M{"$set": bson.M{"status": TaskAdded, "begin_time": time.Time{}}}
+	result, err := coll.UpdateMany(ctx, filter, updates)
+	if err != nil {
+		return 0, fmt.Errorf("UpdateMany failed: %v", err)
+	}
+	return int(result.ModifiedCount), nil
+}
+
 // ResetProcessingTasks resets processing status to added
 func (ws *Workspace) ResetProcessingTasks() error {
 	client, err := GetMongoClient(ws.dbURI)
@@ -363,3 +611,29 @@ func (ws *Workspace) ResetProcessingTasks() error {
 	}
 	return nil
 }
+
+// GetSampledIDs returns all sampled _id of a namespace
+func (ws *Workspace) GetSampledIDs(namespace string) ([]interface{}, error) {
+	client, err := GetMongoClient(ws.dbURI)
+	if err != nil {
+		return nil, fmt.Errorf("GetMongoClient failed: %v", err)
+	}
+	ctx := context.Background()
+	ids := []interface{}{}
+	coll := client.Database(MetaDBName).Collection(MetaTasks)
+	opts := options.Find()
+	opts.SetProjection(bson.D{{"ids", 1}})
+	cursor, err := coll.Find(ctx, bson.D{{"ns", namespace}, {"sampled", true}}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("Find failed: %v", err)
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var task Task
+		if err = bson.Unmarshal(cursor.Current, &task); err != nil {
+			return nil, fmt.Errorf("Unmarshal failed: %v", err)
+		}
+		ids = append(ids, task.IDs...)
+	}
+	return ids, nil
+}